@@ -0,0 +1,199 @@
+package backrest
+
+import (
+	"cmp"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Indicators of backups compared with previous backups of the same type.
+const (
+	anomalySize     = "size"
+	anomalyDelta    = "delta"
+	anomalyDuration = "duration"
+)
+
+var (
+	// Number of standard deviations between the latest backup value
+	// and the mean value of previous backups.
+	pgbrStanzaBackupAnomalyZScoreMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_anomaly_zscore",
+		Help: "Z-score of the latest backup size, delta or duration relative to previous backups of the same type.",
+	},
+		[]string{
+			"backup_type",
+			"indicator",
+			"repo_key",
+			"stanza"})
+	pgbrStanzaBackupAnomalyRatioMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_anomaly_ratio_to_median",
+		Help: "Ratio of the latest backup size, delta or duration to the median of previous backups of the same type.",
+	},
+		[]string{
+			"backup_type",
+			"indicator",
+			"repo_key",
+			"stanza"})
+)
+
+// anomalyWindowKey identifies backups compared with each other.
+type anomalyWindowKey struct {
+	stanza     string
+	repoKey    int
+	backupType string
+}
+
+// anomalySample contains values of backup compared with previous backups.
+type anomalySample struct {
+	label    string
+	start    int64
+	size     float64
+	delta    float64
+	duration float64
+}
+
+// backupAnomalySamples contains backups between collections,
+// so backups removed by expire remain in the window.
+type backupAnomalySamples struct {
+	mu      sync.Mutex
+	samples map[anomalyWindowKey][]anomalySample
+}
+
+// Samples of all stanzas for anomaly detection.
+var backupAnomalySamplesStore = &backupAnomalySamples{samples: make(map[anomalyWindowKey][]anomalySample)}
+
+// add records backups not seen before and keeps the latest backup and up to window previous backups.
+// Returns samples sorted by backup start time.
+func (store *backupAnomalySamples) add(key anomalyWindowKey, backups []backup, window int) []anomalySample {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	samples := store.samples[key]
+	for _, backup := range backups {
+		if slices.ContainsFunc(samples, func(sample anomalySample) bool { return sample.label == backup.Label }) {
+			continue
+		}
+		samples = append(samples, anomalySample{
+			label:    backup.Label,
+			start:    backup.Timestamp.Start,
+			size:     float64(backup.Info.Size),
+			delta:    float64(backup.Info.Delta),
+			duration: float64(backup.Timestamp.Stop - backup.Timestamp.Start),
+		})
+	}
+	slices.SortFunc(samples, func(a, b anomalySample) int {
+		return cmp.Or(cmp.Compare(a.start, b.start), strings.Compare(a.label, b.label))
+	})
+	samples = samples[max(0, len(samples)-1-window):]
+	store.samples[key] = samples
+	return slices.Clone(samples)
+}
+
+// getBackupAnomalyMetrics compares the latest backup of each type in each repo
+// with up to window previous backups of the same type.
+// Backups are kept in store between collections, so the window isn't limited by retention settings.
+func getBackupAnomalyMetrics(store *backupAnomalySamples, stanzaName string, window int, backupData []backup, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	groups := make(map[anomalyWindowKey][]backup)
+	for _, backup := range backupData {
+		key := anomalyWindowKey{stanzaName, backup.Database.RepoKey, backup.Type}
+		groups[key] = append(groups[key], backup)
+	}
+	for key, backups := range groups {
+		samples := store.add(key, backups, window)
+		latest := samples[len(samples)-1]
+		previous := samples[:len(samples)-1]
+		if len(previous) == 0 {
+			continue
+		}
+		for _, indicator := range []struct {
+			name  string
+			value func(anomalySample) float64
+		}{
+			{anomalySize, func(s anomalySample) float64 { return s.size }},
+			{anomalyDelta, func(s anomalySample) float64 { return s.delta }},
+			{anomalyDuration, func(s anomalySample) float64 { return s.duration }},
+		} {
+			values := make([]float64, 0, len(previous))
+			for _, sample := range previous {
+				values = append(values, indicator.value(sample))
+			}
+			labels := []string{
+				key.backupType,
+				indicator.name,
+				strconv.Itoa(key.repoKey),
+				stanzaName,
+			}
+			value := indicator.value(latest)
+			if zScore, ok := getZScore(value, values); ok {
+				setUpMetric(
+					pgbrStanzaBackupAnomalyZScoreMetric,
+					"pgbackrest_backup_anomaly_zscore",
+					zScore,
+					setUpMetricValueFun,
+					logger,
+					labels...,
+				)
+			}
+			if ratio, ok := getRatioToMedian(value, values); ok {
+				setUpMetric(
+					pgbrStanzaBackupAnomalyRatioMetric,
+					"pgbackrest_backup_anomaly_ratio_to_median",
+					ratio,
+					setUpMetricValueFun,
+					logger,
+					labels...,
+				)
+			}
+		}
+	}
+}
+
+// getZScore returns the number of standard deviations between value and the mean of values.
+// At least two values with non-zero standard deviation are required.
+func getZScore(value float64, values []float64) (float64, bool) {
+	if len(values) < 2 {
+		return 0, false
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	stdDev := math.Sqrt(variance / float64(len(values)))
+	if stdDev == 0 {
+		return 0, false
+	}
+	return (value - mean) / stdDev, true
+}
+
+// getRatioToMedian returns the ratio of value to the median of values.
+// Median must be non-zero.
+func getRatioToMedian(value float64, values []float64) (float64, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	sorted := slices.Sorted(slices.Values(values))
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+	if median == 0 {
+		return 0, false
+	}
+	return value / median, true
+}
+
+func resetBackupAnomalyMetrics() {
+	pgbrStanzaBackupAnomalyZScoreMetric.Reset()
+	pgbrStanzaBackupAnomalyRatioMetric.Reset()
+}
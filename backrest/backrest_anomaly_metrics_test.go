@@ -0,0 +1,152 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestGetBackupAnomalyMetrics(t *testing.T) {
+	// The first full backup is out of window.
+	// For duration of previous full backups standard deviation is 0, so z-score is not set.
+	// Incremental backup and backup in repo2 have no previous backups.
+	templateMetrics := `# HELP pgbackrest_backup_anomaly_ratio_to_median Ratio of the latest backup size, delta or duration to the median of previous backups of the same type.
+# TYPE pgbackrest_backup_anomaly_ratio_to_median gauge
+pgbackrest_backup_anomaly_ratio_to_median{backup_type="full",indicator="delta",repo_key="1",stanza="demo"} 2.5
+pgbackrest_backup_anomaly_ratio_to_median{backup_type="full",indicator="duration",repo_key="1",stanza="demo"} 3
+pgbackrest_backup_anomaly_ratio_to_median{backup_type="full",indicator="size",repo_key="1",stanza="demo"} 0.6
+# HELP pgbackrest_backup_anomaly_zscore Z-score of the latest backup size, delta or duration relative to previous backups of the same type.
+# TYPE pgbackrest_backup_anomaly_zscore gauge
+pgbackrest_backup_anomaly_zscore{backup_type="full",indicator="delta",repo_key="1",stanza="demo"} 3
+pgbackrest_backup_anomaly_zscore{backup_type="full",indicator="size",repo_key="1",stanza="demo"} -4
+`
+	backupData := parseTemplateBackups(`[` +
+		templateAnomalyBackup("20210607-092423F", "full", 1, 500, 500, 1623057800, 1623057900) + `,` +
+		templateAnomalyBackup("20210608-092423F", "full", 1, 90, 10, 1623144200, 1623144300) + `,` +
+		templateAnomalyBackup("20210608-092423F", "full", 2, 90, 10, 1623144200, 1623144300) + `,` +
+		templateAnomalyBackup("20210609-092423F", "full", 1, 110, 30, 1623230600, 1623230700) + `,` +
+		templateAnomalyBackup("20210609-092423F_20210609-102423I", "incr", 1, 110, 5, 1623234200, 1623234210) + `,` +
+		templateAnomalyBackup("20210610-092423F", "full", 1, 60, 50, 1623317000, 1623317300) + `]`)
+	resetBackupAnomalyMetrics()
+	store := &backupAnomalySamples{samples: make(map[anomalyWindowKey][]anomalySample)}
+	getBackupAnomalyMetrics(store, "demo", 2, backupData, setUpMetricValue, logger)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrStanzaBackupAnomalyZScoreMetric,
+		pgbrStanzaBackupAnomalyRatioMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
+// Retention keeps 2 full backups, window is 3 backups.
+// Expired backups stay in the window between collections.
+func TestGetBackupAnomalyMetricsExpiredBackups(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_backup_anomaly_ratio_to_median Ratio of the latest backup size, delta or duration to the median of previous backups of the same type.
+# TYPE pgbackrest_backup_anomaly_ratio_to_median gauge
+pgbackrest_backup_anomaly_ratio_to_median{backup_type="full",indicator="delta",repo_key="1",stanza="demo"} 2.5
+pgbackrest_backup_anomaly_ratio_to_median{backup_type="full",indicator="duration",repo_key="1",stanza="demo"} 3
+pgbackrest_backup_anomaly_ratio_to_median{backup_type="full",indicator="size",repo_key="1",stanza="demo"} 0.6
+`
+	backups := []string{
+		templateAnomalyBackup("20210607-092423F", "full", 1, 100, 10, 1623057800, 1623057900),
+		templateAnomalyBackup("20210608-092423F", "full", 1, 110, 30, 1623144200, 1623144300),
+		templateAnomalyBackup("20210609-092423F", "full", 1, 90, 20, 1623230600, 1623230700),
+		templateAnomalyBackup("20210610-092423F", "full", 1, 60, 50, 1623317000, 1623317300),
+	}
+	store := &backupAnomalySamples{samples: make(map[anomalyWindowKey][]anomalySample)}
+	for i := 0; i < len(backups)-1; i++ {
+		resetBackupAnomalyMetrics()
+		getBackupAnomalyMetrics(store, "demo", 3, parseTemplateBackups(`[`+backups[i]+`,`+backups[i+1]+`]`), setUpMetricValue, logger)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrStanzaBackupAnomalyRatioMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+	// The oldest backup is removed from the store when it's out of window.
+	samples := store.add(anomalyWindowKey{"demo", 1, "full"}, parseTemplateBackups(`[`+
+		templateAnomalyBackup("20210611-092423F", "full", 1, 100, 10, 1623403400, 1623403500)+`]`), 3)
+	if got := samples[0].label; got != "20210608-092423F" || len(samples) != 4 {
+		t.Errorf("\nVariables do not match:\n%s %d\nwant:\n%s %d", got, len(samples), "20210608-092423F", 4)
+	}
+}
+
+func TestGetRatioToMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  float64
+		values []float64
+		want   float64
+		wantOK bool
+	}{
+		{"odd", 30, []float64{30, 10, 20}, 1.5, true},
+		{"even", 50, []float64{40, 10, 20, 30}, 2, true},
+		{"empty", 50, nil, 0, false},
+		{"zeroMedian", 50, []float64{0, 0, 10}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := getRatioToMedian(tt.value, tt.values)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("\nVariables do not match:\n%v %v\nwant:\n%v %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetZScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  float64
+		values []float64
+		want   float64
+		wantOK bool
+	}{
+		{"zscore", 130, []float64{90, 110}, 3, true},
+		{"oneValue", 130, []float64{90}, 0, false},
+		{"zeroStdDev", 130, []float64{90, 90}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := getZScore(tt.value, tt.values)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("\nVariables do not match:\n%v %v\nwant:\n%v %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func templateAnomalyBackup(label, backupType string, repoKey int, size, delta, start, stop int64) string {
+	return fmt.Sprintf(
+		`{"label":"%s","type":"%s","database":{"id":1,"repo-key":%d},"error":false,`+
+			`"info":{"delta":%d,"repository":{"delta":%d,"size":%d},"size":%d},`+
+			`"timestamp":{"start":%d,"stop":%d}}`,
+		label, backupType, repoKey, delta, delta, size, size, start, stop)
+}
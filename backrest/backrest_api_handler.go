@@ -0,0 +1,298 @@
+package backrest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the path prefix for JSON API.
+const apiPrefix = "/api/v1"
+
+// The structures below are normalized representation of pgBackRest data for JSON API.
+// Field names use snake_case, absent values are returned as null.
+type apiStanza struct {
+	Name        string        `json:"name"`
+	Cipher      string        `json:"cipher"`
+	Status      apiStatus     `json:"status"`
+	Repos       []apiRepo     `json:"repos"`
+	Databases   []apiDatabase `json:"databases"`
+	BackupCount int           `json:"backup_count"`
+}
+
+type apiStatus struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Backup  apiLockStatus `json:"backup_lock"`
+	Restore apiLockStatus `json:"restore_lock"`
+}
+
+type apiLockStatus struct {
+	Held         bool   `json:"held"`
+	SizeTotal    *int64 `json:"size_bytes"`
+	SizeComplete *int64 `json:"size_complete_bytes"`
+}
+
+type apiRepo struct {
+	Key     int    `json:"key"`
+	Cipher  string `json:"cipher"`
+	Code    int    `json:"status_code"`
+	Message string `json:"status_message"`
+}
+
+type apiDatabase struct {
+	ID       int    `json:"id"`
+	RepoKey  int    `json:"repo_key"`
+	SystemID int64  `json:"system_id"`
+	Version  string `json:"version"`
+}
+
+type apiBackup struct {
+	Label             string            `json:"label"`
+	Type              string            `json:"type"`
+	Prior             string            `json:"prior"`
+	Reference         []string          `json:"reference"`
+	RepoKey           int               `json:"repo_key"`
+	DatabaseID        int               `json:"database_id"`
+	PGVersion         string            `json:"pg_version"`
+	BackrestVersion   string            `json:"backrest_version"`
+	StartTime         time.Time         `json:"start_time"`
+	StopTime          time.Time         `json:"stop_time"`
+	DurationSeconds   float64           `json:"duration_seconds"`
+	Error             *bool             `json:"error"`
+	BlockIncremental  bool              `json:"block_incremental"`
+	Annotations       map[string]string `json:"annotations"`
+	LSNStart          string            `json:"lsn_start"`
+	LSNStop           string            `json:"lsn_stop"`
+	WALStart          string            `json:"wal_start"`
+	WALStop           string            `json:"wal_stop"`
+	SizeBytes         int64             `json:"size_bytes"`
+	DeltaBytes        int64             `json:"delta_bytes"`
+	RepoSizeBytes     *int64            `json:"repo_size_bytes"`
+	RepoDeltaBytes    int64             `json:"repo_delta_bytes"`
+	RepoSizeMapBytes  *int64            `json:"repo_size_map_bytes"`
+	RepoDeltaMapBytes *int64            `json:"repo_delta_map_bytes"`
+}
+
+type apiArchive struct {
+	ID         string `json:"id"`
+	DatabaseID int    `json:"database_id"`
+	RepoKey    int    `json:"repo_key"`
+	PGVersion  string `json:"pg_version"`
+	WALMin     string `json:"wal_min"`
+	WALMax     string `json:"wal_max"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func convertStanzaToAPI(stanzaData stanza) apiStanza {
+	result := apiStanza{
+		Name:   stanzaData.Name,
+		Cipher: stanzaData.Cipher,
+		Status: apiStatus{
+			Code:    stanzaData.Status.Code,
+			Message: stanzaData.Status.Message,
+			Backup: apiLockStatus{
+				Held:         stanzaData.Status.Lock.Backup.Held,
+				SizeTotal:    stanzaData.Status.Lock.Backup.SizeTotal,
+				SizeComplete: stanzaData.Status.Lock.Backup.SizeComplete,
+			},
+			Restore: apiLockStatus{
+				Held:         stanzaData.Status.Lock.Restore.Held,
+				SizeTotal:    stanzaData.Status.Lock.Restore.SizeTotal,
+				SizeComplete: stanzaData.Status.Lock.Restore.SizeComplete,
+			},
+		},
+		Repos:       []apiRepo{},
+		Databases:   make([]apiDatabase, 0, len(stanzaData.DB)),
+		BackupCount: len(stanzaData.Backup),
+	}
+	// For pgBackRest < v2.32 repo info is not available.
+	if stanzaData.Repo != nil {
+		for _, repo := range *stanzaData.Repo {
+			result.Repos = append(result.Repos, apiRepo{
+				Key:     repo.Key,
+				Cipher:  repo.Cipher,
+				Code:    repo.Status.Code,
+				Message: repo.Status.Message,
+			})
+		}
+	}
+	for _, db := range stanzaData.DB {
+		result.Databases = append(result.Databases, apiDatabase(db))
+	}
+	return result
+}
+
+func convertBackupToAPI(backupData backup, dbData []db) apiBackup {
+	reference := make([]string, 0, len(backupData.Reference))
+	for _, ref := range backupData.Reference {
+		if ref != "" {
+			reference = append(reference, ref)
+		}
+	}
+	var annotations map[string]string
+	if backupData.Annotation != nil {
+		annotations = *backupData.Annotation
+	}
+	start := time.Unix(backupData.Timestamp.Start, 0).UTC()
+	stop := time.Unix(backupData.Timestamp.Stop, 0).UTC()
+	return apiBackup{
+		Label:             backupData.Label,
+		Type:              backupData.Type,
+		Prior:             backupData.Prior,
+		Reference:         reference,
+		RepoKey:           backupData.Database.RepoKey,
+		DatabaseID:        backupData.Database.ID,
+		PGVersion:         getPGVersion(backupData.Database.ID, backupData.Database.RepoKey, dbData),
+		BackrestVersion:   backupData.BackrestInfo.Version,
+		StartTime:         start,
+		StopTime:          stop,
+		DurationSeconds:   stop.Sub(start).Seconds(),
+		Error:             backupData.Error,
+		BlockIncremental:  backupData.checkBackupIncremental() == "y",
+		Annotations:       annotations,
+		LSNStart:          backupData.Lsn.StartLSN,
+		LSNStop:           backupData.Lsn.StopLSN,
+		WALStart:          backupData.Archive.StartWAL,
+		WALStop:           backupData.Archive.StopWAL,
+		SizeBytes:         backupData.Info.Size,
+		DeltaBytes:        backupData.Info.Delta,
+		RepoSizeBytes:     backupData.Info.Repository.Size,
+		RepoDeltaBytes:    backupData.Info.Repository.Delta,
+		RepoSizeMapBytes:  backupData.Info.Repository.SizeMap,
+		RepoDeltaMapBytes: backupData.Info.Repository.DeltaMap,
+	}
+}
+
+func convertArchiveToAPI(archiveData archive, dbData []db) apiArchive {
+	return apiArchive{
+		ID:         archiveData.PGVersion,
+		DatabaseID: archiveData.Database.ID,
+		RepoKey:    archiveData.Database.RepoKey,
+		PGVersion:  getPGVersion(archiveData.Database.ID, archiveData.Database.RepoKey, dbData),
+		WALMin:     archiveData.WALMin,
+		WALMax:     archiveData.WALMax,
+	}
+}
+
+// apiStanzasHandler returns the list of stanzas.
+func apiStanzasHandler(logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := loadSnapshot()
+		if snapshot == nil {
+			writeAPIResponse(w, r, http.StatusServiceUnavailable, apiError{"no data collected from pgBackRest yet"}, time.Time{}, logger)
+			return
+		}
+		result := make([]apiStanza, 0, len(snapshot.stanzas))
+		for _, stanzaData := range snapshot.stanzas {
+			result = append(result, convertStanzaToAPI(stanzaData))
+		}
+		writeAPIResponse(w, r, http.StatusOK, result, snapshot.collectedAt, logger)
+	})
+}
+
+// apiBackupsHandler returns the list of backups for stanza.
+func apiBackupsHandler(logger *slog.Logger) http.Handler {
+	return apiStanzaHandler(logger, func(stanzaData stanza) any {
+		result := make([]apiBackup, 0, len(stanzaData.Backup))
+		for _, backupData := range stanzaData.Backup {
+			result = append(result, convertBackupToAPI(backupData, stanzaData.DB))
+		}
+		return result
+	})
+}
+
+// apiArchiveHandler returns the list of WAL archives for stanza.
+func apiArchiveHandler(logger *slog.Logger) http.Handler {
+	return apiStanzaHandler(logger, func(stanzaData stanza) any {
+		result := make([]apiArchive, 0, len(stanzaData.Archive))
+		for _, archiveData := range stanzaData.Archive {
+			result = append(result, convertArchiveToAPI(archiveData, stanzaData.DB))
+		}
+		return result
+	})
+}
+
+// apiStanzaHandler finds stanza from the path and writes the result of convert function.
+func apiStanzaHandler(logger *slog.Logger, convert func(stanzaData stanza) any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := loadSnapshot()
+		if snapshot == nil {
+			writeAPIResponse(w, r, http.StatusServiceUnavailable, apiError{"no data collected from pgBackRest yet"}, time.Time{}, logger)
+			return
+		}
+		stanzaName := r.PathValue("name")
+		stanzaData, ok := snapshot.getStanza(stanzaName)
+		if !ok {
+			writeAPIResponse(w, r, http.StatusNotFound, apiError{fmt.Sprintf("stanza %q not found", stanzaName)}, time.Time{}, logger)
+			return
+		}
+		writeAPIResponse(w, r, http.StatusOK, convert(stanzaData), snapshot.collectedAt, logger)
+	})
+}
+
+// writeAPIResponse writes value as JSON.
+// For successful responses ETag header is set from the hash of the body,
+// if it matches If-None-Match header, 304 status is returned without body.
+func writeAPIResponse(w http.ResponseWriter, r *http.Request, code int, value any, lastModified time.Time, logger *slog.Logger) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		logger.Error("Encode JSON failed", "err", err)
+		http.Error(w, "Encode JSON failed", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	if code == http.StatusOK {
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if !lastModified.IsZero() {
+			w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(code)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		logger.Error("Write response failed", "err", err)
+	}
+}
+
+// etagMatch reports whether If-None-Match header value matches ETag.
+// Weak comparison is used, see RFC 9110, section 13.1.2.
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, value := range strings.Split(ifNoneMatch, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// apiEndpoints returns JSON API handlers by route patterns.
+func apiEndpoints(logger *slog.Logger) map[string]http.Handler {
+	return map[string]http.Handler{
+		"GET " + apiPrefix + "/stanzas":                apiStanzasHandler(logger),
+		"GET " + apiPrefix + "/stanzas/{name}/backups": apiBackupsHandler(logger),
+		"GET " + apiPrefix + "/stanzas/{name}/archive": apiArchiveHandler(logger),
+	}
+}
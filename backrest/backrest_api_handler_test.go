@@ -0,0 +1,161 @@
+package backrest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		snapshot   *collectionSnapshot
+		path       string
+		wantCode   int
+		wantString []string
+	}{
+		{
+			"apiStanzasNoData",
+			nil,
+			"/stanzas",
+			http.StatusServiceUnavailable,
+			[]string{`{"error":"no data collected from pgBackRest yet"}`},
+		},
+		{
+			"apiStanzas",
+			templateAPISnapshot(),
+			"/stanzas",
+			http.StatusOK,
+			[]string{
+				`"name":"demo"`,
+				`"status":{"code":0,"message":"ok","backup_lock":{"held":false,"size_bytes":0,"size_complete_bytes":0}`,
+				`"repos":[{"key":1,"cipher":"none","status_code":0,"status_message":"ok"}]`,
+				`"databases":[{"id":1,"repo_key":1,"system_id":6970977677138971135,"version":"13"}]`,
+				`"backup_count":1`,
+			},
+		},
+		{
+			"apiBackups",
+			templateAPISnapshot(),
+			"/stanzas/demo/backups",
+			http.StatusOK,
+			[]string{
+				`"label":"20210607-092423F"`,
+				`"reference":[]`,
+				`"pg_version":"13"`,
+				`"start_time":"2021-06-07T09:24:23Z","stop_time":"2021-06-07T09:24:26Z","duration_seconds":3`,
+				`"error":true,"block_incremental":true,"annotations":{"testkey":"testvalue"}`,
+				`"repo_size_bytes":null`,
+			},
+		},
+		{
+			"apiArchive",
+			templateAPISnapshot(),
+			"/stanzas/demo/archive",
+			http.StatusOK,
+			[]string{`[{"id":"13-1","database_id":1,"repo_key":1,"pg_version":"13","wal_min":"000000010000000000000001","wal_max":"000000010000000000000004"}]`},
+		},
+		{
+			"apiBackupsStanzaNotFound",
+			templateAPISnapshot(),
+			"/stanzas/demo2/backups",
+			http.StatusNotFound,
+			[]string{`{"error":"stanza \"demo2\" not found"}`},
+		},
+	}
+	mux := http.NewServeMux()
+	for pattern, handler := range apiEndpoints(logger) {
+		mux.Handle(pattern, handler)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeSnapshot(tt.snapshot)
+			defer storeSnapshot(nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiPrefix+tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("\nVariables do not match:\n%d\nwant:\n%d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, "application/json")
+			}
+			if !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("\nInvalid JSON:\n%s", rec.Body.String())
+			}
+			for _, want := range tt.wantString {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("\nVariable do not match:\n%s\nwant:\n%s", want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAPIHandlersETag(t *testing.T) {
+	storeSnapshot(templateAPISnapshot())
+	defer storeSnapshot(nil)
+	handler := apiStanzasHandler(logger)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiPrefix+"/stanzas", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("\nVariables do not match:\ncode=%d, etag=%s\nwant:\ncode=%d, non-empty etag", rec.Code, etag, http.StatusOK)
+	}
+	if got := rec.Header().Get("Last-Modified"); got != "Mon, 14 Jun 2021 21:32:02 GMT" {
+		t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, "Mon, 14 Jun 2021 21:32:02 GMT")
+	}
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantCode    int
+	}{
+		{"apiETagMatch", etag, http.StatusNotModified},
+		{"apiETagWeakMatch", `"other", W/` + etag, http.StatusNotModified},
+		{"apiETagAny", "*", http.StatusNotModified},
+		{"apiETagNotMatch", `"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, apiPrefix+"/stanzas", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("\nVariables do not match:\n%d\nwant:\n%d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("\nNon-empty body for %d response:\n%s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestConvertStanzaToAPIRepoAbsent(t *testing.T) {
+	got := convertStanzaToAPI(templateStanzaRepoAbsent("000000010000000000000004", "000000010000000000000001", 2969514))
+	if !reflect.DeepEqual(got.Repos, []apiRepo{}) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got.Repos, []apiRepo{})
+	}
+}
+
+func templateAPISnapshot() *collectionSnapshot {
+	snapshot := newCollectionSnapshot(time.Unix(1623706322, 0))
+	snapshot.addStanza(templateStanza(
+		"000000010000000000000004",
+		"000000010000000000000001",
+		[]databaseRef{{"postgres", 13425}},
+		true,
+		false,
+		false,
+		12,
+		100,
+		0,
+		0,
+		0,
+		0,
+		annotation{"testkey": "testvalue"}))
+	return snapshot
+}
@@ -0,0 +1,270 @@
+package backrest
+
+import (
+	"log/slog"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Number of hops from the backup to the full backup it is based on.
+	// For full backups the value is 0.
+	pgbrStanzaBackupChainDepthMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_chain_depth",
+		Help: "Number of backups between the backup and the full backup in its chain.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+	// Number of backups (directly or transitively) based on the backup.
+	// All of them become unrestorable if the backup is removed.
+	pgbrStanzaBackupChainDependentsMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_chain_dependents",
+		Help: "Number of backups that would be unrestorable if the backup was removed.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+	pgbrStanzaBackupChainBrokenMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_chain_broken_status",
+		Help: "Backup chain broken status.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+	// Sum of "backup":"info":"repository":"delta" for the backup
+	// and all backups it is based on.
+	pgbrStanzaBackupChainRepoSizeMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_chain_repo_size_bytes",
+		Help: "Compressed files size in all backups of the chain required to restore the database from backup.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+)
+
+// backupChainKey identifies backup in the dependency graph.
+// The same backup label can exist in several repositories,
+// so the graph is built separately for each repo.
+type backupChainKey struct {
+	repoKey int
+	label   string
+}
+
+// backupChainNode is a backup in the dependency graph.
+type backupChainNode struct {
+	backup   backup
+	parent   *backupChainNode
+	children []*backupChainNode
+	// missingRefs contains labels from 'prior' and 'reference' fields
+	// for which there are no backups in the repo.
+	missingRefs []string
+}
+
+// backupChain is a dependency graph of backups for one stanza.
+// Graph is built from 'prior' field, 'reference' field is used
+// only to check that all referenced backups exist.
+type backupChain struct {
+	nodes map[backupChainKey]*backupChainNode
+	// Nodes in the order of pgBackRest output.
+	order []*backupChainNode
+}
+
+func buildBackupChain(backupData []backup) *backupChain {
+	chain := &backupChain{
+		nodes: make(map[backupChainKey]*backupChainNode, len(backupData)),
+		order: make([]*backupChainNode, 0, len(backupData)),
+	}
+	for _, backup := range backupData {
+		node := &backupChainNode{backup: backup}
+		chain.nodes[backupChainKey{backup.Database.RepoKey, backup.Label}] = node
+		chain.order = append(chain.order, node)
+	}
+	for _, node := range chain.order {
+		repoKey := node.backup.Database.RepoKey
+		if node.backup.Prior != "" {
+			parent, ok := chain.nodes[backupChainKey{repoKey, node.backup.Prior}]
+			if ok && parent != node {
+				node.parent = parent
+				parent.children = append(parent.children, node)
+			} else {
+				node.missingRefs = append(node.missingRefs, node.backup.Prior)
+			}
+		}
+		for _, ref := range node.backup.Reference {
+			// For backups without references pgBackRest can return empty values.
+			if ref == "" || ref == node.backup.Prior {
+				continue
+			}
+			if _, ok := chain.nodes[backupChainKey{repoKey, ref}]; !ok {
+				node.missingRefs = append(node.missingRefs, ref)
+			}
+		}
+	}
+	return chain
+}
+
+// ancestors returns all backups the current backup is based on,
+// starting from the nearest one.
+// The walk is limited by the number of backups in the chain
+// to protect against a loop in 'prior' values.
+func (node *backupChainNode) ancestors(limit int) ([]*backupChainNode, bool) {
+	var result []*backupChainNode
+	for parent := node.parent; parent != nil; parent = parent.parent {
+		if len(result) >= limit {
+			return result, false
+		}
+		result = append(result, parent)
+	}
+	return result, true
+}
+
+// depth returns the number of hops to the full backup.
+func (node *backupChainNode) depth(limit int) int {
+	ancestors, _ := node.ancestors(limit)
+	return len(ancestors)
+}
+
+// broken reports whether the backup or any backup it is based on
+// references a missing backup.
+func (node *backupChainNode) broken(limit int) bool {
+	if len(node.missingRefs) != 0 {
+		return true
+	}
+	ancestors, ok := node.ancestors(limit)
+	if !ok {
+		return true
+	}
+	for _, ancestor := range ancestors {
+		if len(ancestor.missingRefs) != 0 {
+			return true
+		}
+	}
+	// Only a full backup may be the root of the chain.
+	root := node
+	if len(ancestors) != 0 {
+		root = ancestors[len(ancestors)-1]
+	}
+	return root.backup.Type != fullLabel
+}
+
+// dependents returns the number of backups based on the current backup.
+func (node *backupChainNode) dependents(limit int) int {
+	visited := make(map[*backupChainNode]struct{})
+	queue := append([]*backupChainNode{}, node.children...)
+	for len(queue) != 0 && len(visited) < limit {
+		child := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[child]; ok || child == node {
+			continue
+		}
+		visited[child] = struct{}{}
+		queue = append(queue, child.children...)
+	}
+	return len(visited)
+}
+
+// repoSize returns the sum of repo delta over the backup chain.
+func (node *backupChainNode) repoSize(limit int) int64 {
+	ancestors, _ := node.ancestors(limit)
+	size := node.backup.Info.Repository.Delta
+	for _, ancestor := range ancestors {
+		size += ancestor.backup.Info.Repository.Delta
+	}
+	return size
+}
+
+// Set backup chain metrics:
+//   - pgbackrest_backup_chain_depth
+//   - pgbackrest_backup_chain_dependents
+//   - pgbackrest_backup_chain_broken_status
+//   - pgbackrest_backup_chain_repo_size_bytes
+func getBackupChainMetrics(stanzaName string, backupData []backup, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	chain := buildBackupChain(backupData)
+	limit := len(chain.order)
+	for _, node := range chain.order {
+		backup := node.backup
+		blockIncr := backup.checkBackupIncremental()
+		labels := []string{
+			backup.Label,
+			backup.Type,
+			blockIncr,
+			strconv.Itoa(backup.Database.ID),
+			strconv.Itoa(backup.Database.RepoKey),
+			stanzaName,
+		}
+		// Backup chain depth.
+		setUpMetric(
+			pgbrStanzaBackupChainDepthMetric,
+			"pgbackrest_backup_chain_depth",
+			float64(node.depth(limit)),
+			setUpMetricValueFun,
+			logger,
+			labels...,
+		)
+		// Number of dependent backups.
+		setUpMetric(
+			pgbrStanzaBackupChainDependentsMetric,
+			"pgbackrest_backup_chain_dependents",
+			float64(node.dependents(limit)),
+			setUpMetricValueFun,
+			logger,
+			labels...,
+		)
+		// Backup chain broken status.
+		//  0 - all backups in the chain exist,
+		//  1 - prior or referenced backup is missing somewhere in the chain.
+		broken := node.broken(limit)
+		if len(node.missingRefs) != 0 {
+			logger.Warn(
+				"Backup references missing backups",
+				"stanza", stanzaName,
+				"backup", backup.Label,
+				"repo_key", backup.Database.RepoKey,
+				"missing", node.missingRefs,
+			)
+		}
+		setUpMetric(
+			pgbrStanzaBackupChainBrokenMetric,
+			"pgbackrest_backup_chain_broken_status",
+			convertBoolToFloat64(broken),
+			setUpMetricValueFun,
+			logger,
+			labels...,
+		)
+		// Repo size required to restore the backup.
+		setUpMetric(
+			pgbrStanzaBackupChainRepoSizeMetric,
+			"pgbackrest_backup_chain_repo_size_bytes",
+			float64(node.repoSize(limit)),
+			setUpMetricValueFun,
+			logger,
+			labels...,
+		)
+	}
+}
+
+func resetBackupChainMetrics() {
+	pgbrStanzaBackupChainDepthMetric.Reset()
+	pgbrStanzaBackupChainDependentsMetric.Reset()
+	pgbrStanzaBackupChainBrokenMetric.Reset()
+	pgbrStanzaBackupChainRepoSizeMetric.Reset()
+}
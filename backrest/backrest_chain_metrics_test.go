@@ -0,0 +1,213 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Chain of backups:
+//
+//	full <- diff <- incr
+//	(missing incr) <- incr
+func TestGetBackupChainMetrics(t *testing.T) {
+	type args struct {
+		stanzaName          string
+		backupData          []backup
+		setUpMetricValueFun setUpMetricValueFunType
+		testText            string
+	}
+	templateMetrics := `# HELP pgbackrest_backup_chain_broken_status Backup chain broken status.
+# TYPE pgbackrest_backup_chain_broken_status gauge
+pgbackrest_backup_chain_broken_status{backup_name="20210607-092423F",backup_type="full",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 0
+pgbackrest_backup_chain_broken_status{backup_name="20210607-092423F_20210607-092500D",backup_type="diff",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 0
+pgbackrest_backup_chain_broken_status{backup_name="20210607-092423F_20210607-092600I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 0
+pgbackrest_backup_chain_broken_status{backup_name="20210607-092423F_20210607-092700I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1
+# HELP pgbackrest_backup_chain_dependents Number of backups that would be unrestorable if the backup was removed.
+# TYPE pgbackrest_backup_chain_dependents gauge
+pgbackrest_backup_chain_dependents{backup_name="20210607-092423F",backup_type="full",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 2
+pgbackrest_backup_chain_dependents{backup_name="20210607-092423F_20210607-092500D",backup_type="diff",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1
+pgbackrest_backup_chain_dependents{backup_name="20210607-092423F_20210607-092600I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 0
+pgbackrest_backup_chain_dependents{backup_name="20210607-092423F_20210607-092700I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 0
+# HELP pgbackrest_backup_chain_depth Number of backups between the backup and the full backup in its chain.
+# TYPE pgbackrest_backup_chain_depth gauge
+pgbackrest_backup_chain_depth{backup_name="20210607-092423F",backup_type="full",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 0
+pgbackrest_backup_chain_depth{backup_name="20210607-092423F_20210607-092500D",backup_type="diff",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1
+pgbackrest_backup_chain_depth{backup_name="20210607-092423F_20210607-092600I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 2
+pgbackrest_backup_chain_depth{backup_name="20210607-092423F_20210607-092700I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 0
+# HELP pgbackrest_backup_chain_repo_size_bytes Compressed files size in all backups of the chain required to restore the database from backup.
+# TYPE pgbackrest_backup_chain_repo_size_bytes gauge
+pgbackrest_backup_chain_repo_size_bytes{backup_name="20210607-092423F",backup_type="full",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1000
+pgbackrest_backup_chain_repo_size_bytes{backup_name="20210607-092423F_20210607-092500D",backup_type="diff",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1100
+pgbackrest_backup_chain_repo_size_bytes{backup_name="20210607-092423F_20210607-092600I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1110
+pgbackrest_backup_chain_repo_size_bytes{backup_name="20210607-092423F_20210607-092700I",backup_type="incr",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1
+`
+	tests := []struct {
+		name string
+		args args
+	}{
+		{
+			"getBackupChainMetrics",
+			args{
+				"demo",
+				templateBackupChain(),
+				setUpMetricValue,
+				templateMetrics,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetBackupChainMetrics()
+			getBackupChainMetrics(tt.args.stanzaName, tt.args.backupData, tt.args.setUpMetricValueFun, logger)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(
+				pgbrStanzaBackupChainDepthMetric,
+				pgbrStanzaBackupChainDependentsMetric,
+				pgbrStanzaBackupChainBrokenMetric,
+				pgbrStanzaBackupChainRepoSizeMetric,
+			)
+			metricFamily, err := reg.Gather()
+			if err != nil {
+				fmt.Println(err)
+			}
+			out := &bytes.Buffer{}
+			for _, mf := range metricFamily {
+				if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+					panic(err)
+				}
+			}
+			if tt.args.testText != out.String() {
+				t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", tt.args.testText, out.String())
+			}
+		})
+	}
+}
+
+func TestBuildBackupChain(t *testing.T) {
+	tests := []struct {
+		name           string
+		backupData     []backup
+		wantDepth      map[string]int
+		wantBroken     map[string]bool
+		wantDependents map[string]int
+	}{
+		{
+			"buildBackupChainLoop",
+			parseTemplateBackups(`[` +
+				`{"label":"20210607-092423F_20210607-092500D","prior":"20210607-092423F_20210607-092600I","type":"diff","database":{"id":1,"repo-key":1}},` +
+				`{"label":"20210607-092423F_20210607-092600I","prior":"20210607-092423F_20210607-092500D","type":"incr","database":{"id":1,"repo-key":1}}]`),
+			map[string]int{"20210607-092423F_20210607-092500D": 2, "20210607-092423F_20210607-092600I": 2},
+			map[string]bool{"20210607-092423F_20210607-092500D": true, "20210607-092423F_20210607-092600I": true},
+			map[string]int{"20210607-092423F_20210607-092500D": 1, "20210607-092423F_20210607-092600I": 1},
+		},
+		{
+			"buildBackupChainMultiRepo",
+			parseTemplateBackups(`[` +
+				`{"label":"20210607-092423F","prior":null,"type":"full","database":{"id":1,"repo-key":1}},` +
+				`{"label":"20210607-092423F_20210607-092500D","prior":"20210607-092423F","type":"diff","database":{"id":1,"repo-key":2}}]`),
+			map[string]int{"20210607-092423F": 0, "20210607-092423F_20210607-092500D": 0},
+			map[string]bool{"20210607-092423F": false, "20210607-092423F_20210607-092500D": true},
+			map[string]int{"20210607-092423F": 0, "20210607-092423F_20210607-092500D": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := buildBackupChain(tt.backupData)
+			limit := len(chain.order)
+			for _, node := range chain.order {
+				label := node.backup.Label
+				if got := node.depth(limit); got != tt.wantDepth[label] {
+					t.Errorf("\nVariables do not match for %s depth:\n%d\nwant:\n%d", label, got, tt.wantDepth[label])
+				}
+				if got := node.broken(limit); got != tt.wantBroken[label] {
+					t.Errorf("\nVariables do not match for %s broken:\n%v\nwant:\n%v", label, got, tt.wantBroken[label])
+				}
+				if got := node.dependents(limit); got != tt.wantDependents[label] {
+					t.Errorf("\nVariables do not match for %s dependents:\n%d\nwant:\n%d", label, got, tt.wantDependents[label])
+				}
+			}
+		})
+	}
+}
+
+func TestGetBackupChainMetricsErrorsAndDebugs(t *testing.T) {
+	type args struct {
+		stanzaName          string
+		backupData          []backup
+		setUpMetricValueFun setUpMetricValueFunType
+		errorsCount         int
+		debugsCount         int
+		warnsCount          int
+	}
+	tests := []struct {
+		name string
+		args args
+	}{
+		{
+			"getBackupChainMetricsLogError",
+			args{
+				"demo",
+				templateBackupChain(),
+				fakeSetUpMetricValue,
+				16,
+				16,
+				1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			lc := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			getBackupChainMetrics(tt.args.stanzaName, tt.args.backupData, tt.args.setUpMetricValueFun, lc)
+			errorsOutputCount := strings.Count(out.String(), "level=ERROR")
+			debugsOutputCount := strings.Count(out.String(), "level=DEBUG")
+			warnsOutputCount := strings.Count(out.String(), "level=WARN")
+			if tt.args.errorsCount != errorsOutputCount || tt.args.debugsCount != debugsOutputCount || tt.args.warnsCount != warnsOutputCount {
+				t.Errorf("\nVariables do not match:\nerrors=%d, debugs=%d, warns=%d\nwant:\nerrors=%d, debugs=%d, warns=%d",
+					tt.args.errorsCount, tt.args.debugsCount, tt.args.warnsCount,
+					errorsOutputCount, debugsOutputCount, warnsOutputCount)
+			}
+		})
+	}
+}
+
+// templateBackupChain returns backups for one stanza:
+// full backup, diff and incr backups based on it
+// and incr backup whose prior backup is missing.
+func templateBackupChain() []backup {
+	return parseTemplateBackups(`[` +
+		`{"label":"20210607-092423F","prior":null,"reference":null,"type":"full",` +
+		`"database":{"id":1,"repo-key":1},"error":false,` +
+		`"info":{"delta":10000,"repository":{"delta":1000,"size":1000},"size":10000},` +
+		`"timestamp":{"start":1623057863,"stop":1623057866}},` +
+		`{"label":"20210607-092423F_20210607-092500D","prior":"20210607-092423F","reference":["20210607-092423F"],"type":"diff",` +
+		`"database":{"id":1,"repo-key":1},"error":false,` +
+		`"info":{"delta":1000,"repository":{"delta":100,"size":1100},"size":10000},` +
+		`"timestamp":{"start":1623057900,"stop":1623057903}},` +
+		`{"label":"20210607-092423F_20210607-092600I","prior":"20210607-092423F_20210607-092500D",` +
+		`"reference":["20210607-092423F","20210607-092423F_20210607-092500D"],"type":"incr",` +
+		`"database":{"id":1,"repo-key":1},"error":true,` +
+		`"info":{"delta":100,"repository":{"delta":10,"size":1110},"size":10000},` +
+		`"timestamp":{"start":1623057960,"stop":1623057963}},` +
+		`{"label":"20210607-092423F_20210607-092700I","prior":"20210607-092423F_20210607-092650I",` +
+		`"reference":["20210607-092423F"],"type":"incr",` +
+		`"database":{"id":1,"repo-key":1},"error":false,` +
+		`"info":{"delta":10,"repository":{"delta":1,"size":1111},"size":10000},` +
+		`"timestamp":{"start":1623058020,"stop":1623058023}}]`)
+}
+
+// parseTemplateBackups parses backup list in pgBackRest json format.
+func parseTemplateBackups(data string) []backup {
+	stanzas, err := parseResult([]byte(`[{"name":"demo","backup":` + data + `}]`))
+	if err != nil {
+		panic(err)
+	}
+	return stanzas[0].Backup
+}
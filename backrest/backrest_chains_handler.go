@@ -0,0 +1,425 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	chainsFormatHTML    = "html"
+	chainsFormatDOT     = "dot"
+	chainsFormatMermaid = "mermaid"
+	// Colors of backup chain nodes.
+	chainsColorFull    = "#93c47d"
+	chainsColorDiff    = "#6fa8dc"
+	chainsColorIncr    = "#ffd966"
+	chainsColorError   = "#e06666"
+	chainsColorMissing = "#cccccc"
+	// Layout of nodes in SVG.
+	chainsNodeWidth    = 270
+	chainsNodeHeight   = 36
+	chainsColumnMargin = 40
+	chainsRowMargin    = 12
+)
+
+// backupChainGroup is a dependency graph of backups for one stanza and repo.
+type backupChainGroup struct {
+	stanzaName string
+	repoKey    int
+	nodes      []*backupChainNode
+}
+
+// chainsLayoutNode is a backup placed on the SVG canvas.
+type chainsLayoutNode struct {
+	X, Y      int
+	Label     string
+	Type      string
+	Fill      string
+	BlockIncr bool
+	Missing   bool
+	Title     string
+}
+
+// chainsLayoutEdge connects prior backup with the backup based on it.
+type chainsLayoutEdge struct {
+	Points  string
+	Missing bool
+}
+
+// chainsLayout is the SVG picture of one backup chain group.
+type chainsLayout struct {
+	Title  string
+	Width  int
+	Height int
+	Nodes  []chainsLayoutNode
+	Edges  []chainsLayoutEdge
+}
+
+var chainsHTMLTemplate = template.Must(template.New("chains").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>pgBackRest backup chains</title>
+<style>
+body { font-family: sans-serif; margin: 0; }
+header { background: #476b6b; color: #fff; padding: 10px 20px; }
+main { padding: 0 20px 20px; }
+svg text { font-family: monospace; font-size: 12px; }
+.legend span { display: inline-block; padding: 2px 8px; margin-right: 6px; border: 1px solid #333; }
+</style>
+</head>
+<body>
+<header><h1>pgBackRest backup chains</h1></header>
+<main>
+<p>Other formats: <a href="?stanza={{ .Stanza }}&amp;format=dot">Graphviz DOT</a>, <a href="?stanza={{ .Stanza }}&amp;format=mermaid">Mermaid</a>.</p>
+<p class="legend">
+<span style="background: {{ .Colors.Full }}">full</span>
+<span style="background: {{ .Colors.Diff }}">diff</span>
+<span style="background: {{ .Colors.Incr }}">incr</span>
+<span style="background: {{ .Colors.Error }}">error</span>
+<span style="background: {{ .Colors.Missing }}; border-style: dashed">missing</span>
+<span style="border-width: 3px">block incremental</span>
+</p>
+{{- range .Layouts }}
+<h2>{{ .Title }}</h2>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{ .Width }}" height="{{ .Height }}">
+{{- range .Edges }}
+<polyline points="{{ .Points }}" fill="none" stroke="#333"{{ if .Missing }} stroke-dasharray="4"{{ end }}/>
+{{- end }}
+{{- range .Nodes }}
+<g><title>{{ .Title }}</title>
+<rect x="{{ .X }}" y="{{ .Y }}" width="{{ $.NodeWidth }}" height="{{ $.NodeHeight }}" rx="4" fill="{{ .Fill }}" stroke="#333" stroke-width="{{ if .BlockIncr }}3{{ else }}1{{ end }}"{{ if .Missing }} stroke-dasharray="4"{{ end }}/>
+<text x="{{ .X }}" y="{{ .Y }}" dx="8" dy="23">{{ .Label }} ({{ .Type }})</text>
+</g>
+{{- end }}
+</svg>
+{{- else }}
+<p>No backups found.</p>
+{{- end }}
+</main>
+</body>
+</html>
+`))
+
+// chainsHandler renders backup chains for the latest collected data.
+// Query parameters:
+//   - stanza - stanza name, if not set, all stanzas are rendered;
+//   - format - one of: [html, dot, mermaid], default html.
+func chainsHandler(logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := loadSnapshot()
+		if snapshot == nil {
+			http.Error(w, "No data collected from pgBackRest yet", http.StatusServiceUnavailable)
+			return
+		}
+		stanzaName := r.URL.Query().Get("stanza")
+		stanzas := snapshot.stanzas
+		if stanzaName != "" {
+			stanzaData, ok := snapshot.getStanza(stanzaName)
+			if !ok {
+				http.Error(w, fmt.Sprintf("Stanza %q not found", stanzaName), http.StatusNotFound)
+				return
+			}
+			stanzas = []stanza{stanzaData}
+		}
+		groups := getBackupChainGroups(stanzas)
+		var (
+			buf         bytes.Buffer
+			err         error
+			contentType string
+		)
+		switch format := r.URL.Query().Get("format"); format {
+		case "", chainsFormatHTML:
+			contentType = "text/html; charset=UTF-8"
+			err = renderChainsHTML(&buf, groups, stanzaName)
+		case chainsFormatDOT:
+			contentType = "text/vnd.graphviz; charset=UTF-8"
+			err = renderChainsDOT(&buf, groups)
+		case chainsFormatMermaid:
+			contentType = "text/plain; charset=UTF-8"
+			err = renderChainsMermaid(&buf, groups)
+		default:
+			http.Error(w, fmt.Sprintf("Unknown format %q", format), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("Render backup chains failed", "err", err)
+			http.Error(w, "Render backup chains failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			logger.Error("Write response failed", "err", err)
+		}
+	})
+}
+
+// getBackupChainGroups splits backups of each stanza by repo
+// and builds dependency graph for them.
+func getBackupChainGroups(stanzas []stanza) []backupChainGroup {
+	var groups []backupChainGroup
+	for _, stanzaData := range stanzas {
+		chain := buildBackupChain(stanzaData.Backup)
+		index := make(map[int]int)
+		for _, node := range chain.order {
+			repoKey := node.backup.Database.RepoKey
+			i, ok := index[repoKey]
+			if !ok {
+				i = len(groups)
+				index[repoKey] = i
+				groups = append(groups, backupChainGroup{stanzaName: stanzaData.Name, repoKey: repoKey})
+			}
+			groups[i].nodes = append(groups[i].nodes, node)
+		}
+	}
+	return groups
+}
+
+// title returns the name of the group.
+func (group backupChainGroup) title() string {
+	return fmt.Sprintf("%s (repo%d)", group.stanzaName, group.repoKey)
+}
+
+// walk calls fn for each node in depth-first order starting from roots.
+// Nodes from loops in 'prior' values are visited last.
+func (group backupChainGroup) walk(fn func(node *backupChainNode, level int)) {
+	visited := make(map[*backupChainNode]struct{}, len(group.nodes))
+	var visit func(node *backupChainNode, level int)
+	visit = func(node *backupChainNode, level int) {
+		if _, ok := visited[node]; ok {
+			return
+		}
+		visited[node] = struct{}{}
+		fn(node, level)
+		for _, child := range node.children {
+			visit(child, level+1)
+		}
+	}
+	for _, node := range group.nodes {
+		if node.parent == nil {
+			visit(node, 0)
+		}
+	}
+	for _, node := range group.nodes {
+		visit(node, 0)
+	}
+}
+
+// backupHasError reports whether backup contains page checksum errors.
+func backupHasError(backupData backup) bool {
+	return backupData.Error != nil && *backupData.Error
+}
+
+// chainsNodeColor returns fill color for backup.
+func chainsNodeColor(backupData backup) string {
+	if backupHasError(backupData) {
+		return chainsColorError
+	}
+	switch backupData.Type {
+	case fullLabel:
+		return chainsColorFull
+	case diffLabel:
+		return chainsColorDiff
+	default:
+		return chainsColorIncr
+	}
+}
+
+// chainsNodeTitle returns short description of backup.
+func chainsNodeTitle(backupData backup) string {
+	return fmt.Sprintf(
+		"%s\ntype: %s\nprior: %s\nerror: %s\nblock incremental: %s\nrepo delta: %d bytes",
+		backupData.Label,
+		backupData.Type,
+		backupData.Prior,
+		strconv.FormatBool(backupHasError(backupData)),
+		backupData.checkBackupIncremental(),
+		backupData.Info.Repository.Delta,
+	)
+}
+
+func renderChainsDOT(w io.Writer, groups []backupChainGroup) error {
+	var b strings.Builder
+	b.WriteString("digraph \"pgbackrest\" {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=filled, fontname=monospace];\n")
+	for i, group := range groups {
+		repoKey := strconv.Itoa(group.repoKey)
+		nodeID := func(label string) string {
+			return strconv.Quote(group.stanzaName + "/" + repoKey + "/" + label)
+		}
+		fmt.Fprintf(&b, "  subgraph \"cluster_%d\" {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", strconv.Quote(group.title()))
+		group.walk(func(node *backupChainNode, _ int) {
+			style := "filled"
+			if node.backup.checkBackupIncremental() == "y" {
+				style = "filled,bold"
+			}
+			fmt.Fprintf(&b, "    %s [label=%s, fillcolor=%s, style=%s, tooltip=%s];\n",
+				nodeID(node.backup.Label),
+				strconv.Quote(node.backup.Label+"\n"+node.backup.Type),
+				strconv.Quote(chainsNodeColor(node.backup)),
+				strconv.Quote(style),
+				strconv.Quote(chainsNodeTitle(node.backup)),
+			)
+		})
+		group.walk(func(node *backupChainNode, _ int) {
+			if node.parent != nil {
+				fmt.Fprintf(&b, "    %s -> %s;\n", nodeID(node.parent.backup.Label), nodeID(node.backup.Label))
+			}
+			for _, missing := range node.missingRefs {
+				fmt.Fprintf(&b, "    %s [label=%s, fillcolor=%s, style=\"filled,dashed\"];\n",
+					nodeID(missing),
+					strconv.Quote(missing+"\nmissing"),
+					strconv.Quote(chainsColorMissing),
+				)
+				fmt.Fprintf(&b, "    %s -> %s [style=dashed];\n", nodeID(missing), nodeID(node.backup.Label))
+			}
+		})
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderChainsMermaid(w io.Writer, groups []backupChainGroup) error {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	fmt.Fprintf(&b, "  classDef full fill:%s\n", chainsColorFull)
+	fmt.Fprintf(&b, "  classDef diff fill:%s\n", chainsColorDiff)
+	fmt.Fprintf(&b, "  classDef incr fill:%s\n", chainsColorIncr)
+	fmt.Fprintf(&b, "  classDef error fill:%s\n", chainsColorError)
+	fmt.Fprintf(&b, "  classDef missing fill:%s,stroke-dasharray:4\n", chainsColorMissing)
+	b.WriteString("  classDef blockincr stroke-width:3px\n")
+	// Mermaid node ids can't contain arbitrary symbols, so sequence numbers are used.
+	ids := make(map[string]string)
+	nodeID := func(groupIndex int, label string) string {
+		key := strconv.Itoa(groupIndex) + "/" + label
+		id, ok := ids[key]
+		if !ok {
+			id = "n" + strconv.Itoa(len(ids))
+			ids[key] = id
+		}
+		return id
+	}
+	// Mermaid labels are quoted, quotes inside labels are replaced.
+	quote := func(value string) string {
+		return "\"" + strings.ReplaceAll(value, "\"", "#quot;") + "\""
+	}
+	for i, group := range groups {
+		fmt.Fprintf(&b, "  subgraph g%d[%s]\n", i, quote(group.title()))
+		group.walk(func(node *backupChainNode, _ int) {
+			id := nodeID(i, node.backup.Label)
+			class := node.backup.Type
+			if backupHasError(node.backup) {
+				class = "error"
+			}
+			fmt.Fprintf(&b, "    %s[%s]:::%s\n", id, quote(node.backup.Label+"<br/>"+node.backup.Type), class)
+			if node.backup.checkBackupIncremental() == "y" {
+				fmt.Fprintf(&b, "    class %s blockincr\n", id)
+			}
+		})
+		group.walk(func(node *backupChainNode, _ int) {
+			if node.parent != nil {
+				fmt.Fprintf(&b, "    %s --> %s\n", nodeID(i, node.parent.backup.Label), nodeID(i, node.backup.Label))
+			}
+			for _, missing := range node.missingRefs {
+				fmt.Fprintf(&b, "    %s[%s]:::missing\n", nodeID(i, missing), quote(missing+"<br/>missing"))
+				fmt.Fprintf(&b, "    %s -.-> %s\n", nodeID(i, missing), nodeID(i, node.backup.Label))
+			}
+		})
+		b.WriteString("  end\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// getChainsLayout places backups of the group on the SVG canvas.
+// Each backup takes its own row, the column is the level in the chain.
+func getChainsLayout(group backupChainGroup) chainsLayout {
+	layout := chainsLayout{Title: group.title()}
+	type position struct{ x, y int }
+	positions := make(map[*backupChainNode]position, len(group.nodes))
+	row := 0
+	addNode := func(node chainsLayoutNode, level int) position {
+		pos := position{
+			x: chainsColumnMargin/2 + level*(chainsNodeWidth+chainsColumnMargin),
+			y: chainsRowMargin + row*(chainsNodeHeight+chainsRowMargin),
+		}
+		row++
+		node.X, node.Y = pos.x, pos.y
+		layout.Nodes = append(layout.Nodes, node)
+		layout.Width = max(layout.Width, pos.x+chainsNodeWidth+chainsColumnMargin/2)
+		layout.Height = pos.y + chainsNodeHeight + chainsRowMargin
+		return pos
+	}
+	addEdge := func(from, to position, missing bool) {
+		startX, startY := from.x+chainsNodeWidth/2, from.y+chainsNodeHeight
+		endX, endY := to.x, to.y+chainsNodeHeight/2
+		layout.Edges = append(layout.Edges, chainsLayoutEdge{
+			Points:  fmt.Sprintf("%d,%d %d,%d %d,%d", startX, startY, startX, endY, endX, endY),
+			Missing: missing,
+		})
+	}
+	group.walk(func(node *backupChainNode, level int) {
+		// Missing backups are drawn before the first backup that references them.
+		missingPositions := make([]position, 0, len(node.missingRefs))
+		for _, missing := range node.missingRefs {
+			missingPositions = append(missingPositions, addNode(chainsLayoutNode{
+				Label:   missing,
+				Type:    "missing",
+				Fill:    chainsColorMissing,
+				Missing: true,
+				Title:   missing + "\nbackup is missing in repository",
+			}, level))
+		}
+		if len(missingPositions) != 0 {
+			level++
+		}
+		positions[node] = addNode(chainsLayoutNode{
+			Label:     node.backup.Label,
+			Type:      node.backup.Type,
+			Fill:      chainsNodeColor(node.backup),
+			BlockIncr: node.backup.checkBackupIncremental() == "y",
+			Title:     chainsNodeTitle(node.backup),
+		}, level)
+		for _, pos := range missingPositions {
+			addEdge(pos, positions[node], true)
+		}
+		if node.parent != nil {
+			if parent, ok := positions[node.parent]; ok {
+				addEdge(parent, positions[node], false)
+			}
+		}
+	})
+	return layout
+}
+
+func renderChainsHTML(w io.Writer, groups []backupChainGroup, stanzaName string) error {
+	layouts := make([]chainsLayout, 0, len(groups))
+	for _, group := range groups {
+		layouts = append(layouts, getChainsLayout(group))
+	}
+	return chainsHTMLTemplate.Execute(w, struct {
+		Stanza     string
+		NodeWidth  int
+		NodeHeight int
+		Layouts    []chainsLayout
+		Colors     struct{ Full, Diff, Incr, Error, Missing string }
+	}{
+		Stanza:     stanzaName,
+		NodeWidth:  chainsNodeWidth,
+		NodeHeight: chainsNodeHeight,
+		Layouts:    layouts,
+		Colors: struct{ Full, Diff, Incr, Error, Missing string }{
+			chainsColorFull, chainsColorDiff, chainsColorIncr, chainsColorError, chainsColorMissing,
+		},
+	})
+}
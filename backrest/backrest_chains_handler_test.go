@@ -0,0 +1,132 @@
+package backrest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChainsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		snapshot   *collectionSnapshot
+		query      string
+		wantCode   int
+		wantType   string
+		wantString []string
+	}{
+		{
+			"chainsHandlerNoData",
+			nil,
+			"",
+			http.StatusServiceUnavailable,
+			"text/plain; charset=utf-8",
+			[]string{"No data collected from pgBackRest yet"},
+		},
+		{
+			"chainsHandlerHTML",
+			templateChainsSnapshot(),
+			"",
+			http.StatusOK,
+			"text/html; charset=UTF-8",
+			[]string{
+				"<h2>demo (repo1)</h2>",
+				`fill="` + chainsColorError + `"`,
+				">20210607-092423F_20210607-092650I (missing)</text>",
+				`href="?stanza=&amp;format=dot"`,
+			},
+		},
+		{
+			"chainsHandlerDOT",
+			templateChainsSnapshot(),
+			"?stanza=demo&format=dot",
+			http.StatusOK,
+			"text/vnd.graphviz; charset=UTF-8",
+			[]string{
+				"digraph \"pgbackrest\" {",
+				`label="demo (repo1)";`,
+				`"demo/1/20210607-092423F" -> "demo/1/20210607-092423F_20210607-092500D";`,
+				`"demo/1/20210607-092423F_20210607-092650I" -> "demo/1/20210607-092423F_20210607-092700I" [style=dashed];`,
+			},
+		},
+		{
+			"chainsHandlerMermaid",
+			templateChainsSnapshot(),
+			"?format=mermaid",
+			http.StatusOK,
+			"text/plain; charset=UTF-8",
+			[]string{
+				"flowchart LR",
+				`subgraph g0["demo (repo1)"]`,
+				`n0["20210607-092423F<br/>full"]:::full`,
+				`n2["20210607-092423F_20210607-092600I<br/>incr"]:::error`,
+				"n0 --> n1",
+				"n4 -.-> n3",
+			},
+		},
+		{
+			"chainsHandlerStanzaNotFound",
+			templateChainsSnapshot(),
+			"?stanza=demo2",
+			http.StatusNotFound,
+			"text/plain; charset=utf-8",
+			[]string{`Stanza "demo2" not found`},
+		},
+		{
+			"chainsHandlerBadFormat",
+			templateChainsSnapshot(),
+			"?format=png",
+			http.StatusBadRequest,
+			"text/plain; charset=utf-8",
+			[]string{`Unknown format "png"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeSnapshot(tt.snapshot)
+			defer storeSnapshot(nil)
+			rec := httptest.NewRecorder()
+			chainsHandler(logger).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, chainsEndpoint+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("\nVariables do not match:\n%d\nwant:\n%d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, tt.wantType)
+			}
+			for _, want := range tt.wantString {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("\nVariable do not match:\n%s\nwant:\n%s", want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGetChainsLayout(t *testing.T) {
+	groups := getBackupChainGroups([]stanza{{Name: "demo", Backup: templateBackupChain()}})
+	if len(groups) != 1 {
+		t.Fatalf("\nVariables do not match:\n%d\nwant:\n%d", len(groups), 1)
+	}
+	layout := getChainsLayout(groups[0])
+	// 4 backups and 1 missing backup.
+	if len(layout.Nodes) != 5 {
+		t.Errorf("\nVariables do not match:\n%d\nwant:\n%d", len(layout.Nodes), 5)
+	}
+	// 2 edges between existing backups and 1 edge from missing backup.
+	if len(layout.Edges) != 3 {
+		t.Errorf("\nVariables do not match:\n%d\nwant:\n%d", len(layout.Edges), 3)
+	}
+	// Incr backup is placed in the third column.
+	if layout.Nodes[2].Label != "20210607-092423F_20210607-092600I" ||
+		layout.Nodes[2].X != chainsColumnMargin/2+2*(chainsNodeWidth+chainsColumnMargin) {
+		t.Errorf("\nVariables do not match:\n%v", layout.Nodes[2])
+	}
+}
+
+func templateChainsSnapshot() *collectionSnapshot {
+	snapshot := newCollectionSnapshot(time.Unix(1623706322, 0))
+	snapshot.addStanza(stanza{Name: "demo", Backup: templateBackupChain()})
+	return snapshot
+}
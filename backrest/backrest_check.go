@@ -0,0 +1,253 @@
+package backrest
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Exit codes of check command, the same as for Nagios plugins.
+// See https://nagios-plugins.org/doc/guidelines.html#AEN78.
+const (
+	CheckOK       = 0
+	CheckWarning  = 1
+	CheckCritical = 2
+	CheckUnknown  = 3
+)
+
+var checkStatusNames = map[int]string{
+	CheckOK:       "OK",
+	CheckWarning:  "WARNING",
+	CheckCritical: "CRITICAL",
+	CheckUnknown:  "UNKNOWN",
+}
+
+// CheckConfig contains parameters for checking stanza.
+// Exceeding the maximum age results in CRITICAL status, exceeding the warning age results in WARNING status.
+// Zero value of the age disables the corresponding threshold.
+type CheckConfig struct {
+	// Config is the full path to pgBackRest configuration file.
+	Config string
+	// ConfigIncludePath is the full path to additional pgBackRest configuration files.
+	ConfigIncludePath string
+	// Stanza is the stanza name.
+	Stanza string
+	// MaxFullAge is the maximum time since the last completed full backup.
+	MaxFullAge time.Duration
+	// MaxDiffAge is the maximum time since the last completed full or differential backup.
+	MaxDiffAge time.Duration
+	// MaxIncrAge is the maximum time since the last completed backup of any type.
+	MaxIncrAge time.Duration
+	// MaxWALAge is the maximum time since the last WAL segment was archived.
+	MaxWALAge time.Duration
+	// WarnFullAge is the warning time since the last completed full backup.
+	WarnFullAge time.Duration
+	// WarnDiffAge is the warning time since the last completed full or differential backup.
+	WarnDiffAge time.Duration
+	// WarnIncrAge is the warning time since the last completed backup of any type.
+	WarnIncrAge time.Duration
+	// WarnWALAge is the warning time since the last WAL segment was archived.
+	WarnWALAge time.Duration
+}
+
+// ageThreshold contains warning and critical thresholds of age.
+type ageThreshold struct {
+	warn time.Duration
+	crit time.Duration
+}
+
+func (threshold ageThreshold) enabled() bool {
+	return threshold.warn > 0 || threshold.crit > 0
+}
+
+// validate checks that warning threshold doesn't exceed critical one, when both are set.
+func (threshold ageThreshold) validate(name string) error {
+	if threshold.warn > 0 && threshold.crit > 0 && threshold.warn > threshold.crit {
+		return fmt.Errorf("warning %s age %s > maximum %s age %s", name, model.Duration(threshold.warn), name, model.Duration(threshold.crit))
+	}
+	return nil
+}
+
+// check returns the status for age and the exceeded threshold.
+func (threshold ageThreshold) check(age time.Duration) (int, time.Duration) {
+	switch {
+	case threshold.crit > 0 && age > threshold.crit:
+		return CheckCritical, threshold.crit
+	case threshold.warn > 0 && age > threshold.warn:
+		return CheckWarning, threshold.warn
+	}
+	return CheckOK, 0
+}
+
+// formatPerfData returns performance data for age in seconds with warning and critical thresholds.
+// Disabled threshold is empty.
+func (threshold ageThreshold) formatPerfData(label string, age time.Duration) string {
+	formatThreshold := func(value time.Duration) string {
+		if value <= 0 {
+			return ""
+		}
+		return strconv.FormatInt(int64(value.Seconds()), 10)
+	}
+	return fmt.Sprintf("%s=%ds;%s;%s", label, int64(age.Seconds()), formatThreshold(threshold.warn), formatThreshold(threshold.crit))
+}
+
+// CheckResult is the result of stanza check.
+type CheckResult struct {
+	// Code is the exit code. One of: CheckOK, CheckWarning, CheckCritical, CheckUnknown.
+	Code int
+	// Messages describe problems or state of stanza if there are no problems.
+	Messages []string
+	// PerfData is the performance data in Nagios format.
+	PerfData []string
+}
+
+// String returns one-line plugin output, e.g.
+// PGBACKREST CRITICAL - stanza demo: full backup 9d 1h ago > 8d | full_age=781200s;604800;691200
+func (result CheckResult) String() string {
+	output := fmt.Sprintf("PGBACKREST %s - %s", checkStatusNames[result.Code], strings.Join(result.Messages, ", "))
+	if len(result.PerfData) != 0 {
+		output += " | " + strings.Join(result.PerfData, " ")
+	}
+	return output
+}
+
+// setCode sets the exit code if it's more severe than the current one.
+// CRITICAL is more severe than WARNING, WARNING is more severe than UNKNOWN.
+func (result *CheckResult) setCode(code int) {
+	severity := []int{CheckOK, CheckUnknown, CheckWarning, CheckCritical}
+	if slices.Index(severity, code) > slices.Index(severity, result.Code) {
+		result.Code = code
+	}
+}
+
+func (result *CheckResult) addProblem(code int, format string, args ...any) {
+	result.setCode(code)
+	result.Messages = append(result.Messages, fmt.Sprintf(format, args...))
+}
+
+// RunCheck gets and parses pgBackRest info for stanza and checks it.
+func RunCheck(cfg CheckConfig, logger *slog.Logger) CheckResult {
+	for _, thresholdCheck := range []struct {
+		name      string
+		threshold ageThreshold
+	}{
+		{fullLabel, ageThreshold{cfg.WarnFullAge, cfg.MaxFullAge}},
+		{diffLabel, ageThreshold{cfg.WarnDiffAge, cfg.MaxDiffAge}},
+		{incrLabel, ageThreshold{cfg.WarnIncrAge, cfg.MaxIncrAge}},
+		{"WAL", ageThreshold{cfg.WarnWALAge, cfg.MaxWALAge}},
+	} {
+		if err := thresholdCheck.threshold.validate(thresholdCheck.name); err != nil {
+			return CheckResult{Code: CheckUnknown, Messages: []string{fmt.Sprintf("invalid thresholds: %v", err)}}
+		}
+	}
+	stanzaData, err := getAllInfoData(cfg.Config, cfg.ConfigIncludePath, cfg.Stanza, "", logger)
+	if err != nil {
+		return CheckResult{Code: CheckUnknown, Messages: []string{fmt.Sprintf("get data from pgBackRest failed: %v", err)}}
+	}
+	parseStanzaData, err := parseResult(stanzaData)
+	if err != nil {
+		return CheckResult{Code: CheckUnknown, Messages: []string{fmt.Sprintf("parse pgBackRest data failed: %v", err)}}
+	}
+	for _, singleStanza := range parseStanzaData {
+		if singleStanza.Name != cfg.Stanza {
+			continue
+		}
+		var (
+			walTimes map[int]time.Time
+			walErr   error
+		)
+		if (ageThreshold{cfg.WarnWALAge, cfg.MaxWALAge}).enabled() {
+			walTimes, walErr = getLastWALArchiveTimes(cfg.Config, cfg.ConfigIncludePath, singleStanza.Name, singleStanza.Archive, logger)
+		}
+		return checkStanza(cfg, singleStanza, walTimes, walErr, time.Now())
+	}
+	return CheckResult{Code: CheckUnknown, Messages: []string{fmt.Sprintf("stanza %s not found", cfg.Stanza)}}
+}
+
+// checkStanza checks stanza and repository statuses, errors in the last backup and freshness of backups and WAL.
+func checkStanza(cfg CheckConfig, stanzaData stanza, walTimes map[int]time.Time, walErr error, now time.Time) CheckResult {
+	result := CheckResult{Code: CheckOK}
+	result.PerfData = append(result.PerfData, fmt.Sprintf("stanza_status=%d", stanzaData.Status.Code))
+	if stanzaData.Status.Code != 0 {
+		result.addProblem(CheckCritical, "stanza status %d (%s)", stanzaData.Status.Code, stanzaData.Status.Message)
+	}
+	// For pgBackRest < v2.32 repo info is not available.
+	if stanzaData.Repo != nil {
+		for _, repo := range *stanzaData.Repo {
+			result.PerfData = append(result.PerfData, fmt.Sprintf("repo%d_status=%d", repo.Key, repo.Status.Code))
+			if repo.Status.Code != 0 {
+				result.addProblem(CheckCritical, "repo%d status %d (%s)", repo.Key, repo.Status.Code, repo.Status.Message)
+			}
+		}
+	}
+	lastBackups := initLastBackupStruct()
+	for _, backupData := range stanzaData.Backup {
+		compareLastBackups(&lastBackups, backupData, backupData.checkBackupIncremental())
+	}
+	// The last backup of any type is stored for incremental backups.
+	// Backup with errors (e.g., page checksum errors) can't be trusted for restore.
+	if lastBackups.incr.backupError != nil && *lastBackups.incr.backupError {
+		result.addProblem(CheckCritical, "backup %s has errors", lastBackups.incr.backupLabel)
+	}
+	for _, backupCheck := range []struct {
+		name       string
+		backupData backupStruct
+		threshold  ageThreshold
+	}{
+		{fullLabel, lastBackups.full, ageThreshold{cfg.WarnFullAge, cfg.MaxFullAge}},
+		{diffLabel, lastBackups.diff, ageThreshold{cfg.WarnDiffAge, cfg.MaxDiffAge}},
+		{incrLabel, lastBackups.incr, ageThreshold{cfg.WarnIncrAge, cfg.MaxIncrAge}},
+	} {
+		if !backupCheck.threshold.enabled() {
+			continue
+		}
+		if backupCheck.backupData.backupTime.IsZero() {
+			result.addProblem(CheckCritical, "no %s backups", backupCheck.name)
+			continue
+		}
+		age := now.Sub(backupCheck.backupData.backupTime)
+		result.PerfData = append(result.PerfData, backupCheck.threshold.formatPerfData(backupCheck.name+"_age", age))
+		if code, exceeded := backupCheck.threshold.check(age); code != CheckOK {
+			result.addProblem(code, "%s backup %s ago > %s", backupCheck.name, formatAge(age), model.Duration(exceeded))
+		}
+	}
+	if walThreshold := (ageThreshold{cfg.WarnWALAge, cfg.MaxWALAge}); walThreshold.enabled() {
+		checkWALAge(&result, walThreshold, walTimes, walErr, now)
+	}
+	if len(result.Messages) == 0 {
+		result.Messages = append(result.Messages, "backups are fresh")
+		if !lastBackups.incr.backupTime.IsZero() {
+			result.Messages[0] = fmt.Sprintf("last backup %s %s ago", lastBackups.incr.backupLabel, formatAge(now.Sub(lastBackups.incr.backupTime)))
+		}
+	}
+	for i := range result.Messages {
+		result.Messages[i] = "stanza " + stanzaData.Name + ": " + result.Messages[i]
+	}
+	return result
+}
+
+// checkWALAge checks the time since the last WAL segment was archived for each repository.
+func checkWALAge(result *CheckResult, threshold ageThreshold, walTimes map[int]time.Time, walErr error, now time.Time) {
+	if walErr != nil {
+		result.addProblem(CheckUnknown, "get WAL archive time failed: %v", walErr)
+		return
+	}
+	if len(walTimes) == 0 {
+		result.addProblem(CheckCritical, "no archived WAL")
+		return
+	}
+	// Sorted for stable output.
+	for _, repoKey := range slices.Sorted(maps.Keys(walTimes)) {
+		age := now.Sub(walTimes[repoKey])
+		result.PerfData = append(result.PerfData, threshold.formatPerfData(fmt.Sprintf("repo%d_wal_age", repoKey), age))
+		if code, exceeded := threshold.check(age); code != CheckOK {
+			result.addProblem(code, "repo%d last WAL archived %s ago > %s", repoKey, formatAge(age), model.Duration(exceeded))
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package backrest
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pgbrCheckStatusMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_check_status",
+		Help: "Result of pgbackrest check command.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrCheckDurationMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_check_duration_seconds",
+		Help: "Duration of pgbackrest check command.",
+	},
+		[]string{"stanza"})
+	pgbrCheckErrorMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_check_error_info",
+		Help: "Error returned by pgbackrest check command.",
+	},
+		[]string{
+			"code",
+			"message",
+			"repo_key",
+			"stanza"})
+)
+
+// Maximum length of error message in label.
+const checkMessageMaxLength = 200
+
+var (
+	// E.g. "P00  ERROR: [082]: WAL segment 000000010000000000000004 was not archived before the 60000ms timeout".
+	checkErrorRegexp = regexp.MustCompile(`ERROR: \[(\d+)\]: (.*)`)
+	// E.g. "repo2: unable to load info file".
+	checkRepoRegexp = regexp.MustCompile(`\brepo(\d+)\b`)
+)
+
+// CheckProbeConfig contains parameters for running pgbackrest check command periodically.
+type CheckProbeConfig struct {
+	// Config is the full path to pgBackRest configuration file.
+	Config string
+	// ConfigIncludePath is the full path to additional pgBackRest configuration files.
+	ConfigIncludePath string
+	// IncludeStanza and ExcludeStanza are the same as for collecting metrics.
+	IncludeStanza []string
+	ExcludeStanza []string
+	// Interval is the interval between runs, disabled when 0.
+	Interval time.Duration
+}
+
+// checkProbeResult is the result of pgbackrest check command for stanza.
+type checkProbeResult struct {
+	repoKeys []int
+	duration time.Duration
+	// Zero code means success.
+	code    int
+	message string
+	// Repository the error is related to, 0 if it's unknown.
+	errorRepoKey int
+}
+
+// checkProbe keeps the latest results of pgbackrest check command.
+// Mutex prevents overlapping runs.
+type checkProbe struct {
+	mu      sync.Mutex
+	results map[string]checkProbeResult
+}
+
+// StartCheckProbe runs pgbackrest check command for stanzas in the background.
+// The first run is performed after the interval, so it doesn't delay the first collection.
+func StartCheckProbe(cfg CheckProbeConfig, logger *slog.Logger) {
+	if cfg.Interval <= 0 {
+		return
+	}
+	logger.Info("Running pgbackrest check periodically", "interval", cfg.Interval)
+	probe := &checkProbe{results: make(map[string]checkProbeResult)}
+	go func() {
+		for {
+			time.Sleep(cfg.Interval)
+			probe.run(cfg, setUpMetricValue, logger)
+		}
+	}()
+}
+
+// run checks stanzas and sets metrics.
+// Stanzas with running backup are skipped, their previous results are kept,
+// because check command can fail with timeout while backup is running.
+func (probe *checkProbe) run(cfg CheckProbeConfig, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	stanzas, err := getProbeStanzas(cfg.Config, cfg.ConfigIncludePath, cfg.IncludeStanza, cfg.ExcludeStanza, logger)
+	if err != nil {
+		return
+	}
+	results := make(map[string]checkProbeResult)
+	for _, singleStanza := range stanzas {
+		if singleStanza.Status.Lock.Backup.Held {
+			logger.Info("Backup is running, pgbackrest check skipped", "stanza", singleStanza.Name)
+			if result, ok := probe.results[singleStanza.Name]; ok {
+				results[singleStanza.Name] = result
+			}
+			continue
+		}
+		results[singleStanza.Name] = runCheckCommand(cfg.Config, cfg.ConfigIncludePath, singleStanza, logger)
+	}
+	probe.results = results
+	resetCheckProbeMetrics()
+	for stanzaName, result := range results {
+		getCheckProbeMetrics(stanzaName, result, setUpMetricValueFun, logger)
+	}
+}
+
+// getProbeStanzas returns current data of stanzas for running commands on them.
+// Include and exclude lists are applied the same way as for collecting metrics.
+// Errors are logged, no stanzas are returned on error.
+func getProbeStanzas(config, configIncludePath string, includeStanzas, excludeStanzas []string, logger *slog.Logger) ([]stanza, error) {
+	var stanzas []stanza
+	for _, includeStanza := range includeStanzas {
+		if stanzaInExclude(includeStanza, excludeStanzas) {
+			continue
+		}
+		stanzaData, err := getAllInfoData(config, configIncludePath, includeStanza, "", logger)
+		if err != nil {
+			logger.Error("Get data from pgBackRest failed", "err", err)
+			return nil, err
+		}
+		parseStanzaData, err := parseResult(stanzaData)
+		if err != nil {
+			logger.Error("Parse JSON failed", "err", err)
+			return nil, err
+		}
+		for _, singleStanza := range parseStanzaData {
+			if !stanzaInExclude(singleStanza.Name, excludeStanzas) && !stanzaFilteredByRegexp(singleStanza.Name) {
+				stanzas = append(stanzas, singleStanza)
+			}
+		}
+	}
+	return stanzas, nil
+}
+
+func returnCheckExecArgs(stanza string) []string {
+	// Info messages contain repositories being checked.
+	return []string{"check", "--stanza", stanza, "--log-level-console", "info"}
+}
+
+// runCheckCommand runs pgbackrest check command for stanza.
+// Unlike other commands, output is parsed on failure too, because it contains the error.
+func runCheckCommand(config, configIncludePath string, stanzaData stanza, logger *slog.Logger) checkProbeResult {
+	args := [][]string{
+		returnCheckExecArgs(stanzaData.Name),
+		returnConfigExecArgs(config, configIncludePath),
+	}
+	cmd := execCommand(appName, concatExecArgs(args)...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	start := time.Now()
+	err := cmd.Run()
+	result := parseCheckOutput(output.String(), err)
+	result.duration = time.Since(start)
+	result.repoKeys = getStanzaRepoKeys(stanzaData)
+	if err != nil {
+		logger.Error(
+			"pgbackrest check failed",
+			"stanza", stanzaData.Name,
+			"code", result.code,
+			"err", result.message,
+		)
+	}
+	return result
+}
+
+// parseCheckOutput returns error code and message of failed check command.
+// pgBackRest exits with error code, it's used when the error is not found in output.
+// The error is related to repository only when the message mentions it. pgBackRest can fail
+// after checking repositories, e.g. on WAL switch, so the last repository being checked is not used.
+func parseCheckOutput(output string, err error) checkProbeResult {
+	var result checkProbeResult
+	if err == nil {
+		return result
+	}
+	result.message = err.Error()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.code = exitErr.ExitCode()
+	}
+	// Error code must be non-zero, e.g. when command can't be started.
+	if result.code <= 0 {
+		result.code = 1
+	}
+	for line := range strings.Lines(output) {
+		if match := checkErrorRegexp.FindStringSubmatch(line); match != nil {
+			if code, err := strconv.Atoi(match[1]); err == nil && code != 0 {
+				result.code = code
+			}
+			result.message = strings.TrimSpace(match[2])
+			if repoMatch := checkRepoRegexp.FindStringSubmatch(result.message); repoMatch != nil {
+				result.errorRepoKey, _ = strconv.Atoi(repoMatch[1])
+			}
+			break
+		}
+	}
+	return result
+}
+
+// getStanzaRepoKeys returns keys of stanza repositories.
+// For pgBackRest < v2.32 repo info is not available, only one repository is supported.
+func getStanzaRepoKeys(stanzaData stanza) []int {
+	if stanzaData.Repo == nil {
+		return []int{1}
+	}
+	repoKeys := make([]int, 0, len(*stanzaData.Repo))
+	for _, repo := range *stanzaData.Repo {
+		repoKeys = append(repoKeys, repo.Key)
+	}
+	return repoKeys
+}
+
+// Set check metrics:
+//   - pgbackrest_check_status
+//   - pgbackrest_check_duration_seconds
+//   - pgbackrest_check_error_info
+//
+// When the error is related to specific repository, only this repository is failed.
+// Otherwise, all repositories of stanza are failed.
+func getCheckProbeMetrics(stanzaName string, result checkProbeResult, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	for _, repoKey := range result.repoKeys {
+		var value float64 = 1
+		if result.code != 0 && (result.errorRepoKey == 0 || result.errorRepoKey == repoKey) {
+			value = 0
+		}
+		setUpMetric(
+			pgbrCheckStatusMetric,
+			"pgbackrest_check_status",
+			value,
+			setUpMetricValueFun,
+			logger,
+			strconv.Itoa(repoKey),
+			stanzaName,
+		)
+	}
+	setUpMetric(
+		pgbrCheckDurationMetric,
+		"pgbackrest_check_duration_seconds",
+		result.duration.Seconds(),
+		setUpMetricValueFun,
+		logger,
+		stanzaName,
+	)
+	if result.code == 0 {
+		return
+	}
+	errorRepoKey := ""
+	if result.errorRepoKey != 0 {
+		errorRepoKey = strconv.Itoa(result.errorRepoKey)
+	}
+	setUpMetric(
+		pgbrCheckErrorMetric,
+		"pgbackrest_check_error_info",
+		1,
+		setUpMetricValueFun,
+		logger,
+		strconv.Itoa(result.code),
+		truncateCheckMessage(result.message),
+		errorRepoKey,
+		stanzaName,
+	)
+}
+
+// truncateCheckMessage limits the length of error message used as label value.
+// Messages with long paths or command output are cut, the full message is logged.
+func truncateCheckMessage(message string) string {
+	if runes := []rune(message); len(runes) > checkMessageMaxLength {
+		return string(runes[:checkMessageMaxLength]) + "..."
+	}
+	return message
+}
+
+func resetCheckProbeMetrics() {
+	pgbrCheckStatusMetric.Reset()
+	pgbrCheckDurationMetric.Reset()
+	pgbrCheckErrorMetric.Reset()
+}
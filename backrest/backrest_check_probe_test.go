@@ -0,0 +1,185 @@
+package backrest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var mockDataCheck = mockStruct{}
+
+// fakeExecCommandCheck returns mockDataCheck for check command and mockData for other commands.
+func fakeExecCommandCheck(command string, args ...string) *exec.Cmd {
+	cs := make([]string, 0, 3+len(args))
+	cs = append(cs, "-test.run=TestExecCommandHelper", "--", command)
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	data := mockData
+	if slices.Contains(args, "check") {
+		data = mockDataCheck
+	}
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1",
+		"STDOUT=" + data.mockStdout,
+		"STDERR=" + data.mockStderr,
+		"EXIT_STATUS=" + strconv.Itoa(data.mockExit)}
+	return cmd
+}
+
+func TestReturnCheckExecArgs(t *testing.T) {
+	got := returnCheckExecArgs("demo")
+	want := []string{"check", "--stanza", "demo", "--log-level-console", "info"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestParseCheckOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		err    error
+		want   checkProbeResult
+	}{
+		{
+			"success",
+			"P00   INFO: check repo1 configuration (primary)\n" +
+				"P00   INFO: check repo1 archive for WAL (primary)\n",
+			nil,
+			checkProbeResult{},
+		},
+		{
+			"errorWithoutRepo",
+			"P00   INFO: check repo1 configuration (primary)\n" +
+				"P00   INFO: check repo2 configuration (primary)\n" +
+				"P00   INFO: check repo2 archive for WAL (primary)\n" +
+				"P00  ERROR: [082]: WAL segment 000000010000000000000004 was not archived before the 60000ms timeout\n" +
+				"            HINT: check the archive_command to ensure that all options are correct.\n",
+			errors.New("exit status 82"),
+			checkProbeResult{
+				code:    82,
+				message: "WAL segment 000000010000000000000004 was not archived before the 60000ms timeout",
+			},
+		},
+		{
+			"errorRepoInMessage",
+			"P00   INFO: check repo1 configuration (primary)\n" +
+				"ERROR: [055]: repo3: unable to load info file '/var/lib/pgbackrest/archive/demo/archive.info'\n",
+			errors.New("exit status 55"),
+			checkProbeResult{
+				code:         55,
+				message:      "repo3: unable to load info file '/var/lib/pgbackrest/archive/demo/archive.info'",
+				errorRepoKey: 3,
+			},
+		},
+		{
+			"errorWithoutOutput",
+			"",
+			errors.New(`exec: "pgbackrest": executable file not found in $PATH`),
+			checkProbeResult{
+				code:    1,
+				message: `exec: "pgbackrest": executable file not found in $PATH`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseCheckOutput(tt.output, tt.err)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nVariables do not match:\n%+v\nwant:\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateCheckMessage(t *testing.T) {
+	message := strings.Repeat("a", checkMessageMaxLength)
+	if got := truncateCheckMessage(message); got != message {
+		t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, message)
+	}
+	if got, want := truncateCheckMessage(message+"b"), message+"..."; got != want {
+		t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCheckProbeRun(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_check_error_info Error returned by pgbackrest check command.
+# TYPE pgbackrest_check_error_info gauge
+pgbackrest_check_error_info{code="28",message="timeout waiting for lock",repo_key="",stanza="locked"} 1
+pgbackrest_check_error_info{code="82",message="WAL segment 000000010000000000000004 was not archived before the 60000ms timeout",repo_key="",stanza="demo"} 1
+# HELP pgbackrest_check_status Result of pgbackrest check command.
+# TYPE pgbackrest_check_status gauge
+pgbackrest_check_status{repo_key="1",stanza="demo"} 0
+pgbackrest_check_status{repo_key="1",stanza="locked"} 0
+pgbackrest_check_status{repo_key="2",stanza="demo"} 0
+`
+	mockData = mockStruct{
+		`[{"name":"demo","status":{"code":0,"lock":{"backup":{"held":false}},"message":"ok"},` +
+			`"repo":[{"key":1,"status":{"code":0,"message":"ok"}},{"key":2,"status":{"code":0,"message":"ok"}}]},` +
+			`{"name":"locked","status":{"code":0,"lock":{"backup":{"held":true}},"message":"ok"},` +
+			`"repo":[{"key":1,"status":{"code":0,"message":"ok"}}]},` +
+			`{"name":"excluded","status":{"code":0,"lock":{"backup":{"held":false}},"message":"ok"}}]`,
+		"",
+		0,
+	}
+	mockDataCheck = mockStruct{
+		"P00   INFO: check repo2 archive for WAL (primary)\n",
+		"ERROR: [082]: WAL segment 000000010000000000000004 was not archived before the 60000ms timeout\n",
+		82,
+	}
+	execCommand = fakeExecCommandCheck
+	defer func() { execCommand = exec.Command }()
+	// The result for stanza with running backup is kept from the previous run.
+	probe := &checkProbe{results: map[string]checkProbeResult{
+		"locked":  {repoKeys: []int{1}, duration: time.Second, code: 28, message: "timeout waiting for lock"},
+		"removed": {repoKeys: []int{1}, duration: time.Second},
+	}}
+	probe.run(CheckProbeConfig{
+		IncludeStanza: []string{""},
+		ExcludeStanza: []string{"excluded"},
+	}, setUpMetricValue, logger)
+	if len(probe.results) != 2 {
+		t.Errorf("\nVariables do not match, results:\n%+v\nwant:\n%d", probe.results, 2)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrCheckStatusMetric,
+		pgbrCheckErrorMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
+// When data can't be received from pgBackRest, previous results are kept.
+func TestCheckProbeRunInfoFailed(t *testing.T) {
+	mockData = mockStruct{"", "", 1}
+	execCommand = fakeExecCommandCheck
+	defer func() { execCommand = exec.Command }()
+	previous := map[string]checkProbeResult{"demo": {repoKeys: []int{1}}}
+	probe := &checkProbe{results: previous}
+	probe.run(CheckProbeConfig{IncludeStanza: []string{"demo"}}, setUpMetricValue, logger)
+	if !reflect.DeepEqual(probe.results, previous) {
+		t.Errorf("\nVariables do not match:\n%+v\nwant:\n%+v", probe.results, previous)
+	}
+}
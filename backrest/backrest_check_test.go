@@ -0,0 +1,289 @@
+package backrest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var mockDataRepoLs = mockStruct{}
+
+func TestCheckStanza(t *testing.T) {
+	// The last backup 20210607-092423F is completed at 2021-06-07 09:24:26 UTC.
+	now := time.Unix(1623057866, 0).Add(48 * time.Hour)
+	walTimes := map[int]time.Time{1: now.Add(-5 * time.Minute)}
+	tests := []struct {
+		name      string
+		cfg       CheckConfig
+		stanza    stanza
+		walTimes  map[int]time.Time
+		walErr    error
+		want      CheckResult
+		wantLines string
+	}{
+		{
+			"checkOK",
+			CheckConfig{MaxFullAge: 72 * time.Hour, MaxIncrAge: 72 * time.Hour, MaxWALAge: 10 * time.Minute},
+			templateCheckStanza(false, 0),
+			walTimes,
+			nil,
+			CheckResult{
+				Code:     CheckOK,
+				Messages: []string{"stanza demo: last backup 20210607-092423F 2d 0h ago"},
+				PerfData: []string{"stanza_status=0", "repo1_status=0", "full_age=172800s;;259200", "incr_age=172800s;;259200", "repo1_wal_age=300s;;600"},
+			},
+			"PGBACKREST OK - stanza demo: last backup 20210607-092423F 2d 0h ago | stanza_status=0 repo1_status=0 full_age=172800s;;259200 incr_age=172800s;;259200 repo1_wal_age=300s;;600",
+		},
+		{
+			"checkCritical",
+			CheckConfig{MaxFullAge: 24 * time.Hour, MaxWALAge: time.Minute},
+			templateCheckStanza(true, 1),
+			walTimes,
+			nil,
+			CheckResult{
+				Code: CheckCritical,
+				Messages: []string{
+					"stanza demo: repo1 status 1 (missing stanza path)",
+					"stanza demo: backup 20210607-092423F has errors",
+					"stanza demo: full backup 2d 0h ago > 1d",
+					"stanza demo: repo1 last WAL archived 5m 0s ago > 1m",
+				},
+				PerfData: []string{"stanza_status=0", "repo1_status=1", "full_age=172800s;;86400", "repo1_wal_age=300s;;60"},
+			},
+			"",
+		},
+		{
+			"checkBackupErrors",
+			CheckConfig{},
+			templateCheckStanza(true, 0),
+			nil,
+			nil,
+			CheckResult{
+				Code:     CheckCritical,
+				Messages: []string{"stanza demo: backup 20210607-092423F has errors"},
+				PerfData: []string{"stanza_status=0", "repo1_status=0"},
+			},
+			"PGBACKREST CRITICAL - stanza demo: backup 20210607-092423F has errors | stanza_status=0 repo1_status=0",
+		},
+		{
+			"checkWarning",
+			CheckConfig{WarnFullAge: 24 * time.Hour, MaxFullAge: 72 * time.Hour, WarnWALAge: time.Minute},
+			templateCheckStanza(false, 0),
+			walTimes,
+			nil,
+			CheckResult{
+				Code: CheckWarning,
+				Messages: []string{
+					"stanza demo: full backup 2d 0h ago > 1d",
+					"stanza demo: repo1 last WAL archived 5m 0s ago > 1m",
+				},
+				PerfData: []string{"stanza_status=0", "repo1_status=0", "full_age=172800s;86400;259200", "repo1_wal_age=300s;60;"},
+			},
+			"PGBACKREST WARNING - stanza demo: full backup 2d 0h ago > 1d, stanza demo: repo1 last WAL archived 5m 0s ago > 1m | stanza_status=0 repo1_status=0 full_age=172800s;86400;259200 repo1_wal_age=300s;60;",
+		},
+		{
+			"checkWarningAndCritical",
+			CheckConfig{WarnFullAge: 24 * time.Hour, MaxFullAge: 36 * time.Hour, WarnWALAge: time.Minute, MaxWALAge: 10 * time.Minute},
+			templateCheckStanza(false, 0),
+			walTimes,
+			nil,
+			CheckResult{
+				Code: CheckCritical,
+				Messages: []string{
+					"stanza demo: full backup 2d 0h ago > 1d12h",
+					"stanza demo: repo1 last WAL archived 5m 0s ago > 1m",
+				},
+				PerfData: []string{"stanza_status=0", "repo1_status=0", "full_age=172800s;86400;129600", "repo1_wal_age=300s;60;600"},
+			},
+			"",
+		},
+		{
+			"checkUnknownWAL",
+			CheckConfig{MaxWALAge: time.Minute},
+			templateCheckStanza(false, 0),
+			nil,
+			errors.New("exit status 1"),
+			CheckResult{
+				Code:     CheckUnknown,
+				Messages: []string{"stanza demo: get WAL archive time failed: exit status 1"},
+				PerfData: []string{"stanza_status=0", "repo1_status=0"},
+			},
+			"",
+		},
+		{
+			"checkNoBackups",
+			CheckConfig{MaxDiffAge: time.Hour, MaxWALAge: time.Minute},
+			stanza{Name: "demo", Status: status{Code: 2, Message: "no valid backups"}},
+			map[int]time.Time{},
+			nil,
+			CheckResult{
+				Code: CheckCritical,
+				Messages: []string{
+					"stanza demo: stanza status 2 (no valid backups)",
+					"stanza demo: no diff backups",
+					"stanza demo: no archived WAL",
+				},
+				PerfData: []string{"stanza_status=2"},
+			},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkStanza(tt.cfg, tt.stanza, tt.walTimes, tt.walErr, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nVariables do not match:\n%#v\nwant:\n%#v", got, tt.want)
+			}
+			if tt.wantLines != "" && got.String() != tt.wantLines {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got.String(), tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	infoData := `[{"archive":[{"database":{"id":1,"repo-key":1},"id":"13-1","max":"000000010000000000000004","min":"000000010000000000000001"}],` +
+		`"backup":[{"archive":{"start":"000000010000000000000002","stop":"000000010000000000000002"},` +
+		`"backrest":{"format":5,"version":"2.45"},"database":{"id":1,"repo-key":1},"error":false,` +
+		`"info":{"delta":24316343,"repository":{"delta":2969512,"size":2969512},"size":24316343},` +
+		`"label":"20210607-092423F","lsn":{"start":"0/2000028","stop":"0/2000100"},"prior":null,"reference":null,` +
+		`"timestamp":{"start":1623057863,"stop":1623057866},"type":"full"}],` +
+		`"cipher":"none","db":[{"id":1,"repo-key":1,"system-id":6970977677138971135,"version":"13"}],"name":"demo",` +
+		`"repo":[{"cipher":"none","key":1,"status":{"code":0,"message":"ok"}}],` +
+		`"status":{"code":0,"lock":{"backup":{"held":false}},"message":"ok"}}]`
+	walTime := time.Now().Add(-2 * time.Minute).Unix()
+	repoLsData := fmt.Sprintf(`{".":{"type":"path"},"000000010000000000000004-e4bd2f1c6e20e6a2b4a1f1fcd3e1d6e7c2a5b3a4.gz":{"type":"file","size":1024,"time":%d}}`, walTime)
+	tests := []struct {
+		name         string
+		cfg          CheckConfig
+		mockInfo     mockStruct
+		mockRepoLs   mockStruct
+		wantCode     int
+		wantMessages []string
+	}{
+		{
+			"runCheckOK",
+			CheckConfig{Stanza: "demo", MaxWALAge: 10 * time.Minute},
+			mockStruct{infoData, "", 0},
+			mockStruct{repoLsData, "", 0},
+			CheckOK,
+			nil,
+		},
+		{
+			"runCheckCriticalWAL",
+			CheckConfig{Stanza: "demo", MaxWALAge: time.Minute},
+			mockStruct{infoData, "", 0},
+			mockStruct{repoLsData, "", 0},
+			CheckCritical,
+			nil,
+		},
+		{
+			"runCheckUnknownRepoLs",
+			CheckConfig{Stanza: "demo", MaxWALAge: time.Minute},
+			mockStruct{infoData, "", 0},
+			mockStruct{"", "ERROR: [031]: invalid option '--filter'", 31},
+			CheckUnknown,
+			[]string{"stanza demo: get WAL archive time failed: exit status 31"},
+		},
+		{
+			"runCheckInvalidThresholds",
+			CheckConfig{Stanza: "demo", WarnIncrAge: 48 * time.Hour, MaxIncrAge: 26 * time.Hour},
+			mockStruct{infoData, "", 0},
+			mockStruct{},
+			CheckUnknown,
+			[]string{"invalid thresholds: warning incr age 2d > maximum incr age 1d2h"},
+		},
+		{
+			"runCheckStanzaNotFound",
+			CheckConfig{Stanza: "demo2"},
+			mockStruct{infoData, "", 0},
+			mockStruct{},
+			CheckUnknown,
+			[]string{"stanza demo2 not found"},
+		},
+		{
+			"runCheckInfoFail",
+			CheckConfig{Stanza: "demo"},
+			mockStruct{"", "ERROR: [029]: missing stanza", 29},
+			mockStruct{},
+			CheckUnknown,
+			[]string{"get data from pgBackRest failed: exit status 29"},
+		},
+		{
+			"runCheckParseFail",
+			CheckConfig{Stanza: "demo"},
+			mockStruct{"[{", "", 0},
+			mockStruct{},
+			CheckUnknown,
+			[]string{"parse pgBackRest data failed: unexpected end of JSON input"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockData = tt.mockInfo
+			mockDataRepoLs = tt.mockRepoLs
+			execCommand = fakeExecCommandRepoLs
+			defer func() { execCommand = exec.Command }()
+			got := RunCheck(tt.cfg, logger)
+			if got.Code != tt.wantCode {
+				t.Errorf("\nVariables do not match:\n%d (%s)\nwant:\n%d", got.Code, got, tt.wantCode)
+			}
+			if tt.wantMessages != nil && !reflect.DeepEqual(got.Messages, tt.wantMessages) {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got.Messages, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func TestReturnRepoLsExecArgs(t *testing.T) {
+	got := returnRepoLsExecArgs(2, "archive/demo/13-1/0000000100000000", "^000000010000000000000004", true)
+	want := []string{"repo-ls", "--output", "json", "--repo", "2", "--filter", "^000000010000000000000004", "--recurse", "archive/demo/13-1/0000000100000000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+// fakeExecCommandRepoLs returns mockDataRepoLs for repo-ls command and mockData for other commands.
+func fakeExecCommandRepoLs(command string, args ...string) *exec.Cmd {
+	cs := make([]string, 0, 3+len(args))
+	cs = append(cs, "-test.run=TestExecCommandHelper", "--", command)
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	data := mockData
+	if slices.Contains(args, "repo-ls") {
+		data = mockDataRepoLs
+	}
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1",
+		"STDOUT=" + data.mockStdout,
+		"STDERR=" + data.mockStderr,
+		"EXIT_STATUS=" + strconv.Itoa(data.mockExit)}
+	return cmd
+}
+
+func templateCheckStanza(backupError bool, repoStatusCode int) stanza {
+	stanzaData := templateStanza(
+		"000000010000000000000004",
+		"000000010000000000000001",
+		[]databaseRef{{"postgres", 13425}},
+		backupError,
+		false,
+		false,
+		12,
+		100,
+		0,
+		0,
+		0,
+		0,
+		annotation{"testkey": "testvalue"})
+	if repoStatusCode != 0 {
+		(*stanzaData.Repo)[0].Status.Code = repoStatusCode
+		(*stanzaData.Repo)[0].Status.Message = "missing stanza path"
+	}
+	return stanzaData
+}
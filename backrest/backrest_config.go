@@ -0,0 +1,185 @@
+package backrest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Default locations of pgBackRest configuration.
+// See https://pgbackrest.org/configuration.html#section-general/option-config.
+const (
+	defaultBackrestConfig            = "/etc/pgbackrest/pgbackrest.conf"
+	defaultBackrestConfigLegacy      = "/etc/pgbackrest.conf"
+	defaultBackrestConfigIncludePath = "/etc/pgbackrest/conf.d"
+	globalSection                    = "global"
+)
+
+// E.g. "repo2-path" or "repo2-retention-full".
+var configRepoOptionRegexp = regexp.MustCompile(`^repo(\d+)-`)
+
+// backrestConfig contains options from pgBackRest configuration files by section.
+// Section is "global", "global:<command>", "<stanza>" or "<stanza>:<command>".
+// Option can be specified several times (e.g. recovery-option), so values are lists.
+type backrestConfig struct {
+	sections map[string]map[string][]string
+}
+
+// loadBackrestConfig reads pgBackRest configuration file and *.conf files from include path.
+// Empty values mean default locations, which are overridden by
+// PGBACKREST_CONFIG and PGBACKREST_CONFIG_INCLUDE_PATH environment variables
+// the same way as for pgbackrest command.
+// Missing default files are not an error.
+func loadBackrestConfig(config, configIncludePath string) (*backrestConfig, error) {
+	cfg := &backrestConfig{sections: make(map[string]map[string][]string)}
+	if config == "" {
+		config = os.Getenv("PGBACKREST_CONFIG")
+	}
+	if config == "" {
+		config = defaultBackrestConfig
+		if _, err := os.Stat(config); errors.Is(err, fs.ErrNotExist) {
+			config = defaultBackrestConfigLegacy
+		}
+		if err := cfg.loadFile(config); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	} else if err := cfg.loadFile(config); err != nil {
+		return nil, err
+	}
+	if configIncludePath == "" {
+		configIncludePath = os.Getenv("PGBACKREST_CONFIG_INCLUDE_PATH")
+	}
+	if configIncludePath == "" {
+		configIncludePath = defaultBackrestConfigIncludePath
+	}
+	// Files are read in alphabetical order.
+	files, err := filepath.Glob(filepath.Join(configIncludePath, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// loadFile parses INI file: "[section]" lines, "key=value" lines and "#" comments.
+func (cfg *backrestConfig) loadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	section := ""
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := cfg.sections[section]; !ok {
+				cfg.sections[section] = make(map[string][]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section == "" {
+			return fmt.Errorf("parse config file %s line %d: %q", file, lineNumber, line)
+		}
+		key = strings.TrimSpace(key)
+		cfg.sections[section][key] = append(cfg.sections[section][key], strings.TrimSpace(value))
+	}
+	return scanner.Err()
+}
+
+// get returns the value of option for stanza and command.
+// Precedence is the same as in pgBackRest: environment variable,
+// then "<stanza>:<command>", "<stanza>", "global:<command>" and "global" sections.
+// Empty stanza or command skips the corresponding sections.
+func (cfg *backrestConfig) get(stanza, command, key string) (string, bool) {
+	if value, ok := os.LookupEnv("PGBACKREST_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))); ok {
+		return value, true
+	}
+	var sections []string
+	if stanza != "" {
+		if command != "" {
+			sections = append(sections, stanza+":"+command)
+		}
+		sections = append(sections, stanza)
+	}
+	if command != "" {
+		sections = append(sections, globalSection+":"+command)
+	}
+	sections = append(sections, globalSection)
+	for _, section := range sections {
+		if values := cfg.sections[section][key]; len(values) != 0 {
+			return values[len(values)-1], true
+		}
+	}
+	return "", false
+}
+
+// stanzas returns sorted names of stanzas that have own sections.
+func (cfg *backrestConfig) stanzas() []string {
+	var result []string
+	for section := range cfg.sections {
+		name, _, _ := strings.Cut(section, ":")
+		if name != globalSection && !slices.Contains(result, name) {
+			result = append(result, name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// repoKeys returns sorted keys of repositories configured for stanza.
+// Options of repositories can be in global and stanza sections.
+// When there are no repository options, default repository 1 is used.
+func (cfg *backrestConfig) repoKeys(stanza string) []int {
+	var result []int
+	for section, options := range cfg.sections {
+		name, _, _ := strings.Cut(section, ":")
+		if name != globalSection && name != stanza {
+			continue
+		}
+		for key := range options {
+			match := configRepoOptionRegexp.FindStringSubmatch(key)
+			if match == nil {
+				continue
+			}
+			repoKey, err := strconv.Atoi(match[1])
+			if err == nil && !slices.Contains(result, repoKey) {
+				result = append(result, repoKey)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return []int{1}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// discoverStanzas returns stanzas from pgBackRest configuration that are not excluded.
+func discoverStanzas(cfg *backrestConfig, excludeStanzas []string) []string {
+	var result []string
+	for _, stanzaName := range cfg.stanzas() {
+		if !stanzaInExclude(stanzaName, excludeStanzas) && !stanzaFilteredByRegexp(stanzaName) {
+			result = append(result, stanzaName)
+		}
+	}
+	return result
+}
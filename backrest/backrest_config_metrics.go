@@ -0,0 +1,179 @@
+package backrest
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pgbrConfigStanzaInfoMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_config_stanza_info",
+		Help: "Stanza settings from pgBackRest configuration.",
+	},
+		[]string{
+			"archive_async",
+			"compress_type",
+			"stanza"})
+	pgbrConfigRepoInfoMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_config_repo_info",
+		Help: "Repository settings from pgBackRest configuration.",
+	},
+		[]string{
+			"block",
+			"bundle",
+			"cipher_type",
+			"repo_key",
+			"retention_archive_type",
+			"retention_full_type",
+			"stanza",
+			"type"})
+	pgbrConfigRepoRetentionFullMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_config_repo_retention_full",
+		Help: "Number of full backups or days to retain from pgBackRest configuration.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrConfigRepoRetentionDiffMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_config_repo_retention_diff",
+		Help: "Number of differential backups to retain from pgBackRest configuration.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrConfigRepoRetentionArchiveMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_config_repo_retention_archive",
+		Help: "Number of backups to retain WAL for from pgBackRest configuration.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+)
+
+// Default values of options.
+// See https://pgbackrest.org/configuration.html.
+var configDefaults = map[string]string{
+	"archive-async":               "n",
+	"compress-type":               "gz",
+	"repo-block":                  "n",
+	"repo-bundle":                 "n",
+	"repo-cipher-type":            "none",
+	"repo-retention-archive-type": "full",
+	"repo-retention-full-type":    "count",
+	"repo-type":                   "posix",
+}
+
+// Disabled by default.
+var configMetricsEnabled bool
+
+// SetConfigMetrics enables collecting settings from pgBackRest configuration.
+// Configuration is read to check it can be parsed.
+func SetConfigMetrics(config, configIncludePath string) error {
+	if _, err := loadBackrestConfig(config, configIncludePath); err != nil {
+		return err
+	}
+	configMetricsEnabled = true
+	return nil
+}
+
+// getConfigMetrics sets metrics for stanzas with own sections in pgBackRest configuration.
+// Include and exclude lists are applied the same way as for collecting metrics.
+func getConfigMetrics(cfg *backrestConfig, includeStanzas, excludeStanzas []string, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	includeSpecified := strings.Join(includeStanzas, "") != ""
+	for _, stanzaName := range cfg.stanzas() {
+		if stanzaInExclude(stanzaName, excludeStanzas) || stanzaFilteredByRegexp(stanzaName) ||
+			(includeSpecified && !slices.Contains(includeStanzas, stanzaName)) {
+			continue
+		}
+		setConfigStanzaMetrics(cfg, stanzaName, setUpMetricValueFun, logger)
+	}
+}
+
+// getConfigOption returns the value of option or its default value.
+// Repository options are specified as "repo-<name>" and looked up as "repo<key>-<name>".
+func getConfigOption(cfg *backrestConfig, stanzaName, command, option string, repoKey int) string {
+	key := option
+	if name, ok := strings.CutPrefix(option, "repo-"); ok {
+		key = fmt.Sprintf("repo%d-%s", repoKey, name)
+	}
+	if value, ok := cfg.get(stanzaName, command, key); ok {
+		return value
+	}
+	return configDefaults[option]
+}
+
+// Set config metrics:
+//   - pgbackrest_config_stanza_info
+//   - pgbackrest_config_repo_info
+//   - pgbackrest_config_repo_retention_full
+//   - pgbackrest_config_repo_retention_diff
+//   - pgbackrest_config_repo_retention_archive
+func setConfigStanzaMetrics(cfg *backrestConfig, stanzaName string, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	setUpMetric(
+		pgbrConfigStanzaInfoMetric,
+		"pgbackrest_config_stanza_info",
+		1,
+		setUpMetricValueFun,
+		logger,
+		getConfigOption(cfg, stanzaName, "archive-push", "archive-async", 0),
+		getConfigOption(cfg, stanzaName, "backup", "compress-type", 0),
+		stanzaName,
+	)
+	for _, repoKey := range cfg.repoKeys(stanzaName) {
+		repoKeyLabel := strconv.Itoa(repoKey)
+		setUpMetric(
+			pgbrConfigRepoInfoMetric,
+			"pgbackrest_config_repo_info",
+			1,
+			setUpMetricValueFun,
+			logger,
+			getConfigOption(cfg, stanzaName, "backup", "repo-block", repoKey),
+			getConfigOption(cfg, stanzaName, "backup", "repo-bundle", repoKey),
+			getConfigOption(cfg, stanzaName, "backup", "repo-cipher-type", repoKey),
+			repoKeyLabel,
+			getConfigOption(cfg, stanzaName, "expire", "repo-retention-archive-type", repoKey),
+			getConfigOption(cfg, stanzaName, "expire", "repo-retention-full-type", repoKey),
+			stanzaName,
+			getConfigOption(cfg, stanzaName, "backup", "repo-type", repoKey),
+		)
+		for option, metric := range map[string]*prometheus.GaugeVec{
+			"repo-retention-full":    pgbrConfigRepoRetentionFullMetric,
+			"repo-retention-diff":    pgbrConfigRepoRetentionDiffMetric,
+			"repo-retention-archive": pgbrConfigRepoRetentionArchiveMetric,
+		} {
+			// Retention options don't have default values.
+			value := getConfigOption(cfg, stanzaName, "expire", option, repoKey)
+			if value == "" {
+				continue
+			}
+			retention, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				logger.Error("Parse retention option failed", "stanza", stanzaName, "repo_key", repoKey, "option", option, "value", value, "err", err)
+				continue
+			}
+			setUpMetric(
+				metric,
+				"pgbackrest_config_"+strings.ReplaceAll(option, "-", "_"),
+				retention,
+				setUpMetricValueFun,
+				logger,
+				repoKeyLabel,
+				stanzaName,
+			)
+		}
+	}
+}
+
+func resetConfigMetrics() {
+	pgbrConfigStanzaInfoMetric.Reset()
+	pgbrConfigRepoInfoMetric.Reset()
+	pgbrConfigRepoRetentionFullMetric.Reset()
+	pgbrConfigRepoRetentionDiffMetric.Reset()
+	pgbrConfigRepoRetentionArchiveMetric.Reset()
+}
@@ -0,0 +1,91 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestGetConfigMetrics(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_config_repo_info Repository settings from pgBackRest configuration.
+# TYPE pgbackrest_config_repo_info gauge
+pgbackrest_config_repo_info{block="n",bundle="n",cipher_type="none",repo_key="1",retention_archive_type="full",retention_full_type="count",stanza="demo",type="posix"} 1
+pgbackrest_config_repo_info{block="y",bundle="y",cipher_type="aes-256-cbc",repo_key="2",retention_archive_type="full",retention_full_type="time",stanza="demo",type="s3"} 1
+# HELP pgbackrest_config_repo_retention_archive Number of backups to retain WAL for from pgBackRest configuration.
+# TYPE pgbackrest_config_repo_retention_archive gauge
+pgbackrest_config_repo_retention_archive{repo_key="1",stanza="demo"} 1
+# HELP pgbackrest_config_repo_retention_diff Number of differential backups to retain from pgBackRest configuration.
+# TYPE pgbackrest_config_repo_retention_diff gauge
+pgbackrest_config_repo_retention_diff{repo_key="1",stanza="demo"} 3
+# HELP pgbackrest_config_repo_retention_full Number of full backups or days to retain from pgBackRest configuration.
+# TYPE pgbackrest_config_repo_retention_full gauge
+pgbackrest_config_repo_retention_full{repo_key="1",stanza="demo"} 2
+pgbackrest_config_repo_retention_full{repo_key="2",stanza="demo"} 14
+# HELP pgbackrest_config_stanza_info Stanza settings from pgBackRest configuration.
+# TYPE pgbackrest_config_stanza_info gauge
+pgbackrest_config_stanza_info{archive_async="y",compress_type="zst",stanza="demo"} 1
+`
+	configFile, includePath := writeTemplateConfig(t, `[global]
+repo1-path=/var/lib/pgbackrest
+repo1-retention-full=2
+repo1-retention-diff=3
+repo1-retention-archive=1
+repo2-type=s3
+repo2-bundle=y
+repo2-block=y
+repo2-cipher-type=aes-256-cbc
+repo2-retention-full-type=time
+repo2-retention-full=14
+compress-type=zst
+
+[global:archive-push]
+archive-async=y
+`, `[demo]
+pg1-path=/var/lib/postgresql/13/main
+[excluded]
+pg1-path=/var/lib/postgresql/14/main
+`)
+	cfg, err := loadBackrestConfig(configFile, includePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetConfigMetrics()
+	getConfigMetrics(cfg, []string{""}, []string{"excluded"}, setUpMetricValue, logger)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrConfigStanzaInfoMetric,
+		pgbrConfigRepoInfoMetric,
+		pgbrConfigRepoRetentionFullMetric,
+		pgbrConfigRepoRetentionDiffMetric,
+		pgbrConfigRepoRetentionArchiveMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
+func TestSetConfigMetrics(t *testing.T) {
+	defer func() { configMetricsEnabled = false }()
+	configFile, includePath := writeTemplateConfig(t, "[global]\nrepo1-path=/var/lib/pgbackrest\n", "")
+	if err := SetConfigMetrics(configFile, includePath); err != nil || !configMetricsEnabled {
+		t.Errorf("\nConfig metrics are not enabled: %v", err)
+	}
+	configMetricsEnabled = false
+	badConfigFile, _ := writeTemplateConfig(t, "repo1-path=/var/lib/pgbackrest\n", "")
+	if err := SetConfigMetrics(badConfigFile, includePath); err == nil || configMetricsEnabled {
+		t.Errorf("\nError expected for invalid config")
+	}
+}
@@ -0,0 +1,134 @@
+package backrest
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTemplateConfig writes pgBackRest configuration file and include path with one file.
+func writeTemplateConfig(t *testing.T, config, include string) (string, string) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "pgbackrest.conf")
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	includePath := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(includePath, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(includePath, "demo.conf"), []byte(include), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Files without .conf extension are ignored.
+	if err := os.WriteFile(filepath.Join(includePath, "demo.conf.bak"), []byte("bad"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return configFile, includePath
+}
+
+func TestBackrestConfigGet(t *testing.T) {
+	configFile, includePath := writeTemplateConfig(t, `
+# Global options.
+[global]
+repo1-path=/var/lib/pgbackrest
+spool-path = /var/spool/pgbackrest
+log-level-file=detail
+
+[global:archive-push]
+spool-path=/tmp/spool-push
+`, `[demo]
+pg1-path=/var/lib/postgresql/13/main
+log-level-file=info
+[demo:archive-push]
+log-level-file=debug
+`)
+	cfg, err := loadBackrestConfig(configFile, includePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		stanza  string
+		command string
+		key     string
+		want    string
+		wantOK  bool
+	}{
+		{"global", "", "", "repo1-path", "/var/lib/pgbackrest", true},
+		{"globalCommand", "", "archive-push", "spool-path", "/tmp/spool-push", true},
+		{"globalOtherCommand", "", "archive-get", "spool-path", "/var/spool/pgbackrest", true},
+		{"stanza", "demo", "backup", "log-level-file", "info", true},
+		{"stanzaCommand", "demo", "archive-push", "log-level-file", "debug", true},
+		{"otherStanza", "demo2", "", "log-level-file", "detail", true},
+		{"missing", "demo", "", "repo1-type", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cfg.get(tt.stanza, tt.command, tt.key)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("\nVariables do not match:\n%s, %v\nwant:\n%s, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("PGBACKREST_REPO1_PATH", "/backup")
+		if got, _ := cfg.get("demo", "", "repo1-path"); got != "/backup" {
+			t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, "/backup")
+		}
+	})
+}
+
+func TestLoadBackrestConfigErrors(t *testing.T) {
+	t.Run("missingConfig", func(t *testing.T) {
+		if _, err := loadBackrestConfig(filepath.Join(t.TempDir(), "missing.conf"), t.TempDir()); err == nil {
+			t.Errorf("\nError expected for missing config file")
+		}
+	})
+	t.Run("optionWithoutSection", func(t *testing.T) {
+		configFile, includePath := writeTemplateConfig(t, "repo1-path=/var/lib/pgbackrest\n", "")
+		if _, err := loadBackrestConfig(configFile, includePath); err == nil {
+			t.Errorf("\nError expected for option without section")
+		}
+	})
+	t.Run("badLine", func(t *testing.T) {
+		configFile, includePath := writeTemplateConfig(t, "[global]\n", "[demo]\npg1-path\n")
+		if _, err := loadBackrestConfig(configFile, includePath); err == nil {
+			t.Errorf("\nError expected for line without value")
+		}
+	})
+}
+
+func TestBackrestConfigStanzasRepoKeys(t *testing.T) {
+	configFile, includePath := writeTemplateConfig(t, `[global]
+repo1-path=/var/lib/pgbackrest
+repo3-type=s3
+
+[global:archive-push]
+compress-level=3
+`, `[demo]
+pg1-path=/var/lib/postgresql/13/main
+[demo:backup]
+repo2-retention-full=2
+[demo2:archive-get]
+process-max=2
+`)
+	cfg, err := loadBackrestConfig(configFile, includePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.stanzas(), []string{"demo", "demo2"}; !slices.Equal(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	if got, want := cfg.repoKeys("demo"), []int{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	if got, want := cfg.repoKeys("demo2"), []int{1, 3}; !slices.Equal(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	empty := &backrestConfig{sections: make(map[string]map[string][]string)}
+	if got, want := empty.repoKeys("demo"), []int{1}; !slices.Equal(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
@@ -0,0 +1,409 @@
+package backrest
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of events detected between collections.
+const (
+	eventBackupNew           = "backup_new"
+	eventBackupError         = "backup_error"
+	eventBackupExpired       = "backup_expired"
+	eventStanzaStatusChanged = "stanza_status_changed"
+	eventRepoStatusChanged   = "repo_status_changed"
+	eventWALArchiveStopped   = "wal_archive_stopped"
+	// Lifecycle events are derived from backup and restore locks.
+	eventBackupStarted   = "backup_started"
+	eventBackupProgress  = "backup_progress"
+	eventBackupCompleted = "backup_completed"
+	eventBackupFailed    = "backup_failed"
+	eventRestoreStarted  = "restore_started"
+	eventRestoreFinished = "restore_finished"
+)
+
+// event describes a change of pgBackRest state between two collections.
+type event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Stanza string    `json:"stanza"`
+	// RepoKey is 0 for events related to the whole stanza.
+	RepoKey int    `json:"repo_key,omitempty"`
+	Backup  string `json:"backup,omitempty"`
+	Message string `json:"message"`
+	// Progress is set for backup lifecycle events when the size info is available.
+	Progress *eventProgress `json:"progress,omitempty"`
+}
+
+// eventProgress is the progress of running backup.
+type eventProgress struct {
+	SizeComplete int64 `json:"size_complete"`
+	SizeTotal    int64 `json:"size_total"`
+}
+
+// key identifies the event for deduplication, time is not taken into account.
+func (e event) key() string {
+	return e.Type + "/" + e.Stanza + "/" + strconv.Itoa(e.RepoKey) + "/" + e.Backup + "/" + e.Message
+}
+
+// eventSink receives events detected after each collection.
+type eventSink func(events []event)
+
+// walArchiveKey identifies the current WAL archive of stanza in repository.
+type walArchiveKey struct {
+	stanza  string
+	repoKey int
+}
+
+// walArchiveState is the state of WAL archiving tracked between collections.
+type walArchiveState struct {
+	walMax    string
+	changedAt time.Time
+	stopped   bool
+}
+
+// eventDetector compares consecutive collection snapshots and returns events.
+type eventDetector struct {
+	// WAL archiving is considered stopped if the last archived WAL segment
+	// has not changed for this time. Disabled when 0.
+	walStoppedAfter time.Duration
+	walArchives     map[walArchiveKey]walArchiveState
+}
+
+func newEventDetector(walStoppedAfter time.Duration) *eventDetector {
+	return &eventDetector{
+		walStoppedAfter: walStoppedAfter,
+		walArchives:     make(map[walArchiveKey]walArchiveState),
+	}
+}
+
+var (
+	eventsMutex    sync.Mutex
+	eventsDetector = newEventDetector(0)
+	eventSinks     []eventSink
+)
+
+// addEventSink registers a sink for events.
+func addEventSink(sink eventSink) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	eventSinks = append(eventSinks, sink)
+}
+
+// SetWALStoppedAfter sets the time after which WAL archiving is considered stopped
+// and wal_archive_stopped event is generated. Disabled when 0.
+func SetWALStoppedAfter(walStoppedAfter time.Duration) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	eventsDetector.walStoppedAfter = walStoppedAfter
+}
+
+// storeSnapshotAndNotify replaces the latest collected snapshot,
+// detects events compared to the previous snapshot and passes them to registered sinks.
+func storeSnapshotAndNotify(snapshot *collectionSnapshot) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	prev := loadSnapshot()
+	storeSnapshot(snapshot)
+	if len(eventSinks) == 0 {
+		return
+	}
+	events := eventsDetector.detect(prev, snapshot)
+	if len(events) == 0 {
+		return
+	}
+	for _, sink := range eventSinks {
+		sink(events)
+	}
+}
+
+// detect returns events for changes between prev and cur snapshots.
+// Stanzas that are absent in one of the snapshots (e.g. because of errors) are skipped,
+// so no events are generated for them. For the first collection no events are generated.
+func (d *eventDetector) detect(prev, cur *collectionSnapshot) []event {
+	if cur == nil {
+		return nil
+	}
+	var events []event
+	for _, curStanza := range cur.stanzas {
+		// The state of WAL archiving is tracked from the first collection.
+		events = append(events, d.detectWALArchiveEvents(curStanza, cur.collectedAt)...)
+		if prev == nil {
+			continue
+		}
+		prevStanza, ok := prev.getStanza(curStanza.Name)
+		if !ok {
+			continue
+		}
+		events = append(events, detectStatusEvents(prevStanza, curStanza, cur.collectedAt)...)
+		events = append(events, detectLockEvents(prevStanza, curStanza, cur.collectedAt)...)
+		events = append(events, detectBackupEvents(prevStanza, curStanza, cur.collectedAt)...)
+	}
+	return events
+}
+
+// detectStatusEvents returns events for stanza and repository status changes.
+func detectStatusEvents(prevStanza, curStanza stanza, eventTime time.Time) []event {
+	var events []event
+	if prevStanza.Status.Code != curStanza.Status.Code {
+		events = append(events, event{
+			Type:   eventStanzaStatusChanged,
+			Time:   eventTime,
+			Stanza: curStanza.Name,
+			Message: fmt.Sprintf(
+				"stanza status changed from %d (%s) to %d (%s)",
+				prevStanza.Status.Code, prevStanza.Status.Message,
+				curStanza.Status.Code, curStanza.Status.Message,
+			),
+		})
+	}
+	// For pgBackRest < v2.32 repo info is not available.
+	if prevStanza.Repo == nil || curStanza.Repo == nil {
+		return events
+	}
+	for _, curRepo := range *curStanza.Repo {
+		for _, prevRepo := range *prevStanza.Repo {
+			if prevRepo.Key != curRepo.Key || prevRepo.Status.Code == curRepo.Status.Code {
+				continue
+			}
+			events = append(events, event{
+				Type:    eventRepoStatusChanged,
+				Time:    eventTime,
+				Stanza:  curStanza.Name,
+				RepoKey: curRepo.Key,
+				Message: fmt.Sprintf(
+					"repo%d status changed from %d (%s) to %d (%s)",
+					curRepo.Key,
+					prevRepo.Status.Code, prevRepo.Status.Message,
+					curRepo.Status.Code, curRepo.Status.Message,
+				),
+			})
+		}
+	}
+	return events
+}
+
+// detectLockEvents returns backup and restore lifecycle events based on lock changes.
+// When the backup is finished, it's considered completed
+// if a new backup without errors has appeared, otherwise it's considered failed.
+// Backups started and finished between collections are reported only as new backups.
+func detectLockEvents(prevStanza, curStanza stanza, eventTime time.Time) []event {
+	var events []event
+	prevLock, curLock := prevStanza.Status.Lock, curStanza.Status.Lock
+	prevRunning, curRunning := isBackupRunning(prevStanza.Status), isBackupRunning(curStanza.Status)
+	progress := getEventProgress(curLock.Backup.SizeComplete, curLock.Backup.SizeTotal)
+	switch {
+	case !prevRunning && curRunning:
+		events = append(events, event{
+			Type:     eventBackupStarted,
+			Time:     eventTime,
+			Stanza:   curStanza.Name,
+			Message:  "backup started",
+			Progress: progress,
+		})
+	case prevRunning && curRunning:
+		prevProgress := getEventProgress(prevLock.Backup.SizeComplete, prevLock.Backup.SizeTotal)
+		if progress == nil || (prevProgress != nil && *prevProgress == *progress) {
+			break
+		}
+		events = append(events, event{
+			Type:     eventBackupProgress,
+			Time:     eventTime,
+			Stanza:   curStanza.Name,
+			Message:  "backup progress " + formatStatusPageProgress(true, curLock.Backup.SizeComplete, curLock.Backup.SizeTotal),
+			Progress: progress,
+		})
+	case prevRunning && !curRunning:
+		events = append(events, getBackupFinishedEvent(prevStanza, curStanza, eventTime))
+	}
+	switch {
+	case !prevLock.Restore.Held && curLock.Restore.Held:
+		events = append(events, event{
+			Type:    eventRestoreStarted,
+			Time:    eventTime,
+			Stanza:  curStanza.Name,
+			Message: "restore started",
+		})
+	case prevLock.Restore.Held && !curLock.Restore.Held:
+		events = append(events, event{
+			Type:    eventRestoreFinished,
+			Time:    eventTime,
+			Stanza:  curStanza.Name,
+			Message: "restore finished",
+		})
+	}
+	return events
+}
+
+// getBackupFinishedEvent returns completed or failed event for released backup lock.
+// If several backups have appeared (e.g. in different repos), the last one is reported.
+func getBackupFinishedEvent(prevStanza, curStanza stanza, eventTime time.Time) event {
+	prevBackups := getBackupsByKey(prevStanza.Backup)
+	var (
+		newBackup backup
+		found     bool
+	)
+	for _, backupData := range curStanza.Backup {
+		if _, ok := prevBackups[backupChainKey{backupData.Database.RepoKey, backupData.Label}]; !ok {
+			newBackup, found = backupData, true
+		}
+	}
+	switch {
+	case !found:
+		return event{
+			Type:    eventBackupFailed,
+			Time:    eventTime,
+			Stanza:  curStanza.Name,
+			Message: "backup lock released, but no new backup appeared",
+		}
+	case backupHasError(newBackup):
+		return event{
+			Type:    eventBackupFailed,
+			Time:    eventTime,
+			Stanza:  curStanza.Name,
+			RepoKey: newBackup.Database.RepoKey,
+			Backup:  newBackup.Label,
+			Message: fmt.Sprintf("%s backup %s in repo%d completed with errors", newBackup.Type, newBackup.Label, newBackup.Database.RepoKey),
+		}
+	default:
+		return event{
+			Type:    eventBackupCompleted,
+			Time:    eventTime,
+			Stanza:  curStanza.Name,
+			RepoKey: newBackup.Database.RepoKey,
+			Backup:  newBackup.Label,
+			Message: fmt.Sprintf("%s backup %s in repo%d completed", newBackup.Type, newBackup.Label, newBackup.Database.RepoKey),
+		}
+	}
+}
+
+// isBackupRunning returns true if the backup lock is held by backup.
+// For pgBackRest >= v2.48 the lock held with zero size is
+// the lock of expire or stanza-* commands, so it's not taken into account.
+// For earlier versions these commands can't be distinguished from backup.
+func isBackupRunning(stanzaStatus status) bool {
+	backupLock := stanzaStatus.Lock.Backup
+	if !backupLock.Held {
+		return false
+	}
+	return backupLock.SizeTotal == nil || *backupLock.SizeTotal != 0
+}
+
+// getEventProgress returns backup progress, nil if the size info is not available
+// (e.g. for pgBackRest < v2.48).
+func getEventProgress(sizeComplete, sizeTotal *int64) *eventProgress {
+	if sizeComplete == nil || sizeTotal == nil {
+		return nil
+	}
+	return &eventProgress{SizeComplete: *sizeComplete, SizeTotal: *sizeTotal}
+}
+
+// detectBackupEvents returns events for new and expired backups.
+// When repository is unavailable in one of the collections, its backups are missing in pgBackRest output,
+// so backups of this repository are not compared.
+func detectBackupEvents(prevStanza, curStanza stanza, eventTime time.Time) []event {
+	var events []event
+	prevBackups := getBackupsByKey(prevStanza.Backup)
+	curBackups := getBackupsByKey(curStanza.Backup)
+	reposAvailable := func(repoKey int) bool {
+		return isHistoryRepoAvailable(prevStanza, repoKey) && isHistoryRepoAvailable(curStanza, repoKey)
+	}
+	for _, backupData := range curStanza.Backup {
+		if _, ok := prevBackups[backupChainKey{backupData.Database.RepoKey, backupData.Label}]; ok || !reposAvailable(backupData.Database.RepoKey) {
+			continue
+		}
+		events = append(events, event{
+			Type:    eventBackupNew,
+			Time:    eventTime,
+			Stanza:  curStanza.Name,
+			RepoKey: backupData.Database.RepoKey,
+			Backup:  backupData.Label,
+			Message: fmt.Sprintf("new %s backup %s in repo%d", backupData.Type, backupData.Label, backupData.Database.RepoKey),
+		})
+		if backupHasError(backupData) {
+			events = append(events, event{
+				Type:    eventBackupError,
+				Time:    eventTime,
+				Stanza:  curStanza.Name,
+				RepoKey: backupData.Database.RepoKey,
+				Backup:  backupData.Label,
+				Message: fmt.Sprintf("backup %s in repo%d has errors", backupData.Label, backupData.Database.RepoKey),
+			})
+		}
+	}
+	for _, backupData := range prevStanza.Backup {
+		if _, ok := curBackups[backupChainKey{backupData.Database.RepoKey, backupData.Label}]; ok || !reposAvailable(backupData.Database.RepoKey) {
+			continue
+		}
+		events = append(events, event{
+			Type:    eventBackupExpired,
+			Time:    eventTime,
+			Stanza:  curStanza.Name,
+			RepoKey: backupData.Database.RepoKey,
+			Backup:  backupData.Label,
+			Message: fmt.Sprintf("%s backup %s in repo%d expired", backupData.Type, backupData.Label, backupData.Database.RepoKey),
+		})
+	}
+	return events
+}
+
+func getBackupsByKey(backups []backup) map[backupChainKey]backup {
+	result := make(map[backupChainKey]backup, len(backups))
+	for _, backupData := range backups {
+		result[backupChainKey{backupData.Database.RepoKey, backupData.Label}] = backupData
+	}
+	return result
+}
+
+// detectWALArchiveEvents tracks the last archived WAL segment for each repository
+// and returns event once when it has not changed for walStoppedAfter.
+func (d *eventDetector) detectWALArchiveEvents(stanzaData stanza, eventTime time.Time) []event {
+	if d.walStoppedAfter <= 0 {
+		return nil
+	}
+	var events []event
+	current := getCurrentWALArchives(stanzaData.Archive)
+	// Sorted for stable order of events.
+	for _, repoKey := range slices.Sorted(maps.Keys(current)) {
+		archiveData := current[repoKey]
+		key := walArchiveKey{stanzaData.Name, repoKey}
+		state, ok := d.walArchives[key]
+		if !ok || state.walMax != archiveData.WALMax {
+			d.walArchives[key] = walArchiveState{walMax: archiveData.WALMax, changedAt: eventTime}
+			continue
+		}
+		if state.stopped || eventTime.Sub(state.changedAt) < d.walStoppedAfter {
+			continue
+		}
+		state.stopped = true
+		d.walArchives[key] = state
+		events = append(events, event{
+			Type:    eventWALArchiveStopped,
+			Time:    eventTime,
+			Stanza:  stanzaData.Name,
+			RepoKey: repoKey,
+			Message: fmt.Sprintf("no WAL segments archived in repo%d since %s, the last is %s", repoKey, state.changedAt.Format(layout), state.walMax),
+		})
+	}
+	return events
+}
+
+// getCurrentWALArchives returns WAL archive for the current database of each repository.
+// Archives for previous databases (e.g. before upgrade) are not changed anymore.
+func getCurrentWALArchives(archives []archive) map[int]archive {
+	result := make(map[int]archive)
+	for _, archiveData := range archives {
+		if archiveData.WALMax == "" {
+			continue
+		}
+		current, ok := result[archiveData.Database.RepoKey]
+		if !ok || archiveData.Database.ID > current.Database.ID {
+			result[archiveData.Database.RepoKey] = archiveData
+		}
+	}
+	return result
+}
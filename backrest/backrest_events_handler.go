@@ -0,0 +1,199 @@
+package backrest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventsEndpoint is the path under which events are streamed as Server-Sent Events.
+const eventsEndpoint = "/events"
+
+const (
+	// Number of recent events kept for replay to new subscribers.
+	eventsBufferSize = 1000
+	// Number of events waiting to be written for one subscriber.
+	// Slow subscribers are disconnected and can reconnect with Last-Event-ID.
+	eventsSubscriberQueueSize = 100
+)
+
+// Interval between keepalive comments, so proxies don't close idle connections.
+var eventsKeepaliveInterval = 30 * time.Second
+
+// streamEvent is an event with sequence number used as SSE id.
+type streamEvent struct {
+	id uint64
+	event
+}
+
+// eventStream keeps recent events in a ring buffer and delivers new events to subscribers.
+type eventStream struct {
+	mu sync.Mutex
+	// Ring buffer of recent events, next points to the oldest event when the buffer is full.
+	buffer      []streamEvent
+	next        int
+	lastID      uint64
+	subscribers map[chan streamEvent]struct{}
+}
+
+var eventsStream = newEventStream(eventsBufferSize)
+
+func newEventStream(size int) *eventStream {
+	return &eventStream{
+		buffer:      make([]streamEvent, 0, size),
+		subscribers: make(map[chan streamEvent]struct{}),
+	}
+}
+
+// publish adds events to the buffer and sends them to subscribers without blocking.
+func (s *eventStream) publish(events []event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range events {
+		s.lastID++
+		item := streamEvent{s.lastID, e}
+		if len(s.buffer) < cap(s.buffer) {
+			s.buffer = append(s.buffer, item)
+		} else {
+			s.buffer[s.next] = item
+			s.next = (s.next + 1) % len(s.buffer)
+		}
+		for ch := range s.subscribers {
+			select {
+			case ch <- item:
+			default:
+				delete(s.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// subscribe returns buffered events with id greater than lastID and channel for new events.
+// The channel is closed when the subscriber is too slow.
+func (s *eventStream) subscribe(lastID uint64) ([]streamEvent, chan streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var replay []streamEvent
+	for i := range s.buffer {
+		item := s.buffer[(s.next+i)%len(s.buffer)]
+		if item.id > lastID {
+			replay = append(replay, item)
+		}
+	}
+	ch := make(chan streamEvent, eventsSubscriberQueueSize)
+	s.subscribers[ch] = struct{}{}
+	return replay, ch
+}
+
+func (s *eventStream) unsubscribe(ch chan streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// eventsFilter selects events by stanza and type, empty lists match all events.
+type eventsFilter struct {
+	stanzas []string
+	types   []string
+}
+
+func (f eventsFilter) match(e event) bool {
+	return (len(f.stanzas) == 0 || slices.Contains(f.stanzas, e.Stanza)) &&
+		(len(f.types) == 0 || slices.Contains(f.types, e.Type))
+}
+
+// eventsHandler streams events as Server-Sent Events.
+// Buffered events are replayed first: all of them for new subscribers,
+// or events after Last-Event-ID for reconnected ones.
+// Events can be filtered with comma-separated stanza and type query parameters.
+func eventsHandler(stream *eventStream, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		var lastID uint64
+		if value := r.Header.Get("Last-Event-ID"); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid Last-Event-ID %q", value), http.StatusBadRequest)
+				return
+			}
+			lastID = id
+		}
+		filter := eventsFilter{
+			stanzas: getEventsQueryValues(r, "stanza"),
+			types:   getEventsQueryValues(r, "type"),
+		}
+		replay, ch := stream.subscribe(lastID)
+		defer stream.unsubscribe(ch)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for _, item := range replay {
+			if err := writeStreamEvent(w, item, filter); err != nil {
+				logger.Debug("Write event failed", "err", err)
+				return
+			}
+		}
+		flusher.Flush()
+		keepalive := time.NewTicker(eventsKeepaliveInterval)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case item, ok := <-ch:
+				if !ok {
+					logger.Warn("Events subscriber is too slow, disconnected")
+					return
+				}
+				if err := writeStreamEvent(w, item, filter); err != nil {
+					logger.Debug("Write event failed", "err", err)
+					return
+				}
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// writeStreamEvent writes event in SSE format, events not matching filter are skipped.
+func writeStreamEvent(w http.ResponseWriter, item streamEvent, filter eventsFilter) error {
+	if !filter.match(item.event) {
+		return nil
+	}
+	data, err := json.Marshal(item.event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", item.id, item.Type, data)
+	return err
+}
+
+func getEventsQueryValues(r *http.Request, name string) []string {
+	var values []string
+	for _, value := range r.URL.Query()[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
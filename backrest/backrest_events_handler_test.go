@@ -0,0 +1,172 @@
+package backrest
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStreamRingBuffer(t *testing.T) {
+	stream := newEventStream(3)
+	for i := range 5 {
+		stream.publish([]event{{Type: eventBackupNew, Stanza: "demo", Backup: string(rune('a' + i))}})
+	}
+	replay, ch := stream.subscribe(0)
+	defer stream.unsubscribe(ch)
+	var got []uint64
+	for _, item := range replay {
+		got = append(got, item.id)
+	}
+	if want := []uint64{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	replay, ch2 := stream.subscribe(4)
+	defer stream.unsubscribe(ch2)
+	if len(replay) != 1 || replay[0].id != 5 || replay[0].Backup != "e" {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", replay, "event with id 5")
+	}
+}
+
+func TestEventStreamSlowSubscriber(t *testing.T) {
+	stream := newEventStream(1)
+	_, ch := stream.subscribe(0)
+	for range eventsSubscriberQueueSize + 1 {
+		stream.publish([]event{{Type: eventBackupNew, Stanza: "demo"}})
+	}
+	for range ch {
+	}
+	if len(stream.subscribers) != 0 {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", len(stream.subscribers), 0)
+	}
+	// Unsubscribe after disconnect is safe.
+	stream.unsubscribe(ch)
+}
+
+func TestEventsHandler(t *testing.T) {
+	eventTime := time.Unix(1623706322, 0).UTC()
+	stream := newEventStream(10)
+	stream.publish([]event{
+		{eventBackupStarted, eventTime, "demo", 0, "", "backup started", &eventProgress{0, 2048}},
+		{eventRestoreStarted, eventTime, "demo2", 0, "", "restore started", nil},
+	})
+	srv := httptest.NewServer(eventsHandler(stream, slog.New(slog.NewTextHandler(io.Discard, nil))))
+	defer srv.Close()
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+		want        []string
+	}{
+		{
+			"eventsHandlerReplay",
+			"",
+			"",
+			[]string{
+				"id: 1",
+				"event: backup_started",
+				`data: {"type":"backup_started","time":"2021-06-14T21:32:02Z","stanza":"demo","message":"backup started","progress":{"size_complete":0,"size_total":2048}}`,
+				"",
+				"id: 2",
+				"event: restore_started",
+				`data: {"type":"restore_started","time":"2021-06-14T21:32:02Z","stanza":"demo2","message":"restore started"}`,
+				"",
+				"id: 3",
+				"event: backup_completed",
+				`data: {"type":"backup_completed","time":"2021-06-14T21:32:02Z","stanza":"demo","repo_key":1,"backup":"20210607-092423F","message":"full backup 20210607-092423F in repo1 completed"}`,
+				"",
+			},
+		},
+		{
+			"eventsHandlerLastEventID",
+			"",
+			"2",
+			[]string{
+				"id: 3",
+				"event: backup_completed",
+				`data: {"type":"backup_completed","time":"2021-06-14T21:32:02Z","stanza":"demo","repo_key":1,"backup":"20210607-092423F","message":"full backup 20210607-092423F in repo1 completed"}`,
+				"",
+			},
+		},
+		{
+			"eventsHandlerFilter",
+			"?stanza=demo&type=backup_started,backup_completed",
+			"",
+			[]string{
+				"id: 1",
+				"event: backup_started",
+				`data: {"type":"backup_started","time":"2021-06-14T21:32:02Z","stanza":"demo","message":"backup started","progress":{"size_complete":0,"size_total":2048}}`,
+				"",
+				"id: 3",
+				"event: backup_completed",
+				`data: {"type":"backup_completed","time":"2021-06-14T21:32:02Z","stanza":"demo","repo_key":1,"backup":"20210607-092423F","message":"full backup 20210607-092423F in repo1 completed"}`,
+				"",
+			},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+eventsEndpoint+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, "text/event-stream")
+			}
+			// The new event is published once after the first subscriber has connected.
+			if i == 0 {
+				waitEventSubscribers(t, stream)
+				stream.publish([]event{{eventBackupCompleted, eventTime, "demo", 1, "20210607-092423F", "full backup 20210607-092423F in repo1 completed", nil}})
+			}
+			reader := bufio.NewReader(resp.Body)
+			var got []string
+			for range tt.want {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, strings.TrimSuffix(line, "\n"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestEventsHandlerBadLastEventID(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, eventsEndpoint, nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	eventsHandler(newEventStream(1), slog.New(slog.NewTextHandler(io.Discard, nil))).ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func waitEventSubscribers(t *testing.T, stream *eventStream) {
+	t.Helper()
+	for range 100 {
+		stream.mu.Lock()
+		count := len(stream.subscribers)
+		stream.mu.Unlock()
+		if count != 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no subscribers")
+}
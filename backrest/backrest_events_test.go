@@ -0,0 +1,281 @@
+package backrest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventDetector(t *testing.T) {
+	prevTime := time.Unix(1623706322, 0)
+	curTime := prevTime.Add(10 * time.Minute)
+	prevStanza := templateEventStanza()
+	curStanza := templateEventStanza()
+	curStanza.Status.Code = 2
+	curStanza.Status.Message = "no valid backups"
+	(*curStanza.Repo)[0].Status.Code = 2
+	(*curStanza.Repo)[0].Status.Message = "no valid backups"
+	newBackup := curStanza.Backup[0]
+	newBackup.Label = "20210607-092423F_20210607-092500I"
+	newBackup.Type = "incr"
+	newBackup.Error = func(b bool) *bool { return &b }(true)
+	curStanza.Backup = []backup{newBackup}
+	prev := newCollectionSnapshot(prevTime)
+	prev.addStanza(prevStanza)
+	// Stanza is absent in the current snapshot, no events for it.
+	prev.addStanza(stanza{Name: "demo2"})
+	cur := newCollectionSnapshot(curTime)
+	cur.addStanza(curStanza)
+	want := []event{
+		{eventStanzaStatusChanged, curTime, "demo", 0, "", "stanza status changed from 0 (ok) to 2 (no valid backups)", nil},
+		{eventRepoStatusChanged, curTime, "demo", 1, "", "repo1 status changed from 0 (ok) to 2 (no valid backups)", nil},
+		{eventBackupNew, curTime, "demo", 1, "20210607-092423F_20210607-092500I", "new incr backup 20210607-092423F_20210607-092500I in repo1", nil},
+		{eventBackupError, curTime, "demo", 1, "20210607-092423F_20210607-092500I", "backup 20210607-092423F_20210607-092500I in repo1 has errors", nil},
+		{eventBackupExpired, curTime, "demo", 1, "20210607-092423F", "full backup 20210607-092423F in repo1 expired", nil},
+	}
+	detector := newEventDetector(0)
+	if got := detector.detect(nil, prev); len(got) != 0 {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, nil)
+	}
+	if got := detector.detect(prev, cur); !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	if got := detector.detect(cur, cur); len(got) != 0 {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, nil)
+	}
+}
+
+func TestEventDetectorWALArchiveStopped(t *testing.T) {
+	startTime := time.Unix(1623706322, 0)
+	detector := newEventDetector(time.Hour)
+	getSnapshot := func(offset time.Duration, walMax string) *collectionSnapshot {
+		stanzaData := templateEventStanza()
+		stanzaData.Archive = []archive{
+			// Archive for previous database is not changed anymore.
+			{databaseID{1, 1}, "12-1", "000000010000000000000001", "000000010000000000000001"},
+			{databaseID{2, 1}, "13-2", walMax, "000000010000000000000001"},
+		}
+		snapshot := newCollectionSnapshot(startTime.Add(offset))
+		snapshot.addStanza(stanzaData)
+		return snapshot
+	}
+	snapshots := []*collectionSnapshot{
+		getSnapshot(0, "000000010000000000000004"),
+		getSnapshot(30*time.Minute, "000000010000000000000005"),
+		getSnapshot(80*time.Minute, "000000010000000000000005"),
+		getSnapshot(100*time.Minute, "000000010000000000000005"),
+		// Event is generated once.
+		getSnapshot(120*time.Minute, "000000010000000000000005"),
+		getSnapshot(130*time.Minute, "000000010000000000000006"),
+	}
+	want := [][]event{
+		nil,
+		nil,
+		nil,
+		{{eventWALArchiveStopped, startTime.Add(100 * time.Minute), "demo", 1, "", "no WAL segments archived in repo1 since " + startTime.Add(30*time.Minute).Format(layout) + ", the last is 000000010000000000000005", nil}},
+		nil,
+		nil,
+	}
+	var prev *collectionSnapshot
+	for i, snapshot := range snapshots {
+		if got := detector.detect(prev, snapshot); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("\nVariables do not match for snapshot %d:\n%v\nwant:\n%v", i, got, want[i])
+		}
+		prev = snapshot
+	}
+}
+
+// Backups of unavailable repo are missing in pgBackRest output,
+// it's not reported as expired and then as new after recovery.
+func TestEventDetectorRepoOutage(t *testing.T) {
+	startTime := time.Unix(1623706322, 0)
+	getSnapshot := func(offset time.Duration, repo2Status int) *collectionSnapshot {
+		stanzaData := templateEventStanza()
+		repo2Backup := stanzaData.Backup[0]
+		repo2Backup.Database.RepoKey = 2
+		repo2 := repo{Cipher: "none", Key: 2}
+		repo2.Status.Code = repo2Status
+		repo2.Status.Message = "ok"
+		if repo2Status == 0 {
+			stanzaData.Backup = append(stanzaData.Backup, repo2Backup)
+		} else {
+			repo2.Status.Message = "other"
+			stanzaData.Status.Code = 4
+			stanzaData.Status.Message = "different across repos"
+		}
+		repos := append(*stanzaData.Repo, repo2)
+		stanzaData.Repo = &repos
+		snapshot := newCollectionSnapshot(startTime.Add(offset))
+		snapshot.addStanza(stanzaData)
+		return snapshot
+	}
+	snapshots := []*collectionSnapshot{
+		getSnapshot(0, 0),
+		getSnapshot(10*time.Minute, 99),
+		getSnapshot(20*time.Minute, 0),
+	}
+	want := [][]event{
+		nil,
+		{
+			{eventStanzaStatusChanged, startTime.Add(10 * time.Minute), "demo", 0, "", "stanza status changed from 0 (ok) to 4 (different across repos)", nil},
+			{eventRepoStatusChanged, startTime.Add(10 * time.Minute), "demo", 2, "", "repo2 status changed from 0 (ok) to 99 (other)", nil},
+		},
+		{
+			{eventStanzaStatusChanged, startTime.Add(20 * time.Minute), "demo", 0, "", "stanza status changed from 4 (different across repos) to 0 (ok)", nil},
+			{eventRepoStatusChanged, startTime.Add(20 * time.Minute), "demo", 2, "", "repo2 status changed from 99 (other) to 0 (ok)", nil},
+		},
+	}
+	detector := newEventDetector(0)
+	var prev *collectionSnapshot
+	for i, snapshot := range snapshots {
+		if got := detector.detect(prev, snapshot); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("\nVariables do not match for snapshot %d:\n%v\nwant:\n%v", i, got, want[i])
+		}
+		prev = snapshot
+	}
+}
+
+func TestStoreSnapshotAndNotify(t *testing.T) {
+	defer func() {
+		eventSinks = nil
+		storeSnapshot(nil)
+	}()
+	var got []event
+	addEventSink(func(events []event) {
+		got = append(got, events...)
+	})
+	storeSnapshot(nil)
+	prev := newCollectionSnapshot(time.Unix(1623706322, 0))
+	prev.addStanza(templateEventStanza())
+	storeSnapshotAndNotify(prev)
+	cur := newCollectionSnapshot(time.Unix(1623706922, 0))
+	cur.addStanza(stanza{Name: "demo", Status: status{Code: 0, Message: "ok"}})
+	storeSnapshotAndNotify(cur)
+	if loadSnapshot() != cur {
+		t.Errorf("\nSnapshot is not stored")
+	}
+	want := []event{
+		{eventBackupExpired, time.Unix(1623706922, 0), "demo", 1, "20210607-092423F", "full backup 20210607-092423F in repo1 expired", nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func templateEventStanza() stanza {
+	return templateStanza(
+		"000000010000000000000004",
+		"000000010000000000000001",
+		[]databaseRef{{"postgres", 13425}},
+		false,
+		false,
+		false,
+		12,
+		100,
+		0,
+		0,
+		0,
+		0,
+		annotation{"testkey": "testvalue"})
+}
+
+func TestDetectLockEvents(t *testing.T) {
+	eventTime := time.Unix(1623706322, 0)
+	getStanza := func(backupLock, restoreLock bool, sizeTotal, sizeComplete int64, backups ...backup) stanza {
+		stanzaData := templateStanza(
+			"000000010000000000000004",
+			"000000010000000000000001",
+			[]databaseRef{{"postgres", 13425}},
+			false,
+			backupLock,
+			restoreLock,
+			12,
+			100,
+			sizeTotal,
+			sizeComplete,
+			0,
+			0,
+			annotation{"testkey": "testvalue"})
+		stanzaData.Backup = append(stanzaData.Backup, backups...)
+		return stanzaData
+	}
+	newBackup := templateEventStanza().Backup[0]
+	newBackup.Label = "20210607-092423F_20210607-092500I"
+	newBackup.Type = "incr"
+	errorBackup := newBackup
+	errorBackup.Error = func(b bool) *bool { return &b }(true)
+	tests := []struct {
+		name string
+		prev stanza
+		cur  stanza
+		want []event
+	}{
+		{
+			"backupStarted",
+			getStanza(false, false, 0, 0),
+			getStanza(true, false, 2048, 0),
+			[]event{{eventBackupStarted, eventTime, "demo", 0, "", "backup started", &eventProgress{0, 2048}}},
+		},
+		{
+			"backupProgress",
+			getStanza(true, false, 2048, 0),
+			getStanza(true, false, 2048, 1024),
+			[]event{{eventBackupProgress, eventTime, "demo", 0, "", "backup progress 50.0% (1024 of 2048 bytes)", &eventProgress{1024, 2048}}},
+		},
+		{
+			"backupProgressNotChanged",
+			getStanza(true, false, 2048, 1024),
+			getStanza(true, false, 2048, 1024),
+			nil,
+		},
+		{
+			"backupCompleted",
+			getStanza(true, false, 2048, 1024),
+			getStanza(false, false, 0, 0, newBackup),
+			[]event{{eventBackupCompleted, eventTime, "demo", 1, newBackup.Label, "incr backup 20210607-092423F_20210607-092500I in repo1 completed", nil}},
+		},
+		{
+			"backupCompletedExpireRunning",
+			getStanza(true, false, 2048, 1024),
+			getStanza(true, false, 0, 0, newBackup),
+			[]event{{eventBackupCompleted, eventTime, "demo", 1, newBackup.Label, "incr backup 20210607-092423F_20210607-092500I in repo1 completed", nil}},
+		},
+		{
+			"backupFailedWithErrors",
+			getStanza(true, false, 2048, 1024),
+			getStanza(false, false, 0, 0, errorBackup),
+			[]event{{eventBackupFailed, eventTime, "demo", 1, errorBackup.Label, "incr backup 20210607-092423F_20210607-092500I in repo1 completed with errors", nil}},
+		},
+		{
+			"backupFailedNoBackup",
+			getStanza(true, false, 2048, 1024),
+			getStanza(false, false, 0, 0),
+			[]event{{eventBackupFailed, eventTime, "demo", 0, "", "backup lock released, but no new backup appeared", nil}},
+		},
+		{
+			"expireRunning",
+			getStanza(false, false, 0, 0),
+			getStanza(true, false, 0, 0),
+			nil,
+		},
+		{
+			"restoreStarted",
+			getStanza(false, false, 0, 0),
+			getStanza(false, true, 0, 0),
+			[]event{{eventRestoreStarted, eventTime, "demo", 0, "", "restore started", nil}},
+		},
+		{
+			"restoreFinished",
+			getStanza(false, true, 0, 0),
+			getStanza(false, false, 0, 0),
+			[]event{{eventRestoreFinished, eventTime, "demo", 0, "", "restore finished", nil}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectLockEvents(tt.prev, tt.cur, eventTime); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
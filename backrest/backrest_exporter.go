@@ -1,13 +1,18 @@
 package backrest
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/exporter-toolkit/web"
 )
 
@@ -16,6 +21,14 @@ var (
 	webEndpoint    string
 )
 
+// chainsEndpoint is the path under which backup chains are rendered.
+const chainsEndpoint = "/chains"
+
+// exporterMetricPrefix is the prefix of metrics written to textfile or pushed to Pushgateway.
+// Go runtime and process metrics are skipped,
+// because they make no sense for one-shot runs and conflict with node_exporter metrics.
+const exporterMetricPrefix = "pgbackrest_"
+
 // BackrestExporterConfig contains additional configuration parameters for the pgBackRest exporter.
 // Fields correspond to command-line flags with default values applied when empty.
 type BackrestExporterConfig struct {
@@ -43,6 +56,12 @@ type BackrestExporterConfig struct {
 	ResetMetricsAfter bool
 	// BackupDBCountParallelProcesses is the number of parallel processes for collecting database information.
 	BackupDBCountParallelProcesses int
+	// AnomalyWindow is the number of previous backups of the same type
+	// to compare the latest backup with. Disabled when 0.
+	AnomalyWindow int
+	// DiscoverStanza enables collecting metrics for each stanza from pgBackRest configuration
+	// when specific stanzas are not included.
+	DiscoverStanza bool
 }
 
 // LogBackrestExporterConfig logs BackrestExporterConfig parameters.
@@ -98,6 +117,16 @@ func LogBackrestExporterConfig(cfg BackrestExporterConfig, logger *slog.Logger)
 			"Enabling additional labels for WAL metrics",
 			"verbose-wal", cfg.VerboseWAL)
 	}
+	if cfg.AnomalyWindow > 0 {
+		logger.Info(
+			"Comparing the latest backups with previous backups",
+			"anomaly-window", cfg.AnomalyWindow)
+	}
+	if cfg.DiscoverStanza && strings.Join(cfg.IncludeStanza, "") == "" {
+		logger.Info(
+			"Discovering stanzas from pgBackRest configuration",
+			"stanza-discovery", cfg.DiscoverStanza)
+	}
 }
 
 // SetPromPortAndPath sets HTTP endpoint parameters
@@ -118,6 +147,12 @@ func StartPromEndpoint(version string, logger *slog.Logger) {
 			logger.Error("Metric endpoint is empty", "endpoint", webEndpoint)
 		}
 		http.Handle(webEndpoint, promhttp.Handler())
+		http.Handle(chainsEndpoint, chainsHandler(logger))
+		addEventSink(eventsStream.publish)
+		http.Handle(eventsEndpoint, eventsHandler(eventsStream, logger))
+		for pattern, handler := range apiEndpoints(logger) {
+			http.Handle(pattern, handler)
+		}
 		if webEndpoint != "/" {
 			landingConfig := web.LandingConfig{
 				Name:        "pgBackRest exporter",
@@ -130,14 +165,30 @@ func StartPromEndpoint(version string, logger *slog.Logger) {
 						Address: webEndpoint,
 						Text:    "Metrics",
 					},
+					{
+						Address:     chainsEndpoint,
+						Text:        "Backup chains",
+						Description: "Backup chains rendered as HTML, Graphviz DOT or Mermaid",
+					},
+					{
+						Address:     eventsEndpoint,
+						Text:        "Events",
+						Description: "Stream of backup lifecycle events (Server-Sent Events)",
+					},
+					{
+						Address:     apiPrefix + "/stanzas",
+						Text:        "JSON API",
+						Description: "Stanzas, backups and WAL archives in JSON format",
+					},
 				},
 			}
-			landingPage, err := web.NewLandingPage(landingConfig)
-			if err != nil {
+			// Landing page is checked once at start,
+			// then it's rendered on each request with the status of stanzas.
+			if _, err := web.NewLandingPage(landingConfig); err != nil {
 				logger.Error("Error creating landing page", "err", err)
 				os.Exit(1)
 			}
-			http.Handle("/", landingPage)
+			http.Handle("/", landingPageHandler(landingConfig, logger))
 		}
 		server := &http.Server{
 			ReadHeaderTimeout: 5 * time.Second,
@@ -149,11 +200,45 @@ func StartPromEndpoint(version string, logger *slog.Logger) {
 	}(logger)
 }
 
-// GetPgBackRestInfo get and parse pgBackRest info and set metrics
-func GetPgBackRestInfo(cfg BackrestExporterConfig, logger *slog.Logger) {
+// GetPgBackRestInfo get and parse pgBackRest info and set metrics.
+// Returns an error if the data for any stanza was not collected.
+// Errors are already logged, so in loop mode the result can be ignored.
+func GetPgBackRestInfo(cfg BackrestExporterConfig, logger *slog.Logger) error {
 	// To calculate the time elapsed since the last completed full, differential or incremental backup.
 	// For all stanzas values are calculated relative to one value.
 	currentUnixTime := time.Now().Unix()
+	// Data received from pgBackRest is saved for HTTP handlers and detecting events.
+	snapshot := newCollectionSnapshot(time.Unix(currentUnixTime, 0))
+	defer storeSnapshotAndNotify(snapshot)
+	// pgBackRest configuration is read once per collection, when it's needed
+	// for discovering stanzas, spool, configuration and retention metrics.
+	// The error is logged once, nil configuration is returned.
+	getBackrestConfig := sync.OnceValue(func() *backrestConfig {
+		backrestCfg, err := loadBackrestConfig(cfg.Config, cfg.ConfigIncludePath)
+		if err != nil {
+			logger.Error("Read pgBackRest configuration failed", "err", err)
+			return nil
+		}
+		return backrestCfg
+	})
+	// Stanzas are discovered on each collection, so added and removed stanzas are taken into account.
+	// Each stanza is collected separately, so the error for one stanza doesn't affect others.
+	if cfg.DiscoverStanza && strings.Join(cfg.IncludeStanza, "") == "" {
+		backrestCfg := getBackrestConfig()
+		var stanzas []string
+		if backrestCfg != nil {
+			stanzas = discoverStanzas(backrestCfg, cfg.ExcludeStanza)
+		}
+		switch {
+		case backrestCfg == nil:
+			logger.Warn("Discover stanzas failed, collecting all stanzas")
+		case len(stanzas) == 0:
+			logger.Warn("No stanzas discovered, collecting all stanzas")
+		default:
+			cfg.IncludeStanza = stanzas
+			cfg.ResetMetricsAfter = false
+		}
+	}
 	// If specific stanzas are specified for collecting metrics,
 	// then we reset all metrics before the loop.
 	// Otherwise, it makes sense to reset the metrics after receiving data from pgBackRest,
@@ -165,7 +250,10 @@ func GetPgBackRestInfo(cfg BackrestExporterConfig, logger *slog.Logger) {
 	excludeSpecified := strings.Join(cfg.ExcludeStanza, "") != ""
 	// Loop over each stanza.
 	// If stanza not set - perform a single loop step to get metrics for all stanzas.
-	for _, stanza := range cfg.IncludeStanza {
+	// If getting data for all stanzas fails, stanzas from pgBackRest configuration are appended to the list.
+	stanzas := slices.Clone(cfg.IncludeStanza)
+	for i := 0; i < len(stanzas); i++ {
+		stanza := stanzas[i]
 		// Flag to check if pgBackRest get info for this stanza.
 		// By default, it's set to true.
 		// If we get an error from pgBackRest when getting info for stanza, flag will be set to false.
@@ -174,12 +262,31 @@ func GetPgBackRestInfo(cfg BackrestExporterConfig, logger *slog.Logger) {
 		// If stanza not set - checking for entry into the exclude list will be performed later.
 		if !stanzaInExclude(stanza, cfg.ExcludeStanza) {
 			stanzaData, err := getAllInfoData(cfg.Config, cfg.ConfigIncludePath, stanza, cfg.BackupType, logger)
+			// One broken stanza can fail the command for all stanzas,
+			// so data for each stanza is requested separately to keep metrics of healthy stanzas.
+			if err != nil && stanza == "" {
+				if fallbackStanzas := getFallbackStanzas(getBackrestConfig(), cfg.ExcludeStanza); len(fallbackStanzas) != 0 {
+					logger.Warn("Get data for all stanzas failed, getting data for each stanza", "stanzas", strings.Join(fallbackStanzas, ","), "err", err)
+					stanzas = append(stanzas, fallbackStanzas...)
+					// Metrics are reset once for all stanzas.
+					if cfg.ResetMetricsAfter {
+						resetMetrics()
+						cfg.ResetMetricsAfter = false
+					}
+					continue
+				}
+			}
 			if err != nil {
 				getDataSuccessStatus = false
+				snapshot.addError(stanza, err)
 				logger.Error("Get data from pgBackRest failed", "err", err)
 			}
 			parseStanzaData, err := parseResult(stanzaData)
 			if err != nil {
+				// Don't duplicate the error, if data was not received from pgBackRest.
+				if getDataSuccessStatus {
+					snapshot.addError(stanza, err)
+				}
 				getDataSuccessStatus = false
 				logger.Error("Parse JSON failed", "err", err)
 			}
@@ -192,20 +299,38 @@ func GetPgBackRestInfo(cfg BackrestExporterConfig, logger *slog.Logger) {
 			}
 			getExporterStatusMetrics(stanza, getDataSuccessStatus, excludeSpecified, setUpMetricValue, logger)
 			for _, singleStanza := range parseStanzaData {
-				// If stanza is in the exclude list, skip it.
-				if stanzaInExclude(singleStanza.Name, cfg.ExcludeStanza) {
+				// If stanza is in the exclude list or filtered by regular expressions, skip it.
+				if stanzaInExclude(singleStanza.Name, cfg.ExcludeStanza) || stanzaFilteredByRegexp(singleStanza.Name) {
 					continue
 				}
+				snapshot.addStanza(singleStanza)
 				getStanzaMetrics(singleStanza.Name, singleStanza.Status, setUpMetricValue, logger)
 				getRepoMetrics(singleStanza.Name, singleStanza.Repo, setUpMetricValue, logger)
 				getWALMetrics(singleStanza.Name, singleStanza.Archive, singleStanza.DB, cfg.VerboseWAL, setUpMetricValue, logger)
 				// Last backups for current stanza
 				lastBackups := getBackupMetrics(singleStanza.Name, cfg.BackupReferenceCount, singleStanza.Backup, singleStanza.DB, setUpMetricValue, logger)
+				getBackupRatioMetrics(singleStanza.Name, singleStanza.Backup, setUpMetricValue, logger)
+				// Observed backups can be forgotten only when all backups are returned.
+				getBackupHistogramMetrics(backupHistogramsObserved, singleStanza, cfg.BackupType == "", logger)
+				// Backup chains can be built only when all backups are returned.
+				// When data is collected for diff or incr backups only, prior backups are missing.
+				if cfg.BackupType == "" || cfg.BackupType == fullLabel {
+					getBackupChainMetrics(singleStanza.Name, singleStanza.Backup, setUpMetricValue, logger)
+				}
+				if cfg.AnomalyWindow > 0 {
+					getBackupAnomalyMetrics(backupAnomalySamplesStore, singleStanza.Name, cfg.AnomalyWindow, singleStanza.Backup, setUpMetricValue, logger)
+				}
+				// History is updated only when all backups are returned,
+				// otherwise filtered out backups would be considered expired.
+				if backupHistoryStore != nil && cfg.BackupType == "" {
+					getBackupHistoryMetrics(backupHistoryStore, singleStanza, currentUnixTime, setUpMetricValue, logger)
+				}
 				// If full backup exists, the values of metrics for differential and
 				// incremental backups also will be set.
 				// If not - metrics won't be set.
 				if !lastBackups.full.backupTime.IsZero() {
 					getBackupLastMetrics(singleStanza.Name, lastBackups, currentUnixTime, setUpMetricValue, logger)
+					getBackupLastRatioMetrics(singleStanza.Name, lastBackups, setUpMetricValue, logger)
 				}
 				// If the calculation of the number of databases in backups is enabled.
 				// Information about number of databases in specific backup has appeared since pgBackRest v2.41.
@@ -225,14 +350,71 @@ func GetPgBackRestInfo(cfg BackrestExporterConfig, logger *slog.Logger) {
 			// and data for this stanza is not collected.
 			// It is necessary to set zero metric value for this stanza.
 			getDataSuccessStatus = false
+			snapshot.addError(stanza, errors.New("stanza is specified in include and exclude lists"))
 			getExporterStatusMetrics(stanza, getDataSuccessStatus, excludeSpecified, setUpMetricValue, logger)
 			logger.Warn("Stanza is specified in include and exclude lists", "stanza", stanza)
 		}
 	}
+	// Stanzas absent in collection are known only when data for all of them is received.
+	if snapshot.err() == nil {
+		pruneBackupHistogramMetrics(backupHistogramsObserved, snapshot.stanzas, logger)
+	}
+	// Repository can be shared by stanzas, so the forecast is calculated for all of them.
+	// Sizes of backups are known only when all backups are returned.
+	if repoForecast != nil && cfg.BackupType == "" {
+		getRepoForecastMetrics(repoForecast, repoArchiveSizesStore, snapshot.stanzas, setUpMetricValue, logger)
+	}
+	// Spool is read locally, it doesn't depend on data from pgBackRest.
+	if spoolPath != "" {
+		// Configuration is needed for pg1-path of stanzas, status files in spool are read without it.
+		getSpoolMetrics(spoolPath, getBackrestConfig(), cfg.IncludeStanza, cfg.ExcludeStanza, currentUnixTime, setUpMetricValue, logger)
+	}
+	if configMetricsEnabled {
+		if backrestCfg := getBackrestConfig(); backrestCfg != nil {
+			getConfigMetrics(backrestCfg, cfg.IncludeStanza, cfg.ExcludeStanza, setUpMetricValue, logger)
+			// Retention is compared with all backups of stanza.
+			if cfg.BackupType == "" {
+				getRetentionMetrics(backrestCfg, snapshot.stanzas, currentUnixTime, setUpMetricValue, logger)
+			}
+		}
+	}
+	// Log metrics are not reset, lines are read once.
+	if logPath != "" {
+		getLogMetrics(logPath, cfg.IncludeStanza, cfg.ExcludeStanza, logFilesTailed, setUpMetricValue, logger)
+	}
+	saveBackupHistory(currentUnixTime, logger)
+	return snapshot.err()
+}
+
+// getFallbackStanzas returns stanzas from pgBackRest configuration for getting data for each stanza separately.
+// No stanzas are returned, if configuration can't be read.
+func getFallbackStanzas(backrestCfg *backrestConfig, excludeStanzas []string) []string {
+	if backrestCfg == nil {
+		return nil
+	}
+	return discoverStanzas(backrestCfg, excludeStanzas)
 }
 
-// GetPgBackrestVersionInfo get and parse pgBackRest version info and set metrics
-func GetPgBackrestVersionInfo(logger *slog.Logger) {
+// GetPgBackrestVersionInfo get and parse pgBackRest version info and set metrics.
+// Returns an error if the version was not collected.
+func GetPgBackrestVersionInfo(logger *slog.Logger) error {
 	resetVersionMetrics()
-	getBackrestVersionMetrics(setUpMetricValue, logger)
+	return getBackrestVersionMetrics(setUpMetricValue, logger)
+}
+
+// exporterMetricsGatherer returns gatherer with pgBackRest and exporter metrics only.
+func exporterMetricsGatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+		result := make([]*dto.MetricFamily, 0, len(mfs))
+		for _, mf := range mfs {
+			if strings.HasPrefix(mf.GetName(), exporterMetricPrefix) {
+				result = append(result, mf)
+			}
+		}
+		return result, nil
+	})
 }
@@ -22,9 +22,13 @@ func getExporterStatusMetrics(stanzaName string, getDataStatus, excludeStanzaSpe
 	// the value of the label 'stanza' will be 'all-stanzas',
 	// if the information is collected for all available stanzas except excluded,
 	// the value of the label 'stanza' will be 'all-stanzas-except-excluded',
+	// if the information is collected for all stanzas filtered by regular expressions,
+	// the value of the label 'stanza' will describe the filters,
 	// otherwise the stanza name will be set.
 	if stanzaName == "" {
-		if excludeStanzaSpecified {
+		if label := getStanzaRegexpFiltersLabel(excludeStanzaSpecified); label != "" {
+			stanzaName = label
+		} else if excludeStanzaSpecified {
 			stanzaName = "all-stanzas-except-excluded"
 		} else {
 			stanzaName = "all-stanzas"
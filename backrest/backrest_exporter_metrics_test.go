@@ -95,6 +95,34 @@ pgbackrest_exporter_status{stanza="all-stanzas"} 1
 	}
 }
 
+func TestGetExporterStatusMetricsRegexpFilters(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_exporter_status pgBackRest exporter get data status.
+# TYPE pgbackrest_exporter_status gauge
+pgbackrest_exporter_status{stanza="include-regex=^prod-;except-excluded"} 1
+`
+	defer func() { stanzaIncludeRegexp, stanzaExcludeRegexp = nil, nil }()
+	if err := SetStanzaRegexpFilters("^prod-", ""); err != nil {
+		t.Fatal(err)
+	}
+	resetExporterMetrics()
+	getExporterStatusMetrics("", true, true, setUpMetricValue, logger)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(pgbrExporterStatusMetric)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
 func TestGetExporterStatusErrorsAndDebugs(t *testing.T) {
 	type args struct {
 		stanzaName          string
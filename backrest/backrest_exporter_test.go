@@ -6,10 +6,13 @@ import (
 	"log/slog"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/exporter-toolkit/web"
 )
 
@@ -57,7 +60,7 @@ func TestGetPgBackRestInfo(t *testing.T) {
 	}{
 		{
 			"GetPgBackRestInfoGoodDataReturn",
-			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, true, true, true, false, false, 1},
+			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, true, true, true, false, false, 1, 0, false},
 			mockStruct{
 				`[{"archive":[{"database":{"id":1,"repo-key":1},"id":"13-1",` +
 					`"max":"000000010000000000000002","min":"000000010000000000000001"}],` +
@@ -75,7 +78,7 @@ func TestGetPgBackRestInfo(t *testing.T) {
 			""},
 		{
 			"GetPgBackRestInfoGoodDataReturnWithWarn",
-			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, true, true, true, false, false, 1},
+			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, true, true, true, false, false, 1, 0, false},
 			mockStruct{
 				`[{"archive":[{"database":{"id":1,"repo-key":1},"id":"13-1",` +
 					`"max":"000000010000000000000002","min":"000000010000000000000001"}],` +
@@ -93,7 +96,7 @@ func TestGetPgBackRestInfo(t *testing.T) {
 			`msg="pgBackRest message" err="WARN: environment contains invalid option 'test'`},
 		{
 			"GetPgBackRestInfoBadDataReturn",
-			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, false, false, false, false, false, 1},
+			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, false, false, false, false, false, 1, 0, false},
 			mockStruct{
 				``,
 				`msg="pgBackRest message" err="ERROR: [029]: missing '=' in key/value at line 9: test"`,
@@ -102,7 +105,7 @@ func TestGetPgBackRestInfo(t *testing.T) {
 			`msg="Get data from pgBackRest failed" err="exit status 29`},
 		{
 			"GetPgBackRestInfoZeroDataReturn",
-			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, false, false, false, false, false, 1},
+			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, false, false, false, false, false, 1, 0, false},
 			mockStruct{
 				`[]`,
 				``,
@@ -111,7 +114,7 @@ func TestGetPgBackRestInfo(t *testing.T) {
 			`msg="No backup data returned"`},
 		{
 			"GetPgBackRestInfoJsonUnmarshalFail",
-			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, false, false, false, false, false, 1},
+			BackrestExporterConfig{"", "", "", []string{""}, []string{""}, false, false, false, false, false, 1, 0, false},
 			mockStruct{
 				`[{}`,
 				``,
@@ -120,7 +123,7 @@ func TestGetPgBackRestInfo(t *testing.T) {
 			`msg="Parse JSON failed" err="unexpected end of JSON input"`},
 		{
 			"GetPgBackRestInfoEqualIncludeExcludeLists",
-			BackrestExporterConfig{"", "", "", []string{"demo"}, []string{"demo"}, false, false, false, false, false, 1},
+			BackrestExporterConfig{"", "", "", []string{"demo"}, []string{"demo"}, false, false, false, false, false, 1, 0, false},
 			mockStruct{
 				``,
 				``,
@@ -144,6 +147,95 @@ func TestGetPgBackRestInfo(t *testing.T) {
 	}
 }
 
+func TestGetPgBackRestInfoDiscoverStanza(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_exporter_status pgBackRest exporter get data status.
+# TYPE pgbackrest_exporter_status gauge
+pgbackrest_exporter_status{stanza="demo"} 1
+pgbackrest_exporter_status{stanza="demo2"} 1
+`
+	configFile, includePath := writeTemplateConfig(t, "[global]\nrepo1-path=/var/lib/pgbackrest\n[demo]\npg1-path=/var/lib/postgresql/13/main\n",
+		"[demo2]\npg1-path=/var/lib/postgresql/14/main\n[excluded]\npg1-path=/var/lib/postgresql/15/main\n")
+	resetMetrics()
+	mockData = mockStruct{`[]`, ``, 0}
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+	cfg := BackrestExporterConfig{
+		Config:            configFile,
+		ConfigIncludePath: includePath,
+		IncludeStanza:     []string{""},
+		ExcludeStanza:     []string{"excluded"},
+		ResetMetricsAfter: true,
+		DiscoverStanza:    true,
+	}
+	if err := GetPgBackRestInfo(cfg, logger); err != nil {
+		t.Errorf("\nUnexpected error: %v", err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(pgbrExporterStatusMetric)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
+func TestGetPgBackRestInfoFallbackStanza(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_exporter_status pgBackRest exporter get data status.
+# TYPE pgbackrest_exporter_status gauge
+pgbackrest_exporter_status{stanza="demo"} 1
+pgbackrest_exporter_status{stanza="demo2"} 0
+`
+	configFile, includePath := writeTemplateConfig(t, "[global]\nrepo1-path=/var/lib/pgbackrest\n[demo]\npg1-path=/var/lib/postgresql/13/main\n",
+		"[demo2]\npg1-path=/var/lib/postgresql/14/main\n")
+	resetMetrics()
+	// Command fails for all stanzas and for demo2.
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		mockData = mockStruct{`[{"name":"demo","status":{"code":0,"message":"ok"}}]`, ``, 0}
+		if !slices.Contains(args, "--stanza") || slices.Contains(args, "demo2") {
+			mockData = mockStruct{``, `ERROR: [103]: unable to find a valid repository`, 103}
+		}
+		return fakeExecCommand(command, args...)
+	}
+	defer func() { execCommand = exec.Command }()
+	cfg := BackrestExporterConfig{
+		Config:            configFile,
+		ConfigIncludePath: includePath,
+		IncludeStanza:     []string{""},
+		ExcludeStanza:     []string{""},
+		ResetMetricsAfter: true,
+	}
+	err := GetPgBackRestInfo(cfg, logger)
+	if err == nil || !strings.HasPrefix(err.Error(), "stanza demo2:") {
+		t.Errorf("\nError for demo2 expected, got: %v", err)
+	}
+	if _, ok := loadSnapshot().getStanza("demo"); !ok {
+		t.Errorf("\nData for demo is not stored")
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(pgbrExporterStatusMetric)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
 func fakeExecCommand(command string, args ...string) *exec.Cmd {
 	cs := make([]string, 0, 3+len(args))
 	cs = append(cs, "-test.run=TestExecCommandHelper", "--", command)
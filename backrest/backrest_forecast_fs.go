@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package backrest
+
+import "syscall"
+
+// getFilesystemUsage returns capacity and used space of filesystem containing path.
+// Capacity is the space available for unprivileged users plus used space.
+func getFilesystemUsage(path string) (int64, int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	blockSize := uint64(stat.Bsize)
+	used := (uint64(stat.Blocks) - uint64(stat.Bfree)) * blockSize
+	available := uint64(stat.Bavail) * blockSize
+	return int64(used + available), int64(used), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package backrest
+
+import (
+	"errors"
+	"runtime"
+)
+
+// getFilesystemUsage is not supported on this platform,
+// capacity has to be specified via --backrest.repo-capacity flag.
+func getFilesystemUsage(path string) (int64, int64, error) {
+	return 0, 0, errors.New("filesystem usage is unsupported on " + runtime.GOOS)
+}
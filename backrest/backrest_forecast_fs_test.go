@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package backrest
+
+import "testing"
+
+func TestGetFilesystemUsage(t *testing.T) {
+	capacity, used, err := getFilesystemUsage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capacity <= 0 || used < 0 || used > capacity {
+		t.Errorf("\nVariables do not match:\ncapacity %d, used %d\nwant:\n%s", capacity, used, "0 <= used <= capacity")
+	}
+	if _, _, err := getFilesystemUsage("/nonexistent/path"); err == nil {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", err, "error")
+	}
+}
@@ -0,0 +1,235 @@
+package backrest
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/alecthomas/units"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+var (
+	// Estimated from the size of backups of stanza in repo written between the oldest and the newest backups.
+	pgbrRepoGrowthMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_growth_bytes_per_day",
+		Help: "Estimated daily growth of stanza size in repository.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrRepoCapacityMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_capacity_bytes",
+		Help: "Repository capacity.",
+	},
+		[]string{"repo_key"})
+	pgbrRepoUsedMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_used_bytes",
+		Help: "Used space in repository.",
+	},
+		[]string{"repo_key"})
+	pgbrRepoDaysUntilFullMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_days_until_full",
+		Help: "Predicted number of days until repository is full.",
+	},
+		[]string{"repo_key"})
+)
+
+// repoForecastConfig contains capacity and path of repositories by repo key.
+type repoForecastConfig struct {
+	capacity map[int]int64
+	path     map[int]string
+}
+
+// Nil when forecasting is disabled.
+var repoForecast *repoForecastConfig
+
+// repoSizeKey identifies data of stanza in repository.
+type repoSizeKey struct {
+	stanza  string
+	repoKey int
+}
+
+// repoArchiveSizes contains the size of WAL archive of stanza in repository.
+// pgbackrest info doesn't return it, so it's taken from the repository storage probe.
+type repoArchiveSizes struct {
+	mu    sync.Mutex
+	sizes map[repoSizeKey]int64
+}
+
+// Sizes of WAL archive, empty when the repository storage probe is disabled.
+var repoArchiveSizesStore = &repoArchiveSizes{sizes: make(map[repoSizeKey]int64)}
+
+func (archive *repoArchiveSizes) set(stanzaName string, repoKey int, size int64) {
+	archive.mu.Lock()
+	defer archive.mu.Unlock()
+	archive.sizes[repoSizeKey{stanzaName, repoKey}] = size
+}
+
+func (archive *repoArchiveSizes) get(stanzaName string, repoKey int) int64 {
+	archive.mu.Lock()
+	defer archive.mu.Unlock()
+	return archive.sizes[repoSizeKey{stanzaName, repoKey}]
+}
+
+// SetRepoForecastConfig enables forecasting when repositories will be full.
+// Keys are repo keys, capacity values are sizes with units (e.g. 500GiB),
+// path values are paths to posix repositories for getting capacity and used space from filesystem.
+func SetRepoForecastConfig(capacity, path map[string]string) error {
+	if len(capacity) == 0 && len(path) == 0 {
+		return nil
+	}
+	cfg := &repoForecastConfig{
+		capacity: make(map[int]int64, len(capacity)),
+		path:     make(map[int]string, len(path)),
+	}
+	for key, value := range capacity {
+		repoKey, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("invalid repo key %q: %w", key, err)
+		}
+		size, err := units.ParseStrictBytes(value)
+		if err != nil {
+			return fmt.Errorf("invalid capacity %q for repo %d: %w", value, repoKey, err)
+		}
+		cfg.capacity[repoKey] = size
+	}
+	for key, value := range path {
+		repoKey, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("invalid repo key %q: %w", key, err)
+		}
+		cfg.path[repoKey] = value
+	}
+	repoForecast = cfg
+	return nil
+}
+
+// repoKeys returns sorted keys of configured repositories.
+func (cfg *repoForecastConfig) repoKeys() []int {
+	keys := slices.Collect(maps.Keys(cfg.capacity))
+	for repoKey := range cfg.path {
+		if !slices.Contains(keys, repoKey) {
+			keys = append(keys, repoKey)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// getRepoForecastMetrics sets growth of each stanza in each repository and
+// the forecast for configured repositories. Stanzas can share repository,
+// so the forecast is based on the growth of all stanzas.
+// Without repository path, used space is the size of backups and WAL archive known from the repository storage probe.
+func getRepoForecastMetrics(cfg *repoForecastConfig, archive *repoArchiveSizes, stanzas []stanza, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	repoGrowth := make(map[int]float64)
+	repoStanzasSize := make(map[int]int64)
+	for _, stanzaData := range stanzas {
+		for repoKey, backups := range getBackupsByRepo(stanzaData.Backup) {
+			repoStanzasSize[repoKey] += getBackupsRepoSize(backups) + archive.get(stanzaData.Name, repoKey)
+			growth, ok := getRepoGrowth(backups)
+			if !ok {
+				continue
+			}
+			repoGrowth[repoKey] += growth
+			setUpMetric(
+				pgbrRepoGrowthMetric,
+				"pgbackrest_repo_growth_bytes_per_day",
+				growth,
+				setUpMetricValueFun,
+				logger,
+				strconv.Itoa(repoKey),
+				stanzaData.Name,
+			)
+		}
+	}
+	for _, repoKey := range cfg.repoKeys() {
+		capacity, used := cfg.capacity[repoKey], repoStanzasSize[repoKey]
+		if path, ok := cfg.path[repoKey]; ok {
+			fsCapacity, fsUsed, err := getFilesystemUsage(path)
+			if err != nil {
+				logger.Error("Get repository filesystem usage failed", "repo_key", repoKey, "path", path, "err", err)
+				continue
+			}
+			// Configured capacity has a priority, e.g. for quotas.
+			if capacity == 0 {
+				capacity = fsCapacity
+			}
+			used = fsUsed
+		}
+		labels := []string{strconv.Itoa(repoKey)}
+		setUpMetric(pgbrRepoCapacityMetric, "pgbackrest_repo_capacity_bytes", float64(capacity), setUpMetricValueFun, logger, labels...)
+		setUpMetric(pgbrRepoUsedMetric, "pgbackrest_repo_used_bytes", float64(used), setUpMetricValueFun, logger, labels...)
+		// When backups don't grow, repository is never full.
+		growth := repoGrowth[repoKey]
+		if growth <= 0 {
+			continue
+		}
+		setUpMetric(
+			pgbrRepoDaysUntilFullMetric,
+			"pgbackrest_repo_days_until_full",
+			max(0, float64(capacity-used)/growth),
+			setUpMetricValueFun,
+			logger,
+			labels...,
+		)
+	}
+}
+
+func getBackupsByRepo(backupData []backup) map[int][]backup {
+	result := make(map[int][]backup)
+	for _, backup := range backupData {
+		result[backup.Database.RepoKey] = append(result[backup.Database.RepoKey], backup)
+	}
+	return result
+}
+
+// getBackupsRepoSize returns the size of backups in repository.
+// Each backup stores only the files changed since prior backup.
+func getBackupsRepoSize(backups []backup) int64 {
+	var size int64
+	for _, backup := range backups {
+		size += backup.Info.Repository.Delta
+	}
+	return size
+}
+
+// getRepoGrowth returns daily growth of stanza size in repository.
+// The growth is the size of backups written after the oldest backup divided by the time
+// between the oldest and the newest backups. The oldest backup was written before this period.
+// Backups are taken from pgBackRest output, so the period covers all backups within retention,
+// including full backup cycles. Expire is not taken into account.
+func getRepoGrowth(backups []backup) (float64, bool) {
+	if len(backups) < 2 {
+		return 0, false
+	}
+	oldest := slices.MinFunc(backups, func(a, b backup) int {
+		return cmp.Compare(a.Timestamp.Stop, b.Timestamp.Stop)
+	})
+	var size, newestStop int64
+	for _, backup := range backups {
+		newestStop = max(newestStop, backup.Timestamp.Stop)
+		if backup.Label != oldest.Label {
+			size += backup.Info.Repository.Delta
+		}
+	}
+	span := newestStop - oldest.Timestamp.Stop
+	if span <= 0 {
+		return 0, false
+	}
+	return float64(size) * secondsPerDay / float64(span), true
+}
+
+func resetRepoForecastMetrics() {
+	pgbrRepoGrowthMetric.Reset()
+	pgbrRepoCapacityMetric.Reset()
+	pgbrRepoUsedMetric.Reset()
+	pgbrRepoDaysUntilFullMetric.Reset()
+}
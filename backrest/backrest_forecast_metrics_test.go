@@ -0,0 +1,169 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Repo is shared by two stanzas:
+//   - demo has two backups one day apart, 300 bytes are written after the oldest one, and 100 bytes of WAL archive;
+//   - demo2 has one backup, so its growth is unknown.
+func TestGetRepoForecastMetrics(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_repo_capacity_bytes Repository capacity.
+# TYPE pgbackrest_repo_capacity_bytes gauge
+pgbackrest_repo_capacity_bytes{repo_key="1"} 3500
+pgbackrest_repo_capacity_bytes{repo_key="3"} 1000
+# HELP pgbackrest_repo_days_until_full Predicted number of days until repository is full.
+# TYPE pgbackrest_repo_days_until_full gauge
+pgbackrest_repo_days_until_full{repo_key="1"} 8
+# HELP pgbackrest_repo_growth_bytes_per_day Estimated daily growth of stanza size in repository.
+# TYPE pgbackrest_repo_growth_bytes_per_day gauge
+pgbackrest_repo_growth_bytes_per_day{repo_key="1",stanza="demo"} 300
+# HELP pgbackrest_repo_used_bytes Used space in repository.
+# TYPE pgbackrest_repo_used_bytes gauge
+pgbackrest_repo_used_bytes{repo_key="1"} 1100
+pgbackrest_repo_used_bytes{repo_key="3"} 0
+`
+	cfg := &repoForecastConfig{
+		capacity: map[int]int64{1: 3500, 3: 1000},
+		path:     map[int]string{},
+	}
+	archive := &repoArchiveSizes{sizes: map[repoSizeKey]int64{{"demo", 1}: 100}}
+	resetRepoForecastMetrics()
+	getRepoForecastMetrics(cfg, archive, []stanza{
+		{Name: "demo", Backup: parseTemplateBackups(`[` +
+			templateForecastBackup("20210607-092423F", 1000, 200, 1623057866) + `,` +
+			templateForecastBackup("20210608-092423F", 1000, 300, 1623144266) + `]`)},
+		{Name: "demo2", Backup: parseTemplateBackups(`[` +
+			templateForecastBackup("20210607-092423F", 5000, 500, 1623057866) + `]`)},
+	}, setUpMetricValue, logger)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrRepoGrowthMetric,
+		pgbrRepoCapacityMetric,
+		pgbrRepoUsedMetric,
+		pgbrRepoDaysUntilFullMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
+func TestGetRepoGrowth(t *testing.T) {
+	tests := []struct {
+		name    string
+		backups string
+		want    float64
+		wantOK  bool
+	}{
+		{
+			"oneBackup",
+			templateForecastBackup("20210601-092423F", 1000, 700, 1622539466),
+			0,
+			false,
+		},
+		{
+			"sameStopTime",
+			templateForecastBackup("20210601-092423F", 1000, 700, 1622539466) + `,` +
+				templateForecastBackup("20210601-092423F_20210601-092424I", 1000, 70, 1622539466),
+			0,
+			false,
+		},
+		// Weekly full backup cycle with daily incremental backups.
+		{
+			"fullBackupCycle",
+			templateForecastBackup("20210601-092423F", 1000, 700, 1622539466) + `,` +
+				templateForecastBackup("20210601-092423F_20210602-092423I", 1000, 70, 1622539466+secondsPerDay) + `,` +
+				templateForecastBackup("20210601-092423F_20210604-092423I", 1000, 70, 1622539466+3*secondsPerDay) + `,` +
+				templateForecastBackup("20210608-092423F", 1000, 700, 1622539466+7*secondsPerDay),
+			120,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := getRepoGrowth(parseTemplateBackups(`[` + tt.backups + `]`))
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("\nVariables do not match:\n%v %v\nwant:\n%v %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetRepoForecastConfig(t *testing.T) {
+	defer func() { repoForecast = nil }()
+	tests := []struct {
+		name     string
+		capacity map[string]string
+		path     map[string]string
+		want     *repoForecastConfig
+		wantErr  bool
+	}{
+		{
+			"disabled",
+			map[string]string{},
+			map[string]string{},
+			nil,
+			false,
+		},
+		{
+			"capacityAndPath",
+			map[string]string{"1": "1GiB", "2": "1GB"},
+			map[string]string{"2": "/var/lib/pgbackrest"},
+			&repoForecastConfig{
+				capacity: map[int]int64{1: 1 << 30, 2: 1000000000},
+				path:     map[int]string{2: "/var/lib/pgbackrest"},
+			},
+			false,
+		},
+		{
+			"badRepoKey",
+			map[string]string{"repo1": "1GiB"},
+			map[string]string{},
+			nil,
+			true,
+		},
+		{
+			"badCapacity",
+			map[string]string{"1": "lots"},
+			map[string]string{},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repoForecast = nil
+			err := SetRepoForecastConfig(tt.capacity, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nVariables do not match:\n%v\nwant error:\n%v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(repoForecast, tt.want) {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", repoForecast, tt.want)
+			}
+		})
+	}
+}
+
+func templateForecastBackup(label string, size, repoDelta, stop int64) string {
+	return fmt.Sprintf(
+		`{"label":"%s","type":"full","database":{"id":1,"repo-key":1},"error":false,`+
+			`"info":{"delta":%d,"repository":{"delta":%d,"size":%d},"size":%d},`+
+			`"timestamp":{"start":%d,"stop":%d}}`,
+		label, size, repoDelta, repoDelta, size, stop-60, stop)
+}
@@ -0,0 +1,197 @@
+package backrest
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Upper bound of growth factor between neighbouring buckets.
+	histogramBucketFactor = 1.1
+	// Resolution is reduced when number of buckets exceeds the limit.
+	histogramMaxBucketNumber = 100
+)
+
+var (
+	// Classic buckets for Prometheus without native histograms support.
+	// Durations from 1 minute to 34 hours.
+	histogramDurationBuckets = prometheus.ExponentialBuckets(60, 2, 12)
+	// Sizes from 1MiB to 4TiB.
+	histogramBytesBuckets = prometheus.ExponentialBuckets(1<<20, 4, 12)
+)
+
+var (
+	// Histograms have both native and classic buckets.
+	// They accumulate values over the exporter lifetime and are not reset between collections.
+	// Histograms of stanzas absent in collection are deleted.
+	pgbrStanzaBackupDurationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                           "pgbackrest_stanza_backup_duration_seconds",
+		Help:                           "Distribution of backup durations.",
+		Buckets:                        histogramDurationBuckets,
+		NativeHistogramBucketFactor:    histogramBucketFactor,
+		NativeHistogramMaxBucketNumber: histogramMaxBucketNumber,
+	},
+		[]string{
+			"backup_type",
+			"repo_key",
+			"stanza"})
+	pgbrStanzaBackupDeltaHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                           "pgbackrest_stanza_backup_delta_bytes",
+		Help:                           "Distribution of amount of data in the database to actually backup.",
+		Buckets:                        histogramBytesBuckets,
+		NativeHistogramBucketFactor:    histogramBucketFactor,
+		NativeHistogramMaxBucketNumber: histogramMaxBucketNumber,
+	},
+		[]string{
+			"backup_type",
+			"repo_key",
+			"stanza"})
+	pgbrStanzaBackupRepoDeltaHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                           "pgbackrest_stanza_backup_repo_delta_bytes",
+		Help:                           "Distribution of compressed files size in backups.",
+		Buckets:                        histogramBytesBuckets,
+		NativeHistogramBucketFactor:    histogramBucketFactor,
+		NativeHistogramMaxBucketNumber: histogramMaxBucketNumber,
+	},
+		[]string{
+			"backup_type",
+			"repo_key",
+			"stanza"})
+)
+
+// observedBackupKey identifies backup observed in histograms.
+type observedBackupKey struct {
+	stanza  string
+	repoKey int
+	label   string
+}
+
+// observedBackups contains backups already observed in histograms,
+// so each backup is observed once.
+type observedBackups struct {
+	mu      sync.Mutex
+	backups map[observedBackupKey]struct{}
+	// Stanzas having histograms.
+	stanzas map[string]struct{}
+}
+
+var backupHistogramsObserved = &observedBackups{
+	backups: make(map[observedBackupKey]struct{}),
+	stanzas: make(map[string]struct{}),
+}
+
+// getBackupHistogramMetrics observes new backups of stanza in histograms:
+//   - pgbackrest_stanza_backup_duration_seconds
+//   - pgbackrest_stanza_backup_delta_bytes
+//   - pgbackrest_stanza_backup_repo_delta_bytes
+//
+// When prune is true, the list of backups is complete and
+// backups absent in available repositories are forgotten.
+func getBackupHistogramMetrics(observed *observedBackups, stanzaData stanza, prune bool, logger *slog.Logger) {
+	observed.mu.Lock()
+	defer observed.mu.Unlock()
+	current := make(map[observedBackupKey]struct{}, len(stanzaData.Backup))
+	for _, backup := range stanzaData.Backup {
+		key := observedBackupKey{stanzaData.Name, backup.Database.RepoKey, backup.Label}
+		current[key] = struct{}{}
+		if _, ok := observed.backups[key]; ok {
+			continue
+		}
+		observed.backups[key] = struct{}{}
+		observed.stanzas[stanzaData.Name] = struct{}{}
+		labels := []string{
+			backup.Type,
+			strconv.Itoa(backup.Database.RepoKey),
+			stanzaData.Name,
+		}
+		observeMetric(
+			pgbrStanzaBackupDurationHistogram,
+			"pgbackrest_stanza_backup_duration_seconds",
+			float64(backup.Timestamp.Stop-backup.Timestamp.Start),
+			logger,
+			labels...,
+		)
+		observeMetric(
+			pgbrStanzaBackupDeltaHistogram,
+			"pgbackrest_stanza_backup_delta_bytes",
+			float64(backup.Info.Delta),
+			logger,
+			labels...,
+		)
+		observeMetric(
+			pgbrStanzaBackupRepoDeltaHistogram,
+			"pgbackrest_stanza_backup_repo_delta_bytes",
+			float64(backup.Info.Repository.Delta),
+			logger,
+			labels...,
+		)
+	}
+	if !prune {
+		return
+	}
+	// Expired backups never return, so the set doesn't grow over time.
+	// Backups of unavailable repositories are kept to avoid observing them twice.
+	for key := range observed.backups {
+		if key.stanza != stanzaData.Name || !isHistoryRepoAvailable(stanzaData, key.repoKey) {
+			continue
+		}
+		if _, ok := current[key]; !ok {
+			delete(observed.backups, key)
+		}
+	}
+}
+
+// pruneBackupHistogramMetrics deletes histograms of stanzas absent in collection,
+// e.g. removed from pgBackRest or excluded.
+// Backups of these stanzas are forgotten, so they are observed again if stanza returns.
+func pruneBackupHistogramMetrics(observed *observedBackups, stanzas []stanza, logger *slog.Logger) {
+	observed.mu.Lock()
+	defer observed.mu.Unlock()
+	present := make(map[string]struct{}, len(stanzas))
+	for _, stanzaData := range stanzas {
+		present[stanzaData.Name] = struct{}{}
+	}
+	for stanzaName := range observed.stanzas {
+		if _, ok := present[stanzaName]; ok {
+			continue
+		}
+		logger.Debug("Delete histograms of absent stanza", "stanza", stanzaName)
+		for _, metric := range []*prometheus.HistogramVec{
+			pgbrStanzaBackupDurationHistogram,
+			pgbrStanzaBackupDeltaHistogram,
+			pgbrStanzaBackupRepoDeltaHistogram,
+		} {
+			metric.DeletePartialMatch(prometheus.Labels{"stanza": stanzaName})
+		}
+		for key := range observed.backups {
+			if key.stanza == stanzaName {
+				delete(observed.backups, key)
+			}
+		}
+		delete(observed.stanzas, stanzaName)
+	}
+}
+
+func observeMetric(metric *prometheus.HistogramVec, metricName string, value float64, logger *slog.Logger, labels ...string) {
+	logger.Debug(
+		"Observe metric",
+		"metric", metricName,
+		"value", value,
+		"labels", strings.Join(labels, ","),
+	)
+	observer, err := metric.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		logger.Error(
+			"Metric observe failed",
+			"metric", metricName,
+			"err", err,
+		)
+		return
+	}
+	observer.Observe(value)
+}
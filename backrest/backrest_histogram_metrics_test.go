@@ -0,0 +1,177 @@
+package backrest
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type histogramSample struct {
+	count uint64
+	sum   float64
+}
+
+func gatherHistogramSamples(t *testing.T, metric *prometheus.HistogramVec) map[string]histogramSample {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metric)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]histogramSample)
+	for _, mf := range metricFamily {
+		for _, m := range mf.GetMetric() {
+			var key string
+			for _, label := range m.GetLabel() {
+				key += label.GetName() + "=" + label.GetValue() + ","
+			}
+			histogram := m.GetHistogram()
+			// Histogram has both native schema and classic buckets.
+			if histogram.Schema == nil || len(histogram.GetBucket()) != 12 {
+				t.Errorf("\nHistogram %s has no native or classic buckets", mf.GetName())
+			}
+			result[key] = histogramSample{histogram.GetSampleCount(), histogram.GetSampleSum()}
+		}
+	}
+	return result
+}
+
+func TestGetBackupHistogramMetrics(t *testing.T) {
+	pgbrStanzaBackupDurationHistogram.Reset()
+	pgbrStanzaBackupDeltaHistogram.Reset()
+	pgbrStanzaBackupRepoDeltaHistogram.Reset()
+	observed := &observedBackups{
+		backups: make(map[observedBackupKey]struct{}),
+		stanzas: make(map[string]struct{}),
+	}
+	stanzaData := stanza{
+		Name: "demo",
+		Backup: parseTemplateBackups(`[` +
+			`{"label":"20210607-092423F","type":"full","database":{"id":1,"repo-key":1},` +
+			`"info":{"delta":10000,"repository":{"delta":1000,"size":1000},"size":10000},` +
+			`"timestamp":{"start":1623057863,"stop":1623057867}},` +
+			`{"label":"20210607-092423F_20210607-092500I","prior":"20210607-092423F","type":"incr",` +
+			`"database":{"id":1,"repo-key":1},` +
+			`"info":{"delta":1000,"repository":{"delta":100,"size":1000},"size":10000},` +
+			`"timestamp":{"start":1623057900,"stop":1623057902}}]`),
+		Status: status{Code: 0},
+	}
+	// The same backups returned by the next collection must not be observed again.
+	getBackupHistogramMetrics(observed, stanzaData, true, logger)
+	getBackupHistogramMetrics(observed, stanzaData, true, logger)
+	// New full backup, the first one is expired.
+	stanzaData.Backup = append(stanzaData.Backup[1:], parseTemplateBackups(`[`+
+		`{"label":"20210608-092423F","type":"full","database":{"id":1,"repo-key":1},`+
+		`"info":{"delta":12000,"repository":{"delta":1200,"size":1200},"size":12000},`+
+		`"timestamp":{"start":1623144263,"stop":1623144269}}]`)...)
+	getBackupHistogramMetrics(observed, stanzaData, true, logger)
+	if len(observed.backups) != 2 {
+		t.Errorf("\nVariables do not match, observed backups:\n%d\nwant:\n%d", len(observed.backups), 2)
+	}
+	testCases := []struct {
+		name   string
+		metric *prometheus.HistogramVec
+		want   map[string]histogramSample
+	}{
+		{
+			"duration",
+			pgbrStanzaBackupDurationHistogram,
+			map[string]histogramSample{
+				"backup_type=full,repo_key=1,stanza=demo,": {2, 10},
+				"backup_type=incr,repo_key=1,stanza=demo,": {1, 2},
+			},
+		},
+		{
+			"delta",
+			pgbrStanzaBackupDeltaHistogram,
+			map[string]histogramSample{
+				"backup_type=full,repo_key=1,stanza=demo,": {2, 22000},
+				"backup_type=incr,repo_key=1,stanza=demo,": {1, 1000},
+			},
+		},
+		{
+			"repoDelta",
+			pgbrStanzaBackupRepoDeltaHistogram,
+			map[string]histogramSample{
+				"backup_type=full,repo_key=1,stanza=demo,": {2, 2200},
+				"backup_type=incr,repo_key=1,stanza=demo,": {1, 100},
+			},
+		},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := gatherHistogramSamples(t, tt.metric)
+			if len(got) != len(tt.want) {
+				t.Errorf("\nVariables do not match, series:\n%v\nwant:\n%v", got, tt.want)
+			}
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("\nVariables do not match, %s:\n%v\nwant:\n%v", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+// Backups of unavailable repository are missing in pgBackRest output.
+// They must not be forgotten, otherwise they are observed again when repository is available.
+func TestGetBackupHistogramMetricsRepoUnavailable(t *testing.T) {
+	pgbrStanzaBackupDurationHistogram.Reset()
+	pgbrStanzaBackupDeltaHistogram.Reset()
+	pgbrStanzaBackupRepoDeltaHistogram.Reset()
+	observed := &observedBackups{
+		backups: make(map[observedBackupKey]struct{}),
+		stanzas: make(map[string]struct{}),
+	}
+	backups := parseTemplateBackups(`[` +
+		`{"label":"20210607-092423F","type":"full","database":{"id":1,"repo-key":1},` +
+		`"info":{"delta":10000,"repository":{"delta":1000,"size":1000},"size":10000},` +
+		`"timestamp":{"start":1623057863,"stop":1623057867}}]`)
+	getBackupHistogramMetrics(observed, stanza{Name: "demo", Backup: backups}, true, logger)
+	getBackupHistogramMetrics(observed, stanza{Name: "demo", Status: status{Code: 99}}, true, logger)
+	getBackupHistogramMetrics(observed, stanza{Name: "demo", Backup: backups}, true, logger)
+	got := gatherHistogramSamples(t, pgbrStanzaBackupDurationHistogram)
+	want := histogramSample{1, 4}
+	if got["backup_type=full,repo_key=1,stanza=demo,"] != want {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+// Histograms of stanza absent in collection are deleted,
+// its backups are observed again when stanza returns.
+func TestPruneBackupHistogramMetrics(t *testing.T) {
+	pgbrStanzaBackupDurationHistogram.Reset()
+	pgbrStanzaBackupDeltaHistogram.Reset()
+	pgbrStanzaBackupRepoDeltaHistogram.Reset()
+	observed := &observedBackups{
+		backups: make(map[observedBackupKey]struct{}),
+		stanzas: make(map[string]struct{}),
+	}
+	backups := parseTemplateBackups(`[` +
+		`{"label":"20210607-092423F","type":"full","database":{"id":1,"repo-key":1},` +
+		`"info":{"delta":10000,"repository":{"delta":1000,"size":1000},"size":10000},` +
+		`"timestamp":{"start":1623057863,"stop":1623057867}}]`)
+	demo := stanza{Name: "demo", Backup: backups}
+	demo2 := stanza{Name: "demo2", Backup: backups}
+	getBackupHistogramMetrics(observed, demo, true, logger)
+	getBackupHistogramMetrics(observed, demo2, true, logger)
+	pruneBackupHistogramMetrics(observed, []stanza{demo}, logger)
+	want := map[string]histogramSample{"backup_type=full,repo_key=1,stanza=demo,": {1, 4}}
+	for _, metric := range []*prometheus.HistogramVec{
+		pgbrStanzaBackupDurationHistogram,
+		pgbrStanzaBackupDeltaHistogram,
+		pgbrStanzaBackupRepoDeltaHistogram,
+	} {
+		if got := gatherHistogramSamples(t, metric); len(got) != 1 {
+			t.Errorf("\nVariables do not match, series:\n%v\nwant:\n%v", got, want)
+		}
+	}
+	if len(observed.backups) != 1 || len(observed.stanzas) != 1 {
+		t.Errorf("\nVariables do not match, observed backups:\n%d\nwant:\n%d", len(observed.backups), 1)
+	}
+	getBackupHistogramMetrics(observed, demo2, true, logger)
+	want["backup_type=full,repo_key=1,stanza=demo2,"] = histogramSample{1, 4}
+	if got := gatherHistogramSamples(t, pgbrStanzaBackupDurationHistogram); len(got) != 2 || got["backup_type=full,repo_key=1,stanza=demo2,"] != want["backup_type=full,repo_key=1,stanza=demo2,"] {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
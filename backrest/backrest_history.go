@@ -0,0 +1,387 @@
+package backrest
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Backups that disappeared from pgBackRest output in the regular way,
+	// i.e. no remaining backups depend on them.
+	pgbrHistoryExpiredBackupsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgbackrest_history_expired_backups_total",
+		Help: "Number of backups found expired by comparing with the history.",
+	},
+		[]string{
+			"backup_type",
+			"repo_key",
+			"stanza"})
+	pgbrHistoryOldestExpiredBackupMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_history_oldest_expired_backup_seconds",
+		Help: "Time since the oldest expired backup recorded in the history was completed.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	// Backups that disappeared while remaining backups still depend on them.
+	// pgBackRest expires dependent backups together, so it's likely manual deletion.
+	pgbrHistoryBackupVanishedMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_history_backup_vanished_status",
+		Help: "Backup disappeared while newer backups in its chain still exist.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"repo_key",
+			"stanza"})
+)
+
+// historyRecord is a backup seen in pgBackRest output, one JSON line in the history file.
+type historyRecord struct {
+	Stanza    string    `json:"stanza"`
+	RepoKey   int       `json:"repo_key"`
+	Label     string    `json:"label"`
+	Type      string    `json:"type"`
+	Prior     string    `json:"prior,omitempty"`
+	StartTime int64     `json:"start_time"`
+	StopTime  int64     `json:"stop_time"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// ExpiredAt is the time of the first collection when the backup was absent.
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+	Vanished  bool       `json:"vanished,omitempty"`
+}
+
+// historyKey identifies backup in the history.
+type historyKey struct {
+	stanza  string
+	repoKey int
+	label   string
+}
+
+// backupHistory is the on-disk history of backups.
+// It's loaded once at start and rewritten after collections which changed it.
+type backupHistory struct {
+	mu   sync.Mutex
+	file string
+	// Records not seen for longer than retention are removed. Disabled when 0.
+	retention time.Duration
+	records   map[historyKey]*historyRecord
+	// Changes of last_seen only don't require rewriting the file.
+	changed bool
+}
+
+// Nil when the history is disabled.
+var backupHistoryStore *backupHistory
+
+// SetBackupHistoryFile enables recording the history of backups to file.
+// Records from existing file are loaded, so the history survives restarts.
+// Records not seen for longer than retention are removed, when retention is 0 they are kept forever.
+func SetBackupHistoryFile(file string, retention time.Duration) error {
+	history, err := loadBackupHistory(file)
+	if err != nil {
+		return err
+	}
+	history.retention = retention
+	backupHistoryStore = history
+	return nil
+}
+
+// loadBackupHistory reads history file in JSON lines format.
+// Missing file is not an error, it's created when the first backup is recorded.
+func loadBackupHistory(file string) (*backupHistory, error) {
+	history := &backupHistory{
+		file:    file,
+		records: make(map[historyKey]*historyRecord),
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record := &historyRecord{}
+		if err := json.Unmarshal([]byte(line), record); err != nil {
+			return nil, fmt.Errorf("parse history file %s line %d: %w", file, lineNumber, err)
+		}
+		history.records[historyKey{record.Stanza, record.RepoKey, record.Label}] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// update records backups of stanza seen in the current collection.
+// Backups recorded earlier and absent now are marked as expired,
+// or as vanished if remaining backups reference them.
+// Backups expired in this collection are returned, vanished ones are not included.
+// The list of backups must be complete (not filtered by backup type).
+func (history *backupHistory) update(stanzaData stanza, now time.Time) []historyRecord {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	stanzaName, backupData := stanzaData.Name, stanzaData.Backup
+	current := make(map[historyKey]struct{}, len(backupData))
+	for _, backup := range backupData {
+		key := historyKey{stanzaName, backup.Database.RepoKey, backup.Label}
+		current[key] = struct{}{}
+		record, ok := history.records[key]
+		if !ok {
+			record = &historyRecord{
+				Stanza:    stanzaName,
+				RepoKey:   backup.Database.RepoKey,
+				Label:     backup.Label,
+				Type:      backup.Type,
+				Prior:     backup.Prior,
+				StartTime: backup.Timestamp.Start,
+				StopTime:  backup.Timestamp.Stop,
+				FirstSeen: now,
+			}
+			history.records[key] = record
+			history.changed = true
+		}
+		record.LastSeen = now
+		// Backup can reappear, e.g. when repository was temporarily unavailable.
+		if record.ExpiredAt != nil || record.Vanished {
+			record.ExpiredAt = nil
+			record.Vanished = false
+			history.changed = true
+		}
+	}
+	// Labels referenced by remaining backups, but missing in the repo.
+	missingRefs := make(map[backupChainKey]struct{})
+	for _, node := range buildBackupChain(backupData).order {
+		for _, ref := range node.missingRefs {
+			missingRefs[backupChainKey{node.backup.Database.RepoKey, ref}] = struct{}{}
+		}
+	}
+	var expired []historyRecord
+	for key, record := range history.records {
+		if key.stanza != stanzaName || record.ExpiredAt != nil || !isHistoryRepoAvailable(stanzaData, key.repoKey) {
+			continue
+		}
+		if _, ok := current[key]; ok {
+			continue
+		}
+		expiredAt := now
+		record.ExpiredAt = &expiredAt
+		_, record.Vanished = missingRefs[backupChainKey{key.repoKey, key.label}]
+		history.changed = true
+		if !record.Vanished {
+			expired = append(expired, *record)
+		}
+	}
+	return expired
+}
+
+// prune removes records not seen for longer than retention.
+// These are expired backups and backups of stanzas which are removed
+// from pgBackRest or excluded from collection.
+func (history *backupHistory) prune(now time.Time) {
+	if history.retention <= 0 {
+		return
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	for key, record := range history.records {
+		if now.Sub(record.LastSeen) > history.retention {
+			delete(history.records, key)
+			history.changed = true
+		}
+	}
+}
+
+// isHistoryRepoAvailable returns true if the list of backups in repository is reliable.
+// When repository is unavailable, its backups are missing in pgBackRest output,
+// but they must not be considered expired.
+// Status codes: 0 - ok, 2 - no valid backups.
+func isHistoryRepoAvailable(stanzaData stanza, repoKey int) bool {
+	// For pgBackRest < v2.32 repo info is not available.
+	if stanzaData.Repo == nil {
+		return stanzaData.Status.Code == 0 || stanzaData.Status.Code == 2
+	}
+	for _, repo := range *stanzaData.Repo {
+		if repo.Key == repoKey {
+			return repo.Status.Code == 0 || repo.Status.Code == 2
+		}
+	}
+	// Repository is removed from configuration.
+	return false
+}
+
+// getStanzaRecords returns records of stanza sorted by repo and backup start time.
+func (history *backupHistory) getStanzaRecords(stanzaName string) []historyRecord {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	var records []historyRecord
+	for key, record := range history.records {
+		if key.stanza == stanzaName {
+			records = append(records, *record)
+		}
+	}
+	slices.SortFunc(records, compareHistoryRecords)
+	return records
+}
+
+// save rewrites history file atomically: records are written to temporary file
+// in the same directory, which then replaces the history file.
+// The file is not rewritten if records haven't changed since the last save.
+func (history *backupHistory) save() (err error) {
+	history.mu.Lock()
+	if !history.changed {
+		history.mu.Unlock()
+		return nil
+	}
+	records := make([]historyRecord, 0, len(history.records))
+	for _, record := range history.records {
+		records = append(records, *record)
+	}
+	history.changed = false
+	history.mu.Unlock()
+	// Retry on the next collection.
+	defer func() {
+		if err != nil {
+			history.mu.Lock()
+			history.changed = true
+			history.mu.Unlock()
+		}
+	}()
+	slices.SortFunc(records, compareHistoryRecords)
+	tmp, err := os.CreateTemp(filepath.Dir(history.file), filepath.Base(history.file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Removing fails after successful rename, it's expected.
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), history.file)
+}
+
+func compareHistoryRecords(a, b historyRecord) int {
+	if c := strings.Compare(a.Stanza, b.Stanza); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.RepoKey, b.RepoKey); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.StartTime, b.StartTime); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Label, b.Label)
+}
+
+// getBackupHistoryMetrics updates the history of stanza backups and sets metrics based on it.
+func getBackupHistoryMetrics(history *backupHistory, stanzaData stanza, currentUnixTime int64, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	stanzaName := stanzaData.Name
+	for _, record := range history.update(stanzaData, time.Unix(currentUnixTime, 0)) {
+		addMetric(
+			pgbrHistoryExpiredBackupsMetric,
+			"pgbackrest_history_expired_backups_total",
+			1,
+			logger,
+			record.Type,
+			strconv.Itoa(record.RepoKey),
+			stanzaName,
+		)
+	}
+	// Records are sorted by start time, so the first expired backup of repo is the oldest one.
+	oldestExpired := make(map[int]historyRecord)
+	for _, record := range history.getStanzaRecords(stanzaName) {
+		if record.ExpiredAt == nil {
+			continue
+		}
+		if record.Vanished {
+			logger.Warn(
+				"Backup vanished while newer backups depend on it",
+				"stanza", stanzaName,
+				"repo_key", record.RepoKey,
+				"backup", record.Label,
+			)
+			setUpMetric(
+				pgbrHistoryBackupVanishedMetric,
+				"pgbackrest_history_backup_vanished_status",
+				1,
+				setUpMetricValueFun,
+				logger,
+				record.Label,
+				record.Type,
+				strconv.Itoa(record.RepoKey),
+				stanzaName,
+			)
+			continue
+		}
+		if _, ok := oldestExpired[record.RepoKey]; !ok {
+			oldestExpired[record.RepoKey] = record
+		}
+	}
+	for repoKey, record := range oldestExpired {
+		setUpMetric(
+			pgbrHistoryOldestExpiredBackupMetric,
+			"pgbackrest_history_oldest_expired_backup_seconds",
+			float64(currentUnixTime-record.StopTime),
+			setUpMetricValueFun,
+			logger,
+			strconv.Itoa(repoKey),
+			stanzaName,
+		)
+	}
+}
+
+// saveBackupHistory removes outdated records and writes the history to file, if it's enabled.
+func saveBackupHistory(currentUnixTime int64, logger *slog.Logger) {
+	if backupHistoryStore == nil {
+		return
+	}
+	backupHistoryStore.prune(time.Unix(currentUnixTime, 0))
+	if err := backupHistoryStore.save(); err != nil {
+		logger.Error("Save backup history failed", "file", backupHistoryStore.file, "err", err)
+	}
+}
+
+// Counter of expired backups is not reset, backups expire once.
+func resetBackupHistoryMetrics() {
+	pgbrHistoryOldestExpiredBackupMetric.Reset()
+	pgbrHistoryBackupVanishedMetric.Reset()
+}
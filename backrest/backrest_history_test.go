@@ -0,0 +1,187 @@
+package backrest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Backups are removed one by one:
+//
+//	full <- diff <- incr
+//	full <- (vanished diff) <- incr
+//	full
+func TestGetBackupHistoryMetrics(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_history_backup_vanished_status Backup disappeared while newer backups in its chain still exist.
+# TYPE pgbackrest_history_backup_vanished_status gauge
+pgbackrest_history_backup_vanished_status{backup_name="20210607-092423F_20210607-092500D",backup_type="diff",repo_key="1",stanza="demo"} 1
+# HELP pgbackrest_history_expired_backups_total Number of backups found expired by comparing with the history.
+# TYPE pgbackrest_history_expired_backups_total counter
+pgbackrest_history_expired_backups_total{backup_type="incr",repo_key="1",stanza="demo"} 1
+# HELP pgbackrest_history_oldest_expired_backup_seconds Time since the oldest expired backup recorded in the history was completed.
+# TYPE pgbackrest_history_oldest_expired_backup_seconds gauge
+pgbackrest_history_oldest_expired_backup_seconds{repo_key="1",stanza="demo"} 600
+`
+	backups := templateBackupChain()
+	history := &backupHistory{records: make(map[historyKey]*historyRecord)}
+	collections := [][]backup{
+		backups[:3],
+		{backups[0], backups[2]},
+		backups[:1],
+	}
+	// Time of the last collection is 600 seconds after incr backup is completed.
+	currentUnixTime := int64(1623058563)
+	pgbrHistoryExpiredBackupsMetric.Reset()
+	for i, backupData := range collections {
+		resetBackupHistoryMetrics()
+		getBackupHistoryMetrics(
+			history,
+			stanza{Name: "demo", Backup: backupData},
+			currentUnixTime-int64(len(collections)-1-i)*60,
+			setUpMetricValue,
+			logger,
+		)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrHistoryExpiredBackupsMetric,
+		pgbrHistoryOldestExpiredBackupMetric,
+		pgbrHistoryBackupVanishedMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+	full := history.records[historyKey{"demo", 1, backups[0].Label}]
+	if full.FirstSeen != time.Unix(currentUnixTime-120, 0) || full.LastSeen != time.Unix(currentUnixTime, 0) || full.ExpiredAt != nil {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", *full, "full backup seen in all collections")
+	}
+	diff := history.records[historyKey{"demo", 1, backups[1].Label}]
+	if diff.ExpiredAt == nil || !diff.ExpiredAt.Equal(time.Unix(currentUnixTime-60, 0)) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", diff.ExpiredAt, time.Unix(currentUnixTime-60, 0))
+	}
+}
+
+func TestBackupHistoryRepoUnavailable(t *testing.T) {
+	backups := templateBackupChain()[:1]
+	history := &backupHistory{records: make(map[historyKey]*historyRecord)}
+	now := time.Unix(1623058563, 0)
+	history.update(stanza{Name: "demo", Backup: backups}, now)
+	tests := []struct {
+		name       string
+		stanzaData stanza
+	}{
+		{
+			"stanzaStatusError",
+			stanza{Name: "demo", Status: status{Code: 99, Message: "other"}},
+		},
+		{
+			"repoStatusError",
+			stanza{Name: "demo", Repo: &[]repo{{Key: 1, Status: struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}{1, "missing stanza path"}}}},
+		},
+		{
+			"repoRemoved",
+			stanza{Name: "demo", Repo: &[]repo{{Key: 2}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history.update(tt.stanzaData, now.Add(time.Minute))
+			if record := history.records[historyKey{"demo", 1, backups[0].Label}]; record.ExpiredAt != nil {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", record.ExpiredAt, nil)
+			}
+		})
+	}
+}
+
+func TestBackupHistoryPrune(t *testing.T) {
+	backups := templateBackupChain()
+	history := &backupHistory{records: make(map[historyKey]*historyRecord), retention: time.Hour}
+	now := time.Unix(1623058563, 0)
+	history.update(stanza{Name: "demo", Backup: backups[:2]}, now)
+	history.update(stanza{Name: "demo2", Backup: backups[:1]}, now)
+	// Diff backup is expired, stanza demo2 is not collected anymore.
+	history.update(stanza{Name: "demo", Backup: backups[:1]}, now.Add(30*time.Minute))
+	history.changed = false
+	history.prune(now.Add(30 * time.Minute))
+	if len(history.records) != 3 || history.changed {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", len(history.records), 3)
+	}
+	history.update(stanza{Name: "demo", Backup: backups[:1]}, now.Add(2*time.Hour))
+	history.prune(now.Add(2 * time.Hour))
+	want := []historyKey{{"demo", 1, backups[0].Label}}
+	var got []historyKey
+	for key := range history.records {
+		got = append(got, key)
+	}
+	if !reflect.DeepEqual(got, want) || !history.changed {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestBackupHistoryFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := loadBackupHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1623058563, 0).UTC()
+	history.update(stanza{Name: "demo", Backup: templateBackupChain()[:2]}, now)
+	history.update(stanza{Name: "demo", Backup: templateBackupChain()[:1]}, now.Add(time.Minute))
+	if err := history.save(); err != nil {
+		t.Fatal(err)
+	}
+	// Temporary file is replaced.
+	if entries, _ := os.ReadDir(filepath.Dir(file)); len(entries) != 1 {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", len(entries), 1)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"stanza":"demo","repo_key":1,"label":"20210607-092423F","type":"full","start_time":1623057863,"stop_time":1623057866,"first_seen":"2021-06-07T09:36:03Z","last_seen":"2021-06-07T09:37:03Z"}
+{"stanza":"demo","repo_key":1,"label":"20210607-092423F_20210607-092500D","type":"diff","prior":"20210607-092423F","start_time":1623057900,"stop_time":1623057903,"first_seen":"2021-06-07T09:36:03Z","last_seen":"2021-06-07T09:36:03Z","expired_at":"2021-06-07T09:37:03Z"}
+`
+	if string(data) != want {
+		t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", data, want)
+	}
+	loaded, err := loadBackupHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.getStanzaRecords("demo"); !reflect.DeepEqual(got, history.getStanzaRecords("demo")) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, history.getStanzaRecords("demo"))
+	}
+	// Only last_seen is changed, file is not rewritten.
+	history.update(stanza{Name: "demo", Backup: templateBackupChain()[:1]}, now.Add(2*time.Minute))
+	if err := history.save(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != want {
+		t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", data, want)
+	}
+	if err := os.WriteFile(file, []byte("{\"stanza\":\"demo\"}\nbad\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadBackupHistory(file); err == nil {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", err, "parse error")
+	}
+}
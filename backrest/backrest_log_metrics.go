@@ -0,0 +1,327 @@
+package backrest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Default log path of pgBackRest.
+// See https://pgbackrest.org/configuration.html#section-log/option-log-path.
+const defaultLogPath = "/var/log/pgbackrest"
+
+// Format of timestamp in pgBackRest log file, local time is used.
+const logTimestampLayout = "2006-01-02 15:04:05.000"
+
+// Maximum amount of data read from log file on each collection.
+// Large files are read in parts on subsequent collections.
+const logReadLimit = 16 << 20
+
+const (
+	logStatusCompleted = "completed"
+	logStatusAborted   = "aborted"
+)
+
+var (
+	pgbrLogEntriesMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgbackrest_log_entries_total",
+		Help: "Number of entries in pgBackRest log files.",
+	},
+		[]string{
+			"command",
+			"level",
+			"stanza"})
+	pgbrLogErrorsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgbackrest_log_errors_total",
+		Help: "Number of errors with code in pgBackRest log files.",
+	},
+		[]string{
+			"code",
+			"command",
+			"stanza"})
+	pgbrLogCommandRunsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgbackrest_log_command_runs_total",
+		Help: "Number of finished commands in pgBackRest log files.",
+	},
+		[]string{
+			"command",
+			"stanza",
+			"status"})
+	pgbrLogCommandLastRunMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_log_command_last_run_timestamp_seconds",
+		Help: "Time when the last command was finished.",
+	},
+		[]string{
+			"command",
+			"stanza",
+			"status"})
+	pgbrLogCommandLastDurationMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_log_command_last_duration_seconds",
+		Help: "Duration of the last finished command.",
+	},
+		[]string{
+			"command",
+			"stanza"})
+)
+
+var (
+	// E.g. "2021-06-07 09:24:23.531 P00   INFO: backup command begin 2.34: --stanza=demo".
+	logLineRegexp = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}) P\d+ +([A-Z]+): (.*)$`)
+	// E.g. "[082]: WAL segment 000000010000000000000004 was not archived before the 60000ms timeout".
+	logErrorCodeRegexp = regexp.MustCompile(`^\[(\d+)\]: `)
+	// E.g. "backup command begin 2.34: ..." or "archive-push:async command begin 2.34: ...".
+	logCommandBeginRegexp = regexp.MustCompile(`^\S+ command begin `)
+	// E.g. "backup command end: completed successfully (7003ms)" or "backup command end: aborted with exception [082]".
+	logCommandEndRegexp = regexp.MustCompile(`^\S+ command end: (completed successfully|aborted with exception \[\d+\])(?: \((\d+)ms\))?`)
+)
+
+// Commands with own log file, named "<stanza>-<command>.log".
+// Commands without stanza are logged to "all-<command>.log".
+var logCommands = []string{
+	"archive-get",
+	"archive-get-async",
+	"archive-push",
+	"archive-push-async",
+	"backup",
+	"check",
+	"expire",
+	"restore",
+	"stanza-create",
+	"stanza-delete",
+	"stanza-upgrade",
+	"verify",
+}
+
+// Empty when log metrics are disabled.
+var logPath string
+
+// Read positions of log files between collections.
+var logFilesTailed = &logTail{files: make(map[string]*logFileState)}
+
+// logTail contains read positions of log files.
+type logTail struct {
+	mu    sync.Mutex
+	files map[string]*logFileState
+}
+
+// logFileState is read position in log file and the start of running command.
+type logFileState struct {
+	info   os.FileInfo
+	offset int64
+	begin  time.Time
+}
+
+// SetLogPath enables collecting metrics from pgBackRest log files.
+// When path is empty, log-path option is taken from pgBackRest configuration,
+// or the default value is used.
+func SetLogPath(path, config, configIncludePath string) (string, error) {
+	if path == "" {
+		cfg, err := loadBackrestConfig(config, configIncludePath)
+		if err != nil {
+			return "", err
+		}
+		value, ok := cfg.get("", "", "log-path")
+		if !ok {
+			value = defaultLogPath
+		}
+		path = value
+	}
+	logPath = path
+	return path, nil
+}
+
+// getLogMetrics reads lines appended to log files since the previous collection.
+// On the first collection log files are read from the beginning, up to logReadLimit on each collection.
+// Include and exclude lists are applied the same way as for collecting metrics.
+func getLogMetrics(path string, includeStanzas, excludeStanzas []string, tail *logTail, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	files, err := filepath.Glob(filepath.Join(path, "*.log"))
+	if err != nil {
+		logger.Error("Get log files failed", "path", path, "err", err)
+		return
+	}
+	includeSpecified := strings.Join(includeStanzas, "") != ""
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	for _, file := range files {
+		stanzaName, command, ok := parseLogFileName(filepath.Base(file))
+		if !ok || stanzaInExclude(stanzaName, excludeStanzas) || stanzaFilteredByRegexp(stanzaName) ||
+			(includeSpecified && !slices.Contains(includeStanzas, stanzaName)) {
+			continue
+		}
+		state, ok := tail.files[file]
+		if !ok {
+			state = &logFileState{}
+			tail.files[file] = state
+		}
+		err := readLogFile(file, state, logReadLimit, func(line string) {
+			processLogLine(line, stanzaName, command, state, setUpMetricValueFun, logger)
+		})
+		if err != nil {
+			// File can be removed by logrotate.
+			if errors.Is(err, fs.ErrNotExist) {
+				delete(tail.files, file)
+				continue
+			}
+			logger.Error("Read log file failed", "file", file, "err", err)
+		}
+	}
+}
+
+// parseLogFileName returns stanza and command from log file name.
+// Stanza name can contain "-", so the longest known command suffix is used.
+func parseLogFileName(name string) (string, string, bool) {
+	base, ok := strings.CutSuffix(name, ".log")
+	if !ok {
+		return "", "", false
+	}
+	stanzaName, command := "", ""
+	for _, logCommand := range logCommands {
+		if prefix, ok := strings.CutSuffix(base, "-"+logCommand); ok && prefix != "" && len(logCommand) > len(command) {
+			stanzaName, command = prefix, logCommand
+		}
+	}
+	return stanzaName, command, command != ""
+}
+
+// readLogFile calls processLine for complete lines appended to file since the last read.
+// Up to limit bytes are read, the rest is read next time.
+// When file is rotated or truncated, it's read from the beginning.
+func readLogFile(file string, state *logFileState, limit int64, processLine func(string)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if state.info != nil && (!os.SameFile(state.info, info) || info.Size() < state.offset) {
+		state.offset = 0
+		state.begin = time.Time{}
+	}
+	state.info = info
+	if _, err := f.Seek(state.offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(io.LimitReader(f, limit))
+	var read int64
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// The last line can be not completely written yet.
+			// Line longer than limit is skipped, otherwise reading would stop on it.
+			if read == 0 && int64(len(line)) == limit {
+				state.offset += limit
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		read += int64(len(line))
+		state.offset += int64(len(line))
+		processLine(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// Set log metrics:
+//   - pgbackrest_log_entries_total
+//   - pgbackrest_log_errors_total
+//   - pgbackrest_log_command_runs_total
+//   - pgbackrest_log_command_last_run_timestamp_seconds
+//   - pgbackrest_log_command_last_duration_seconds
+func processLogLine(line, stanzaName, command string, state *logFileState, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	match := logLineRegexp.FindStringSubmatch(line)
+	// E.g. "-------------------PROCESS START-------------------" or continuation of multiline message.
+	if match == nil {
+		return
+	}
+	timestamp, err := time.ParseInLocation(logTimestampLayout, match[1], time.Local)
+	if err != nil {
+		logger.Error("Parse log timestamp failed", "line", line, "err", err)
+		return
+	}
+	level, message := match[2], match[3]
+	addMetric(pgbrLogEntriesMetric, "pgbackrest_log_entries_total", 1, logger, command, level, stanzaName)
+	if level == "ERROR" {
+		if code := logErrorCodeRegexp.FindStringSubmatch(message); code != nil {
+			addMetric(pgbrLogErrorsMetric, "pgbackrest_log_errors_total", 1, logger, code[1], command, stanzaName)
+		}
+	}
+	if logCommandBeginRegexp.MatchString(message) {
+		state.begin = timestamp
+		return
+	}
+	end := logCommandEndRegexp.FindStringSubmatch(message)
+	if end == nil {
+		return
+	}
+	status := logStatusAborted
+	if end[1] == "completed successfully" {
+		status = logStatusCompleted
+	}
+	addMetric(pgbrLogCommandRunsMetric, "pgbackrest_log_command_runs_total", 1, logger, command, stanzaName, status)
+	setUpMetric(
+		pgbrLogCommandLastRunMetric,
+		"pgbackrest_log_command_last_run_timestamp_seconds",
+		float64(timestamp.UnixMilli())/1000,
+		setUpMetricValueFun,
+		logger,
+		command,
+		stanzaName,
+		status,
+	)
+	// Duration is written at the end of command, otherwise it's calculated from the beginning.
+	duration := -1.0
+	if end[2] != "" {
+		ms, _ := strconv.ParseInt(end[2], 10, 64)
+		duration = float64(ms) / 1000
+	} else if !state.begin.IsZero() {
+		duration = timestamp.Sub(state.begin).Seconds()
+	}
+	if duration >= 0 {
+		setUpMetric(
+			pgbrLogCommandLastDurationMetric,
+			"pgbackrest_log_command_last_duration_seconds",
+			duration,
+			setUpMetricValueFun,
+			logger,
+			command,
+			stanzaName,
+		)
+	}
+	state.begin = time.Time{}
+}
+
+func addMetric(metric *prometheus.CounterVec, metricName string, value float64, logger *slog.Logger, labels ...string) {
+	logger.Debug(
+		"Add metric",
+		"metric", metricName,
+		"value", value,
+		"labels", strings.Join(labels, ","),
+	)
+	counter, err := metric.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		logger.Error(
+			"Metric add failed",
+			"metric", metricName,
+			"err", err,
+		)
+		return
+	}
+	counter.Add(value)
+}
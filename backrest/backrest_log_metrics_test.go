@@ -0,0 +1,193 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+const templateBackupLog = `-------------------PROCESS START-------------------
+2021-06-07 09:24:23.531 P00   INFO: backup command begin 2.34: --exec-id=1234-abcd --stanza=demo
+2021-06-07 09:24:24.000 P00   WARN: no prior backup exists, incr backup has been changed to full
+2021-06-07 09:24:30.534 P00   INFO: backup command end: completed successfully (7003ms)
+-------------------PROCESS START-------------------
+2021-06-08 09:24:23.000 P00   INFO: backup command begin 2.34: --exec-id=1235-abcd --stanza=demo
+2021-06-08 09:25:23.500 P00  ERROR: [082]: WAL segment 000000010000000000000006 was not archived before the 60000ms timeout
+                                    HINT: check the archive_command to ensure that all options are correct.
+2021-06-08 09:25:23.600 P00   INFO: backup command end: aborted with exception [082]
+`
+
+func logTimestamp(t *testing.T, value string) string {
+	timestamp, err := time.ParseInLocation(logTimestampLayout, value, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.FormatFloat(float64(timestamp.UnixMilli())/1000, 'g', -1, 64)
+}
+
+func gatherLogMetrics(t *testing.T) string {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrLogEntriesMetric,
+		pgbrLogErrorsMetric,
+		pgbrLogCommandRunsMetric,
+		pgbrLogCommandLastRunMetric,
+		pgbrLogCommandLastDurationMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	return out.String()
+}
+
+func TestGetLogMetrics(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_log_command_last_duration_seconds Duration of the last finished command.
+# TYPE pgbackrest_log_command_last_duration_seconds gauge
+pgbackrest_log_command_last_duration_seconds{command="backup",stanza="demo-db"} %s
+# HELP pgbackrest_log_command_last_run_timestamp_seconds Time when the last command was finished.
+# TYPE pgbackrest_log_command_last_run_timestamp_seconds gauge
+pgbackrest_log_command_last_run_timestamp_seconds{command="backup",stanza="demo-db",status="aborted"} %s
+pgbackrest_log_command_last_run_timestamp_seconds{command="backup",stanza="demo-db",status="completed"} %s
+# HELP pgbackrest_log_command_runs_total Number of finished commands in pgBackRest log files.
+# TYPE pgbackrest_log_command_runs_total counter
+pgbackrest_log_command_runs_total{command="backup",stanza="demo-db",status="aborted"} %d
+pgbackrest_log_command_runs_total{command="backup",stanza="demo-db",status="completed"} 1
+# HELP pgbackrest_log_entries_total Number of entries in pgBackRest log files.
+# TYPE pgbackrest_log_entries_total counter
+pgbackrest_log_entries_total{command="backup",level="ERROR",stanza="demo-db"} %d
+pgbackrest_log_entries_total{command="backup",level="INFO",stanza="demo-db"} %d
+pgbackrest_log_entries_total{command="backup",level="WARN",stanza="demo-db"} 1
+# HELP pgbackrest_log_errors_total Number of errors with code in pgBackRest log files.
+# TYPE pgbackrest_log_errors_total counter
+pgbackrest_log_errors_total{code="082",command="backup",stanza="demo-db"} %d
+`
+	dir := t.TempDir()
+	file := filepath.Join(dir, "demo-db-backup.log")
+	if err := os.WriteFile(file, []byte(templateBackupLog+"2021-06-09 09:24:23.000 P00   INFO: backup com"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Excluded stanza and unknown files are skipped.
+	if err := os.WriteFile(filepath.Join(dir, "excluded-backup.log"), []byte(templateBackupLog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "demo-db-unknown.log"), []byte(templateBackupLog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Counters are not reset in the exporter, lines are read once.
+	for _, metric := range []interface{ Reset() }{
+		pgbrLogEntriesMetric,
+		pgbrLogErrorsMetric,
+		pgbrLogCommandRunsMetric,
+		pgbrLogCommandLastRunMetric,
+		pgbrLogCommandLastDurationMetric,
+	} {
+		metric.Reset()
+	}
+	tail := &logTail{files: make(map[string]*logFileState)}
+	getLogMetrics(dir, []string{""}, []string{"excluded"}, tail, setUpMetricValue, logger)
+	want := fmt.Sprintf(templateMetrics, "60.6",
+		logTimestamp(t, "2021-06-08 09:25:23.600"), logTimestamp(t, "2021-06-07 09:24:30.534"), 1, 1, 4, 1)
+	if got := gatherLogMetrics(t); got != want {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", got, want)
+	}
+	// The incomplete line is read when it's finished.
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`mand begin 2.34: --exec-id=1236-abcd --stanza=demo
+2021-06-09 09:24:25.000 P00  ERROR: [082]: WAL segment 000000010000000000000008 was not archived before the 60000ms timeout
+2021-06-09 09:24:25.500 P00   INFO: backup command end: aborted with exception [082]
+`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	getLogMetrics(dir, []string{""}, []string{"excluded"}, tail, setUpMetricValue, logger)
+	want = fmt.Sprintf(templateMetrics, "2.5",
+		logTimestamp(t, "2021-06-09 09:24:25.500"), logTimestamp(t, "2021-06-07 09:24:30.534"), 2, 2, 6, 2)
+	if got := gatherLogMetrics(t); got != want {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", got, want)
+	}
+	// Truncated file is read from the beginning.
+	if err := os.WriteFile(file, []byte("2021-06-10 09:24:23.000 P00   INFO: backup command begin 2.34: --stanza=demo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	getLogMetrics(dir, []string{""}, []string{"excluded"}, tail, setUpMetricValue, logger)
+	want = fmt.Sprintf(templateMetrics, "2.5",
+		logTimestamp(t, "2021-06-09 09:24:25.500"), logTimestamp(t, "2021-06-07 09:24:30.534"), 2, 2, 7, 2)
+	if got := gatherLogMetrics(t); got != want {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReadLogFileLimit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "demo-backup.log")
+	if err := os.WriteFile(file, []byte("first\nsecond\nthird line is too long\nlast\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	state := &logFileState{}
+	var got []string
+	for range 4 {
+		if err := readLogFile(file, state, 15, func(line string) { got = append(got, line) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The part of too long line is read as a separate line.
+	want := []string{"first", "second", "oo long", "last"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestParseLogFileName(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		wantStanza string
+		wantCmd    string
+		wantOK     bool
+	}{
+		{"backup", "demo-backup.log", "demo", "backup", true},
+		{"async", "demo-archive-push-async.log", "demo", "archive-push-async", true},
+		{"stanzaWithDash", "prod-db-archive-get.log", "prod-db", "archive-get", true},
+		{"withoutStanza", "all-server.log", "", "", false},
+		{"onlyCommand", "backup.log", "", "", false},
+		{"notLog", "demo-backup.log.1", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stanzaName, command, ok := parseLogFileName(tt.file)
+			if stanzaName != tt.wantStanza || command != tt.wantCmd || ok != tt.wantOK {
+				t.Errorf("\nVariables do not match:\n%s, %s, %v\nwant:\n%s, %s, %v",
+					stanzaName, command, ok, tt.wantStanza, tt.wantCmd, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetLogPath(t *testing.T) {
+	defer func() { logPath = "" }()
+	configFile, includePath := writeTemplateConfig(t, "[global]\nlog-path=/tmp/log\n", "")
+	got, err := SetLogPath("", configFile, includePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/tmp/log" || logPath != "/tmp/log" {
+		t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, "/tmp/log")
+	}
+}
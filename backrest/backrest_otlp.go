@@ -0,0 +1,89 @@
+package backrest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	otelprometheus "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLPConfig contains parameters for exporting metrics via OpenTelemetry protocol.
+// Only http/protobuf transport is supported, it's accepted by OpenTelemetry collector
+// and most vendors and doesn't require gRPC dependencies.
+type OTLPConfig struct {
+	// Endpoint is the URL of OTLP receiver, e.g. http://otel-collector:4318/v1/metrics.
+	// For http scheme TLS is disabled.
+	Endpoint string
+	// Headers are sent with each export request, e.g. for authentication.
+	Headers map[string]string
+	// ResourceAttributes describe the source of metrics, e.g. host.name or cluster.
+	ResourceAttributes map[string]string
+	// Timeout is the timeout for export request.
+	Timeout time.Duration
+}
+
+// OTLPExporter exports pgBackRest metrics via OpenTelemetry protocol.
+type OTLPExporter struct {
+	exporter sdkmetric.Exporter
+	producer sdkmetric.Producer
+	resource *resource.Resource
+}
+
+// NewOTLPExporter creates OTLP exporter for pgBackRest metrics from the default registry.
+func NewOTLPExporter(cfg OTLPConfig) (*OTLPExporter, error) {
+	return newOTLPExporter(cfg, prometheus.DefaultGatherer)
+}
+
+func newOTLPExporter(cfg OTLPConfig, gatherer prometheus.Gatherer) (*OTLPExporter, error) {
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(cfg.Endpoint)}
+	if len(cfg.Headers) != 0 {
+		opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, otlpmetrichttp.WithTimeout(cfg.Timeout))
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make([]attribute.KeyValue, 0, len(cfg.ResourceAttributes))
+	// Sorted for stable order of attributes.
+	for _, key := range slices.Sorted(maps.Keys(cfg.ResourceAttributes)) {
+		attrs = append(attrs, attribute.String(key, cfg.ResourceAttributes[key]))
+	}
+	return &OTLPExporter{
+		exporter: exporter,
+		producer: otelprometheus.NewMetricProducer(otelprometheus.WithGatherer(exporterMetricsGatherer(gatherer))),
+		resource: resource.NewSchemaless(attrs...),
+	}, nil
+}
+
+// Export sends current values of metrics.
+// It's called after each collection, so the same metric values are exported and exposed on metrics endpoint.
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	scopeMetrics, err := e.producer.Produce(ctx)
+	if err != nil {
+		return err
+	}
+	if len(scopeMetrics) == 0 {
+		return nil
+	}
+	return e.exporter.Export(ctx, &metricdata.ResourceMetrics{
+		Resource:     e.resource,
+		ScopeMetrics: scopeMetrics,
+	})
+}
+
+// Shutdown flushes and closes the exporter.
+// It must be called before exit, the context limits the time of shutdown.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return e.exporter.Shutdown(ctx)
+}
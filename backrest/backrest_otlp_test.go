@@ -0,0 +1,90 @@
+package backrest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func templateOTLPRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	statusMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_exporter_status",
+		Help: "pgBackRest exporter get data status.",
+	}, []string{"stanza"})
+	goMetric := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "go_goroutines",
+		Help: "Number of goroutines that currently exist.",
+	})
+	registry.MustRegister(statusMetric, goMetric)
+	statusMetric.WithLabelValues("demo").Set(1)
+	return registry
+}
+
+// getOTLPRequestSummary returns resource attributes and metric names from export request.
+func getOTLPRequestSummary(req *colmetricspb.ExportMetricsServiceRequest) (map[string]string, []string) {
+	attrs := make(map[string]string)
+	var names []string
+	for _, rm := range req.GetResourceMetrics() {
+		for _, attr := range rm.GetResource().GetAttributes() {
+			attrs[attr.GetKey()] = attr.GetValue().GetStringValue()
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				names = append(names, metric.GetName())
+			}
+		}
+	}
+	sort.Strings(names)
+	return attrs, names
+}
+
+func TestOTLPExporter(t *testing.T) {
+	wantAttrs := map[string]string{"host.name": "db1", "cluster": "main"}
+	wantNames := []string{"pgbackrest_exporter_status"}
+	t.Run("otlpHTTP", func(t *testing.T) {
+		requests := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/metrics" || r.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("\nUnexpected request: %s %v", r.URL.Path, r.Header)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("\nRead body failed: %v", err)
+			}
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				t.Errorf("\nUnmarshal request failed: %v", err)
+			}
+			requests <- req
+			w.Header().Set("Content-Type", "application/x-protobuf")
+		}))
+		defer srv.Close()
+		exporter, err := newOTLPExporter(OTLPConfig{
+			Endpoint:           srv.URL + "/v1/metrics",
+			Headers:            map[string]string{"Authorization": "Bearer token"},
+			ResourceAttributes: wantAttrs,
+			Timeout:            5 * time.Second,
+		}, templateOTLPRegistry())
+		if err != nil {
+			t.Fatalf("\nCreate OTLP exporter failed: %v", err)
+		}
+		defer exporter.Shutdown(context.Background())
+		if err := exporter.Export(context.Background()); err != nil {
+			t.Fatalf("\nExport failed: %v", err)
+		}
+		attrs, names := getOTLPRequestSummary(<-requests)
+		if !reflect.DeepEqual(attrs, wantAttrs) || !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("\nVariables do not match:\n%v %v\nwant:\n%v %v", attrs, names, wantAttrs, wantNames)
+		}
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log/slog"
 	"os/exec"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +16,12 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Regular expressions for stanza names, nil when not set.
+var (
+	stanzaIncludeRegexp *regexp.Regexp
+	stanzaExcludeRegexp *regexp.Regexp
+)
+
 type setUpMetricValueFunType func(metric *prometheus.GaugeVec, value float64, labels ...string) error
 
 type backupStruct struct {
@@ -316,11 +324,64 @@ func stanzaInExclude(stanza string, listExclude []string) bool {
 			if val == stanza {
 				return true
 			}
+			// Value can be glob pattern, e.g. "staging-*".
+			// Values without metacharacters are exact names. Invalid patterns,
+			// e.g. "demo[1", match only the same name.
+			if !strings.ContainsAny(val, "*?[") {
+				continue
+			}
+			if matched, err := path.Match(val, stanza); err == nil && matched {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// SetStanzaRegexpFilters sets regular expressions for stanza names.
+// Filters are applied to stanzas returned by pgBackRest. Empty expression disables the filter.
+func SetStanzaRegexpFilters(include, exclude string) error {
+	var err error
+	stanzaIncludeRegexp, stanzaExcludeRegexp = nil, nil
+	if include != "" {
+		if stanzaIncludeRegexp, err = regexp.Compile(include); err != nil {
+			return fmt.Errorf("include: %w", err)
+		}
+	}
+	if exclude != "" {
+		if stanzaExcludeRegexp, err = regexp.Compile(exclude); err != nil {
+			stanzaIncludeRegexp = nil
+			return fmt.Errorf("exclude: %w", err)
+		}
+	}
+	return nil
+}
+
+// stanzaFilteredByRegexp returns true if stanza doesn't match include expression or matches exclude expression.
+func stanzaFilteredByRegexp(stanza string) bool {
+	return (stanzaIncludeRegexp != nil && !stanzaIncludeRegexp.MatchString(stanza)) ||
+		(stanzaExcludeRegexp != nil && stanzaExcludeRegexp.MatchString(stanza))
+}
+
+// getStanzaRegexpFiltersLabel returns the value of 'stanza' label for all stanzas filtered by regular expressions,
+// e.g. "include-regex=^prod-;except-excluded". Empty when filters are not set.
+func getStanzaRegexpFiltersLabel(excludeStanzaSpecified bool) string {
+	var filters []string
+	if stanzaIncludeRegexp != nil {
+		filters = append(filters, "include-regex="+stanzaIncludeRegexp.String())
+	}
+	if stanzaExcludeRegexp != nil {
+		filters = append(filters, "exclude-regex="+stanzaExcludeRegexp.String())
+	}
+	if len(filters) == 0 {
+		return ""
+	}
+	if excludeStanzaSpecified {
+		filters = append(filters, "except-excluded")
+	}
+	return strings.Join(filters, ";")
+}
+
 func getParsedSpecificBackupInfoData(config, configIncludePath, stanzaName, backupLabel string, logger *slog.Logger) ([]stanza, error) {
 	stanzaDataSpecific, err := getSpecificBackupInfoData(config, configIncludePath, stanzaName, backupLabel, logger)
 	if err != nil {
@@ -362,9 +423,17 @@ func setUpMetric(metric *prometheus.GaugeVec, metricName string, value float64,
 func resetMetrics() {
 	resetStanzaMetrics()
 	resetRepoMetrics()
+	resetRepoForecastMetrics()
 	resetBackupMetrics()
+	resetBackupRatioMetrics()
+	resetBackupChainMetrics()
+	resetBackupAnomalyMetrics()
+	resetBackupHistoryMetrics()
 	resetLastBackupMetrics()
 	resetWALMetrics()
+	resetSpoolMetrics()
+	resetConfigMetrics()
+	resetRetentionMetrics()
 	resetExporterMetrics()
 }
 
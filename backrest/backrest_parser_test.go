@@ -450,6 +450,21 @@ func TestStanzaInExclude(t *testing.T) {
 		{"stanzaInExcludeStanzaInExcludeList",
 			args{"demo", []string{"demo", "test"}},
 			true},
+		{"stanzaInExcludeStanzaMatchesGlob",
+			args{"staging-db1", []string{"demo", "staging-*"}},
+			true},
+		{"stanzaInExcludeStanzaNotMatchesGlob",
+			args{"prod-db1", []string{"demo", "staging-*"}},
+			false},
+		{"stanzaInExcludeStanzaWithBracketMatchesItself",
+			args{"demo[1", []string{"demo[1"}},
+			true},
+		{"stanzaInExcludeStanzaNotMatchesNameWithBracket",
+			args{"demo[12", []string{"demo[1"}},
+			false},
+		{"stanzaInExcludeStanzaNotMatchesNameWithBracketAsPattern",
+			args{"demo1", []string{"demo[1"}},
+			false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -460,6 +475,40 @@ func TestStanzaInExclude(t *testing.T) {
 	}
 }
 
+func TestStanzaFilteredByRegexp(t *testing.T) {
+	defer func() { stanzaIncludeRegexp, stanzaExcludeRegexp = nil, nil }()
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		stanza  string
+		want    bool
+		label   string
+	}{
+		{"noFilters", "", "", "demo", false, ""},
+		{"includeMatches", "^prod-", "", "prod-db1", false, "include-regex=^prod-"},
+		{"includeNotMatches", "^prod-", "", "staging-db1", true, "include-regex=^prod-"},
+		{"excludeMatches", "^prod-", "-test$", "prod-db1-test", true, "include-regex=^prod-;exclude-regex=-test$"},
+		{"excludeOnly", "", "-test$", "staging-db1", false, "exclude-regex=-test$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetStanzaRegexpFilters(tt.include, tt.exclude); err != nil {
+				t.Fatal(err)
+			}
+			if got := stanzaFilteredByRegexp(tt.stanza); got != tt.want {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, tt.want)
+			}
+			if got := getStanzaRegexpFiltersLabel(false); got != tt.label {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, tt.label)
+			}
+		})
+	}
+	if err := SetStanzaRegexpFilters("^prod-", "("); err == nil || stanzaIncludeRegexp != nil {
+		t.Errorf("\nError expected for invalid regular expression")
+	}
+}
+
 func fakeSetUpMetricValue(metric *prometheus.GaugeVec, value float64, labels ...string) error {
 	return errors.New("сustorm error for test")
 }
@@ -0,0 +1,100 @@
+package backrest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/config"
+)
+
+// PushConfig contains parameters for pushing metrics to Prometheus Pushgateway.
+type PushConfig struct {
+	// URL is the Pushgateway URL, e.g. http://pushgateway:9091.
+	URL string
+	// Job is the value of job label.
+	Job string
+	// Instance is the value of instance label in grouping key.
+	Instance string
+	// Stanza is the value of stanza label in grouping key.
+	Stanza string
+	// HTTPConfigFile is the path to HTTP client configuration file for basic authentication and TLS.
+	// The format is the same as for Prometheus scrape configs, see https://prometheus.io/docs/prometheus/latest/configuration/configuration/#http_config.
+	HTTPConfigFile string
+	// Timeout is the timeout for push request.
+	Timeout time.Duration
+}
+
+// PushMetrics pushes pgBackRest metrics from the default registry to Pushgateway.
+// All metrics in the group (job, stanza, instance) are replaced.
+func PushMetrics(cfg PushConfig) error {
+	return pushMetrics(cfg, prometheus.DefaultGatherer)
+}
+
+func pushMetrics(cfg PushConfig, gatherer prometheus.Gatherer) error {
+	httpConfig := config.DefaultHTTPClientConfig
+	if cfg.HTTPConfigFile != "" {
+		fileConfig, _, err := config.LoadHTTPConfigFile(cfg.HTTPConfigFile)
+		if err != nil {
+			return fmt.Errorf("load HTTP config file: %w", err)
+		}
+		httpConfig = *fileConfig
+	}
+	client, err := config.NewClientFromConfig(httpConfig, "pushgateway")
+	if err != nil {
+		return fmt.Errorf("create HTTP client: %w", err)
+	}
+	ctx := context.Background()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	return push.New(cfg.URL, cfg.Job).
+		Grouping("stanza", cfg.Stanza).
+		Grouping("instance", cfg.Instance).
+		Gatherer(groupingLabelsGatherer(exporterMetricsGatherer(gatherer), cfg.Stanza)).
+		Client(client).
+		PushContext(ctx)
+}
+
+// groupingLabelsGatherer removes stanza label from metrics.
+// Pushgateway rejects metrics with labels from grouping key,
+// the label is added back by Pushgateway from grouping key on scrape.
+// Metrics for other stanzas are skipped, they can't be in the group.
+func groupingLabelsGatherer(gatherer prometheus.Gatherer, stanzaName string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+		result := mfs[:0]
+		for _, mf := range mfs {
+			metrics := mf.Metric[:0]
+			for _, metric := range mf.Metric {
+				labels := metric.Label[:0]
+				skip := false
+				for _, label := range metric.Label {
+					if label.GetName() != "stanza" {
+						labels = append(labels, label)
+						continue
+					}
+					skip = label.GetValue() != stanzaName
+				}
+				if skip {
+					continue
+				}
+				metric.Label = labels
+				metrics = append(metrics, metric)
+			}
+			if len(metrics) != 0 {
+				mf.Metric = metrics
+				result = append(result, mf)
+			}
+		}
+		return result, nil
+	})
+}
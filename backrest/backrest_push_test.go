@@ -0,0 +1,154 @@
+package backrest
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// pushgatewayRequest is a request received by Pushgateway stand-in.
+type pushgatewayRequest struct {
+	method string
+	// Grouping key from URL path, order of labels is not defined.
+	grouping map[string]string
+	username string
+	password string
+	metrics  map[string][]map[string]string
+}
+
+// newPushgatewayServer returns HTTP server implementing Pushgateway API for push requests.
+func newPushgatewayServer(t *testing.T, code int, requests chan<- pushgatewayRequest) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := pushgatewayRequest{
+			method:   r.Method,
+			grouping: make(map[string]string),
+			metrics:  make(map[string][]map[string]string),
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/metrics/"), "/")
+		for i := 0; i+1 < len(parts); i += 2 {
+			req.grouping[parts[i]] = parts[i+1]
+		}
+		req.username, req.password, _ = r.BasicAuth()
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			mf := &dto.MetricFamily{}
+			err := decoder.Decode(mf)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Errorf("\nDecode metrics failed: %v", err)
+				break
+			}
+			for _, metric := range mf.GetMetric() {
+				labels := make(map[string]string)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				req.metrics[mf.GetName()] = append(req.metrics[mf.GetName()], labels)
+			}
+		}
+		requests <- req
+		w.WriteHeader(code)
+	}))
+}
+
+func TestPushMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	statusMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_exporter_status",
+		Help: "pgBackRest exporter get data status.",
+	}, []string{"stanza"})
+	versionMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_version_info",
+		Help: "Information about pgBackRest version.",
+	}, []string{"version"})
+	goMetric := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "go_goroutines",
+		Help: "Number of goroutines that currently exist.",
+	})
+	registry.MustRegister(statusMetric, versionMetric, goMetric)
+	statusMetric.WithLabelValues("demo").Set(1)
+	statusMetric.WithLabelValues("demo2").Set(1)
+	versionMetric.WithLabelValues("2.58.0").Set(2058000)
+	requests := make(chan pushgatewayRequest, 1)
+	srv := newPushgatewayServer(t, http.StatusOK, requests)
+	defer srv.Close()
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatalf("\nWrite CA file failed: %v", err)
+	}
+	configFile := filepath.Join(dir, "push.yml")
+	configData := "basic_auth:\n  username: pusher\n  password: secret\ntls_config:\n  ca_file: " + caFile + "\n  server_name: example.com\n"
+	if err := os.WriteFile(configFile, []byte(configData), 0o600); err != nil {
+		t.Fatalf("\nWrite config file failed: %v", err)
+	}
+	cfg := PushConfig{
+		URL:            srv.URL,
+		Job:            "pgbackrest_exporter",
+		Instance:       "db1",
+		Stanza:         "demo",
+		HTTPConfigFile: configFile,
+	}
+	if err := pushMetrics(cfg, registry); err != nil {
+		t.Fatalf("\nPush metrics failed: %v", err)
+	}
+	got := <-requests
+	want := pushgatewayRequest{
+		method:   http.MethodPut,
+		grouping: map[string]string{"job": "pgbackrest_exporter", "stanza": "demo", "instance": "db1"},
+		username: "pusher",
+		password: "secret",
+		metrics: map[string][]map[string]string{
+			"pgbackrest_exporter_status": {{}},
+			"pgbackrest_version_info":    {{"version": "2.58.0"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestPushMetricsErrors(t *testing.T) {
+	requests := make(chan pushgatewayRequest, 1)
+	srv := newPushgatewayServer(t, http.StatusInternalServerError, requests)
+	defer srv.Close()
+	tests := []struct {
+		name string
+		cfg  PushConfig
+	}{
+		// Server certificate is not trusted without CA file.
+		{"pushTLSFail", PushConfig{URL: srv.URL, Job: "job", Instance: "db1", Stanza: "demo"}},
+		{"pushConfigFileAbsent", PushConfig{URL: srv.URL, Job: "job", Instance: "db1", Stanza: "demo", HTTPConfigFile: filepath.Join(t.TempDir(), "absent.yml")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := pushMetrics(tt.cfg, prometheus.NewRegistry()); err == nil {
+				t.Errorf("\nExpected error for push")
+			}
+		})
+	}
+	t.Run("pushBadResponseCode", func(t *testing.T) {
+		httpConfig := filepath.Join(t.TempDir(), "push.yml")
+		if err := os.WriteFile(httpConfig, []byte("tls_config:\n  insecure_skip_verify: true\n"), 0o600); err != nil {
+			t.Fatalf("\nWrite config file failed: %v", err)
+		}
+		cfg := PushConfig{URL: srv.URL, Job: "job", Instance: "db1", Stanza: "demo", HTTPConfigFile: httpConfig}
+		if err := pushMetrics(cfg, prometheus.NewRegistry()); err == nil {
+			t.Errorf("\nExpected error for bad response code")
+		}
+		<-requests
+	})
+}
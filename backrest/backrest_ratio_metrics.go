@@ -0,0 +1,223 @@
+package backrest
+
+import (
+	"log/slog"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// "backup":"info":"size" / "backup":"info":"repository":"size".
+	pgbrStanzaBackupCompressionRatioMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_compression_ratio",
+		Help: "Ratio of uncompressed database size to compressed files size to restore the database from backup.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+	// "backup":"info":"delta" / "backup":"info":"size".
+	pgbrStanzaBackupDeltaRatioMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_delta_ratio",
+		Help: "Ratio of data to actually backup to uncompressed database size.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+	// "backup":"info":"repository":"size-map" / "backup":"info":"repository":"delta-map".
+	pgbrStanzaBackupRepoSizeMapRatioMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_repo_size_map_ratio",
+		Help: "Ratio of block incremental map size to block incremental delta map size in backup.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+	// "backup":"info":"delta" / backup duration.
+	pgbrStanzaBackupThroughputMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_throughput_bytes_per_second",
+		Help: "Amount of data in the database to actually backup per second of backup duration.",
+	},
+		[]string{
+			"backup_name",
+			"backup_type",
+			"block_incr",
+			"database_id",
+			"repo_key",
+			"stanza"})
+	pgbrStanzaBackupLastCompressionRatioMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_last_compression_ratio",
+		Help: "Ratio of uncompressed database size to compressed files size to restore the database from the last full, differential or incremental backup.",
+	},
+		[]string{
+			"backup_type",
+			"block_incr",
+			"stanza"})
+	pgbrStanzaBackupLastDeltaRatioMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_last_delta_ratio",
+		Help: "Ratio of data to actually backup to uncompressed database size in the last full, differential or incremental backup.",
+	},
+		[]string{
+			"backup_type",
+			"block_incr",
+			"stanza"})
+	pgbrStanzaBackupLastRepoSizeMapRatioMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_last_repo_size_map_ratio",
+		Help: "Ratio of block incremental map size to block incremental delta map size in the last full, differential or incremental backup.",
+	},
+		[]string{
+			"backup_type",
+			"block_incr",
+			"stanza"})
+	pgbrStanzaBackupLastThroughputMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_backup_last_throughput_bytes_per_second",
+		Help: "Amount of data in the database to actually backup per second of duration of the last full, differential or incremental backup.",
+	},
+		[]string{
+			"backup_type",
+			"block_incr",
+			"stanza"})
+)
+
+// backupRatioMetric is a derived metric and its name for logging.
+type backupRatioMetric struct {
+	metric     *prometheus.GaugeVec
+	metricName string
+}
+
+// Metrics in the order of values returned by getBackupRatios.
+var (
+	backupRatioMetrics = []backupRatioMetric{
+		{pgbrStanzaBackupCompressionRatioMetric, "pgbackrest_backup_compression_ratio"},
+		{pgbrStanzaBackupDeltaRatioMetric, "pgbackrest_backup_delta_ratio"},
+		{pgbrStanzaBackupRepoSizeMapRatioMetric, "pgbackrest_backup_repo_size_map_ratio"},
+		{pgbrStanzaBackupThroughputMetric, "pgbackrest_backup_throughput_bytes_per_second"},
+	}
+	backupLastRatioMetrics = []backupRatioMetric{
+		{pgbrStanzaBackupLastCompressionRatioMetric, "pgbackrest_backup_last_compression_ratio"},
+		{pgbrStanzaBackupLastDeltaRatioMetric, "pgbackrest_backup_last_delta_ratio"},
+		{pgbrStanzaBackupLastRepoSizeMapRatioMetric, "pgbackrest_backup_last_repo_size_map_ratio"},
+		{pgbrStanzaBackupLastThroughputMetric, "pgbackrest_backup_last_throughput_bytes_per_second"},
+	}
+)
+
+// backupRatio is a value of derived metric.
+// If the value can't be calculated, the metric is not set.
+type backupRatio struct {
+	value float64
+	ok    bool
+}
+
+// getBackupRatios returns compression ratio, delta ratio, map ratio and throughput.
+// Values that can't be calculated are skipped:
+//   - repository size is absent for block incremental backups (pgBackRest >= v2.45);
+//   - map and delta map are absent for pgBackRest < v2.44 and for backups without block incremental;
+//   - duration can be 0 for very small databases.
+func getBackupRatios(size, delta int64, repoSize, repoSizeMap, repoDeltaMap *int64, duration float64) []backupRatio {
+	ratios := make([]backupRatio, 4)
+	if repoSize != nil && *repoSize != 0 {
+		ratios[0] = backupRatio{float64(size) / float64(*repoSize), true}
+	}
+	if size != 0 {
+		ratios[1] = backupRatio{float64(delta) / float64(size), true}
+	}
+	if repoSizeMap != nil && repoDeltaMap != nil && *repoDeltaMap != 0 {
+		ratios[2] = backupRatio{float64(*repoSizeMap) / float64(*repoDeltaMap), true}
+	}
+	if duration > 0 {
+		ratios[3] = backupRatio{float64(delta) / duration, true}
+	}
+	return ratios
+}
+
+// Set backup derived metrics:
+//   - pgbackrest_backup_compression_ratio
+//   - pgbackrest_backup_delta_ratio
+//   - pgbackrest_backup_repo_size_map_ratio
+//   - pgbackrest_backup_throughput_bytes_per_second
+func getBackupRatioMetrics(stanzaName string, backupData []backup, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	for _, backup := range backupData {
+		ratios := getBackupRatios(
+			backup.Info.Size,
+			backup.Info.Delta,
+			backup.Info.Repository.Size,
+			backup.Info.Repository.SizeMap,
+			backup.Info.Repository.DeltaMap,
+			float64(backup.Timestamp.Stop-backup.Timestamp.Start),
+		)
+		for i, ratio := range ratios {
+			if !ratio.ok {
+				continue
+			}
+			setUpMetric(
+				backupRatioMetrics[i].metric,
+				backupRatioMetrics[i].metricName,
+				ratio.value,
+				setUpMetricValueFun,
+				logger,
+				backup.Label,
+				backup.Type,
+				backup.checkBackupIncremental(),
+				strconv.Itoa(backup.Database.ID),
+				strconv.Itoa(backup.Database.RepoKey),
+				stanzaName,
+			)
+		}
+	}
+}
+
+// Set derived metrics for the last backups:
+//   - pgbackrest_backup_last_compression_ratio
+//   - pgbackrest_backup_last_delta_ratio
+//   - pgbackrest_backup_last_repo_size_map_ratio
+//   - pgbackrest_backup_last_throughput_bytes_per_second
+func getBackupLastRatioMetrics(stanzaName string, lastBackups lastBackupsStruct, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	for _, backup := range []backupStruct{lastBackups.full, lastBackups.diff, lastBackups.incr} {
+		ratios := getBackupRatios(
+			backup.backupSize,
+			backup.backupDelta,
+			backup.backupRepoSize,
+			backup.backupRepoSizeMap,
+			backup.backupRepoDeltaMap,
+			backup.backupDuration,
+		)
+		for i, ratio := range ratios {
+			if !ratio.ok {
+				continue
+			}
+			setUpMetric(
+				backupLastRatioMetrics[i].metric,
+				backupLastRatioMetrics[i].metricName,
+				ratio.value,
+				setUpMetricValueFun,
+				logger,
+				backup.backupType,
+				backup.backupBlockIncr,
+				stanzaName,
+			)
+		}
+	}
+}
+
+func resetBackupRatioMetrics() {
+	pgbrStanzaBackupCompressionRatioMetric.Reset()
+	pgbrStanzaBackupDeltaRatioMetric.Reset()
+	pgbrStanzaBackupRepoSizeMapRatioMetric.Reset()
+	pgbrStanzaBackupThroughputMetric.Reset()
+	pgbrStanzaBackupLastCompressionRatioMetric.Reset()
+	pgbrStanzaBackupLastDeltaRatioMetric.Reset()
+	pgbrStanzaBackupLastRepoSizeMapRatioMetric.Reset()
+	pgbrStanzaBackupLastThroughputMetric.Reset()
+}
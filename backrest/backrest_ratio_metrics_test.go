@@ -0,0 +1,85 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// The first backup is a regular full backup.
+// The second is block incremental backup without repository size and with zero duration,
+// so compression ratio and throughput are not set for it.
+func TestGetBackupRatioMetrics(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_backup_compression_ratio Ratio of uncompressed database size to compressed files size to restore the database from backup.
+# TYPE pgbackrest_backup_compression_ratio gauge
+pgbackrest_backup_compression_ratio{backup_name="20210607-092423F",backup_type="full",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 10
+# HELP pgbackrest_backup_delta_ratio Ratio of data to actually backup to uncompressed database size.
+# TYPE pgbackrest_backup_delta_ratio gauge
+pgbackrest_backup_delta_ratio{backup_name="20210607-092423F",backup_type="full",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 1
+pgbackrest_backup_delta_ratio{backup_name="20210607-092423F_20210607-092500I",backup_type="incr",block_incr="y",database_id="1",repo_key="1",stanza="demo"} 0.1
+# HELP pgbackrest_backup_last_compression_ratio Ratio of uncompressed database size to compressed files size to restore the database from the last full, differential or incremental backup.
+# TYPE pgbackrest_backup_last_compression_ratio gauge
+pgbackrest_backup_last_compression_ratio{backup_type="diff",block_incr="n",stanza="demo"} 10
+pgbackrest_backup_last_compression_ratio{backup_type="full",block_incr="n",stanza="demo"} 10
+# HELP pgbackrest_backup_last_delta_ratio Ratio of data to actually backup to uncompressed database size in the last full, differential or incremental backup.
+# TYPE pgbackrest_backup_last_delta_ratio gauge
+pgbackrest_backup_last_delta_ratio{backup_type="diff",block_incr="n",stanza="demo"} 1
+pgbackrest_backup_last_delta_ratio{backup_type="full",block_incr="n",stanza="demo"} 1
+pgbackrest_backup_last_delta_ratio{backup_type="incr",block_incr="y",stanza="demo"} 0.1
+# HELP pgbackrest_backup_last_repo_size_map_ratio Ratio of block incremental map size to block incremental delta map size in the last full, differential or incremental backup.
+# TYPE pgbackrest_backup_last_repo_size_map_ratio gauge
+pgbackrest_backup_last_repo_size_map_ratio{backup_type="incr",block_incr="y",stanza="demo"} 2
+# HELP pgbackrest_backup_last_throughput_bytes_per_second Amount of data in the database to actually backup per second of duration of the last full, differential or incremental backup.
+# TYPE pgbackrest_backup_last_throughput_bytes_per_second gauge
+pgbackrest_backup_last_throughput_bytes_per_second{backup_type="diff",block_incr="n",stanza="demo"} 2500
+pgbackrest_backup_last_throughput_bytes_per_second{backup_type="full",block_incr="n",stanza="demo"} 2500
+# HELP pgbackrest_backup_repo_size_map_ratio Ratio of block incremental map size to block incremental delta map size in backup.
+# TYPE pgbackrest_backup_repo_size_map_ratio gauge
+pgbackrest_backup_repo_size_map_ratio{backup_name="20210607-092423F_20210607-092500I",backup_type="incr",block_incr="y",database_id="1",repo_key="1",stanza="demo"} 2
+# HELP pgbackrest_backup_throughput_bytes_per_second Amount of data in the database to actually backup per second of backup duration.
+# TYPE pgbackrest_backup_throughput_bytes_per_second gauge
+pgbackrest_backup_throughput_bytes_per_second{backup_name="20210607-092423F",backup_type="full",block_incr="n",database_id="1",repo_key="1",stanza="demo"} 2500
+`
+	backupData := parseTemplateBackups(`[` +
+		`{"label":"20210607-092423F","type":"full","database":{"id":1,"repo-key":1},"error":false,` +
+		`"info":{"delta":10000,"repository":{"delta":1000,"size":1000},"size":10000},` +
+		`"timestamp":{"start":1623057863,"stop":1623057867}},` +
+		`{"label":"20210607-092423F_20210607-092500I","prior":"20210607-092423F","type":"incr",` +
+		`"database":{"id":1,"repo-key":1},"error":false,` +
+		`"info":{"delta":1000,"repository":{"delta":100,"delta-map":10,"size-map":20},"size":10000},` +
+		`"timestamp":{"start":1623057900,"stop":1623057900}}]`)
+	lastBackups := initLastBackupStruct()
+	for _, backup := range backupData {
+		compareLastBackups(&lastBackups, backup, backup.checkBackupIncremental())
+	}
+	resetBackupRatioMetrics()
+	getBackupRatioMetrics("demo", backupData, setUpMetricValue, logger)
+	getBackupLastRatioMetrics("demo", lastBackups, setUpMetricValue, logger)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrStanzaBackupCompressionRatioMetric,
+		pgbrStanzaBackupDeltaRatioMetric,
+		pgbrStanzaBackupRepoSizeMapRatioMetric,
+		pgbrStanzaBackupThroughputMetric,
+		pgbrStanzaBackupLastCompressionRatioMetric,
+		pgbrStanzaBackupLastDeltaRatioMetric,
+		pgbrStanzaBackupLastRepoSizeMapRatioMetric,
+		pgbrStanzaBackupLastThroughputMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
@@ -0,0 +1,76 @@
+package backrest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// WAL segment name consists of timeline (8 chars), log (8 chars) and segment (8 chars).
+// Segments are stored in repository in directories named by timeline and log.
+const walDirNameLength = 16
+
+func returnRepoLsExecArgs(repoKey int, path, filter string, recurse bool) []string {
+	args := []string{"repo-ls", "--output", "json"}
+	if repoKey != 0 {
+		args = append(args, "--repo", strconv.Itoa(repoKey))
+	}
+	if filter != "" {
+		args = append(args, "--filter", filter)
+	}
+	if recurse {
+		args = append(args, "--recurse")
+	}
+	// Path is relative to the repository path.
+	return append(args, path)
+}
+
+func getRepoLsData(config, configIncludePath string, repoKey int, path, filter string, recurse bool, logger *slog.Logger) ([]byte, error) {
+	args := [][]string{
+		returnRepoLsExecArgs(repoKey, path, filter, recurse),
+		returnConfigExecArgs(config, configIncludePath),
+	}
+	return execBackRestCommand(appName, concatExecArgs(args), logger)
+}
+
+func parseRepoLsResult(output []byte) (map[string]repoLsEntry, error) {
+	var entries map[string]repoLsEntry
+	err := json.Unmarshal(output, &entries)
+	return entries, err
+}
+
+// getLastWALArchiveTimes returns modification time of the latest archived WAL segment for each repository.
+// Repositories without archived WAL segments are not returned.
+// Information about archives contains only WAL segment names,
+// so the time is taken from the file in repository.
+func getLastWALArchiveTimes(config, configIncludePath, stanzaName string, archives []archive, logger *slog.Logger) (map[int]time.Time, error) {
+	result := make(map[int]time.Time)
+	for _, archiveData := range archives {
+		if len(archiveData.WALMax) < walDirNameLength {
+			continue
+		}
+		path := fmt.Sprintf("archive/%s/%s/%s", stanzaName, archiveData.PGVersion, archiveData.WALMax[:walDirNameLength])
+		data, err := getRepoLsData(config, configIncludePath, archiveData.Database.RepoKey, path, "^"+archiveData.WALMax, false, logger)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseRepoLsResult(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type != "file" || entry.Time == nil {
+				continue
+			}
+			// Several archives are for different database ids (e.g. after upgrade),
+			// the latest time is used.
+			fileTime := time.Unix(*entry.Time, 0)
+			if fileTime.After(result[archiveData.Database.RepoKey]) {
+				result[archiveData.Database.RepoKey] = fileTime
+			}
+		}
+	}
+	return result, nil
+}
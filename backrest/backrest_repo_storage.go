@@ -0,0 +1,345 @@
+package backrest
+
+import (
+	"log/slog"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Areas of stanza in repository.
+const (
+	repoAreaBackup  = "backup"
+	repoAreaArchive = "archive"
+)
+
+var (
+	pgbrRepoStorageBytesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_storage_bytes",
+		Help: "Total size of files in repository.",
+	},
+		[]string{
+			"area",
+			"repo_key",
+			"stanza"})
+	pgbrRepoStorageFilesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_storage_files",
+		Help: "Number of files in repository.",
+	},
+		[]string{
+			"area",
+			"repo_key",
+			"stanza"})
+	pgbrRepoStorageWALFilesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_storage_wal_files",
+		Help: "Number of WAL segments in repository.",
+	},
+		[]string{
+			"archive_id",
+			"repo_key",
+			"stanza",
+			"timeline"})
+	pgbrRepoStorageWALBytesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_storage_wal_bytes",
+		Help: "Total size of WAL segments in repository.",
+	},
+		[]string{
+			"archive_id",
+			"repo_key",
+			"stanza",
+			"timeline"})
+	// Directories of backups are removed by pgBackRest on expire.
+	// Remaining ones can be left after manual deletion of backups from info or failed expire.
+	pgbrRepoStorageOrphanedBackupMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_repo_storage_orphaned_backup_bytes",
+		Help: "Total size of files in backup directory that is not listed in pgbackrest info.",
+	},
+		[]string{
+			"backup_name",
+			"repo_key",
+			"stanza"})
+)
+
+var (
+	// E.g. "20210607-092423F" or "20210607-092423F_20210607-092500I".
+	backupDirRegexp = regexp.MustCompile(`^\d{8}-\d{6}F(_\d{8}-\d{6}[DI])?$`)
+	// E.g. "000000010000000000000004-bcdc2a8fa3d0b4ab5bd6fb8d8b7a8d9b9a7c4d47.gz" or with ".partial" suffix.
+	walSegmentRegexp = regexp.MustCompile(`^([0-9A-F]{8})[0-9A-F]{16}(\.partial)?-[0-9a-f]{40}`)
+)
+
+// RepoStorageProbeConfig contains parameters for getting repository usage periodically.
+type RepoStorageProbeConfig struct {
+	// Config is the full path to pgBackRest configuration file.
+	Config string
+	// ConfigIncludePath is the full path to additional pgBackRest configuration files.
+	ConfigIncludePath string
+	// IncludeStanza and ExcludeStanza are the same as for collecting metrics.
+	IncludeStanza []string
+	ExcludeStanza []string
+	// Interval is the interval between runs, disabled when 0.
+	Interval time.Duration
+}
+
+// repoStorageUsage is the usage of stanza area in repository.
+type repoStorageUsage struct {
+	bytes int64
+	files int
+}
+
+// walStorageKey identifies WAL segments of timeline in archive.
+type walStorageKey struct {
+	archiveID string
+	timeline  string
+}
+
+// repoStorageKey identifies repository of stanza.
+type repoStorageKey struct {
+	stanza  string
+	repoKey int
+}
+
+// repoStorageResult is the usage of stanza in repository.
+type repoStorageResult struct {
+	areas map[string]repoStorageUsage
+	wal   map[walStorageKey]repoStorageUsage
+	// Nil when orphaned backups are not detected, e.g. backup is running.
+	orphanedBackups map[string]repoStorageUsage
+}
+
+// repoStorageProbe keeps the latest results of listing repositories.
+// Mutex prevents overlapping runs.
+type repoStorageProbe struct {
+	mu      sync.Mutex
+	results map[repoStorageKey]repoStorageResult
+}
+
+// StartRepoStorageProbe lists files of stanzas in repositories in the background.
+// Listing all files can be slow, so it's run on its own schedule.
+// The first run is performed after the interval, so it doesn't delay the first collection.
+func StartRepoStorageProbe(cfg RepoStorageProbeConfig, logger *slog.Logger) {
+	if cfg.Interval <= 0 {
+		return
+	}
+	logger.Info("Getting repository storage usage periodically", "interval", cfg.Interval)
+	probe := &repoStorageProbe{results: make(map[repoStorageKey]repoStorageResult)}
+	go func() {
+		for {
+			time.Sleep(cfg.Interval)
+			probe.run(cfg, setUpMetricValue, logger)
+		}
+	}()
+}
+
+// run lists each repository of stanzas and sets metrics.
+// Repositories that can't be listed keep their previous results, errors are logged.
+// Metrics for stanzas that are not returned by pgBackRest anymore are removed.
+func (probe *repoStorageProbe) run(cfg RepoStorageProbeConfig, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	stanzas, err := getProbeStanzas(cfg.Config, cfg.ConfigIncludePath, cfg.IncludeStanza, cfg.ExcludeStanza, logger)
+	if err != nil {
+		return
+	}
+	results := make(map[repoStorageKey]repoStorageResult)
+	for _, singleStanza := range stanzas {
+		for _, repoKey := range getStanzaRepoKeys(singleStanza) {
+			key := repoStorageKey{singleStanza.Name, repoKey}
+			result, err := getRepoStorageResult(cfg.Config, cfg.ConfigIncludePath, singleStanza, repoKey, logger)
+			if err != nil {
+				logger.Error("Get repository files failed", "stanza", singleStanza.Name, "repo_key", repoKey, "err", err)
+				if previous, ok := probe.results[key]; ok {
+					results[key] = previous
+				}
+				continue
+			}
+			results[key] = result
+		}
+	}
+	probe.results = results
+	resetRepoStorageMetrics()
+	for key, result := range results {
+		getRepoStorageMetrics(key.stanza, key.repoKey, result, setUpMetricValueFun, logger)
+	}
+}
+
+// getRepoStorageResult lists backup and archive areas of stanza in repository.
+func getRepoStorageResult(config, configIncludePath string, stanzaData stanza, repoKey int, logger *slog.Logger) (repoStorageResult, error) {
+	result := repoStorageResult{areas: make(map[string]repoStorageUsage)}
+	for _, area := range []string{repoAreaBackup, repoAreaArchive} {
+		data, err := getRepoLsData(config, configIncludePath, repoKey, area+"/"+stanzaData.Name, "", true, logger)
+		if err != nil {
+			return result, err
+		}
+		entries, err := parseRepoLsResult(data)
+		if err != nil {
+			return result, err
+		}
+		result.areas[area] = getRepoStorageUsage(entries)
+		switch area {
+		case repoAreaArchive:
+			result.wal = getWALStorageUsage(entries)
+		case repoAreaBackup:
+			// Directory of running backup is not listed in info until backup is completed.
+			if !stanzaData.Status.Lock.Backup.Held {
+				result.orphanedBackups = getOrphanedBackups(entries, stanzaData.Backup, repoKey)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Set repository storage metrics:
+//   - pgbackrest_repo_storage_bytes
+//   - pgbackrest_repo_storage_files
+//   - pgbackrest_repo_storage_wal_files
+//   - pgbackrest_repo_storage_wal_bytes
+//   - pgbackrest_repo_storage_orphaned_backup_bytes
+func getRepoStorageMetrics(stanzaName string, repoKey int, result repoStorageResult, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	repoKeyLabel := strconv.Itoa(repoKey)
+	for area, usage := range result.areas {
+		setUpMetric(
+			pgbrRepoStorageBytesMetric,
+			"pgbackrest_repo_storage_bytes",
+			float64(usage.bytes),
+			setUpMetricValueFun,
+			logger,
+			area,
+			repoKeyLabel,
+			stanzaName,
+		)
+		setUpMetric(
+			pgbrRepoStorageFilesMetric,
+			"pgbackrest_repo_storage_files",
+			float64(usage.files),
+			setUpMetricValueFun,
+			logger,
+			area,
+			repoKeyLabel,
+			stanzaName,
+		)
+	}
+	// Used for forecasting repository growth.
+	repoArchiveSizesStore.set(stanzaName, repoKey, result.areas[repoAreaArchive].bytes)
+	for key, walUsage := range result.wal {
+		labels := []string{key.archiveID, repoKeyLabel, stanzaName, key.timeline}
+		setUpMetric(
+			pgbrRepoStorageWALFilesMetric,
+			"pgbackrest_repo_storage_wal_files",
+			float64(walUsage.files),
+			setUpMetricValueFun,
+			logger,
+			labels...,
+		)
+		setUpMetric(
+			pgbrRepoStorageWALBytesMetric,
+			"pgbackrest_repo_storage_wal_bytes",
+			float64(walUsage.bytes),
+			setUpMetricValueFun,
+			logger,
+			labels...,
+		)
+	}
+	for label, backupUsage := range result.orphanedBackups {
+		setUpMetric(
+			pgbrRepoStorageOrphanedBackupMetric,
+			"pgbackrest_repo_storage_orphaned_backup_bytes",
+			float64(backupUsage.bytes),
+			setUpMetricValueFun,
+			logger,
+			label,
+			repoKeyLabel,
+			stanzaName,
+		)
+	}
+}
+
+// getRepoStorageUsage returns total size and number of files.
+// Links (e.g. "latest") and directories are not counted.
+func getRepoStorageUsage(entries map[string]repoLsEntry) repoStorageUsage {
+	var usage repoStorageUsage
+	for _, entry := range entries {
+		if entry.Type != "file" {
+			continue
+		}
+		usage.files++
+		if entry.Size != nil {
+			usage.bytes += *entry.Size
+		}
+	}
+	return usage
+}
+
+// getWALStorageUsage returns usage of WAL segments by archive id and timeline.
+// Names are relative to archive/<stanza>, e.g. "13-1/0000000100000000/000000010000000000000004-<sha1>.gz".
+// Other files, e.g. history and backup label files, are not counted.
+func getWALStorageUsage(entries map[string]repoLsEntry) map[walStorageKey]repoStorageUsage {
+	result := make(map[walStorageKey]repoStorageUsage)
+	for name, entry := range entries {
+		if entry.Type != "file" {
+			continue
+		}
+		archiveID, _, ok := strings.Cut(name, "/")
+		if !ok {
+			continue
+		}
+		match := walSegmentRegexp.FindStringSubmatch(path.Base(name))
+		if match == nil {
+			continue
+		}
+		key := walStorageKey{archiveID, match[1]}
+		usage := result[key]
+		usage.files++
+		if entry.Size != nil {
+			usage.bytes += *entry.Size
+		}
+		result[key] = usage
+	}
+	return result
+}
+
+// getOrphanedBackups returns usage of backup directories not listed in info for repository.
+// Names are relative to backup/<stanza>, e.g. "20210607-092423F/backup.manifest".
+func getOrphanedBackups(entries map[string]repoLsEntry, backupData []backup, repoKey int) map[string]repoStorageUsage {
+	var labels []string
+	for _, backup := range backupData {
+		backupRepoKey := backup.Database.RepoKey
+		// For pgBackRest < v2.32 repo key is not returned, only one repository is supported.
+		if backupRepoKey == 0 {
+			backupRepoKey = 1
+		}
+		if backupRepoKey == repoKey {
+			labels = append(labels, backup.Label)
+		}
+	}
+	result := make(map[string]repoStorageUsage)
+	for name, entry := range entries {
+		dir, _, _ := strings.Cut(name, "/")
+		if !backupDirRegexp.MatchString(dir) || slices.Contains(labels, dir) {
+			continue
+		}
+		usage := result[dir]
+		if entry.Type == "file" {
+			usage.files++
+			if entry.Size != nil {
+				usage.bytes += *entry.Size
+			}
+		}
+		result[dir] = usage
+	}
+	return result
+}
+
+func resetRepoStorageMetrics() {
+	pgbrRepoStorageBytesMetric.Reset()
+	pgbrRepoStorageFilesMetric.Reset()
+	pgbrRepoStorageWALFilesMetric.Reset()
+	pgbrRepoStorageWALBytesMetric.Reset()
+	pgbrRepoStorageOrphanedBackupMetric.Reset()
+}
@@ -0,0 +1,226 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	templateRepoLsBackup = `{".":{"type":"path"},` +
+		`"20210607-092423F":{"type":"path"},` +
+		`"20210607-092423F/backup.manifest":{"type":"file","size":1000,"time":1623057867},` +
+		`"20210607-092423F/pg_data/PG_VERSION.gz":{"type":"file","size":20,"time":1623057867},` +
+		`"20210601-092423F":{"type":"path"},` +
+		`"20210601-092423F/backup.manifest":{"type":"file","size":900,"time":1622539467},` +
+		`"backup.info":{"type":"file","size":500,"time":1623057867},` +
+		`"latest":{"type":"link","destination":"20210607-092423F"}}`
+	templateRepoLsArchive = `{".":{"type":"path"},` +
+		`"archive.info":{"type":"file","size":250,"time":1623057867},` +
+		`"13-1":{"type":"path"},` +
+		`"13-1/00000002.history":{"type":"file","size":40,"time":1623057867},` +
+		`"13-1/0000000100000000":{"type":"path"},` +
+		`"13-1/0000000100000000/000000010000000000000001-bcdc2a8fa3d0b4ab5bd6fb8d8b7a8d9b9a7c4d47.gz":{"type":"file","size":100,"time":1623057867},` +
+		`"13-1/0000000100000000/000000010000000000000002.00000028.backup":{"type":"file","size":10,"time":1623057867},` +
+		`"13-1/0000000100000000/000000010000000000000002-0bdc2a8fa3d0b4ab5bd6fb8d8b7a8d9b9a7c4d47.gz":{"type":"file","size":200,"time":1623057867},` +
+		`"13-1/0000000200000000":{"type":"path"},` +
+		`"13-1/0000000200000000/000000020000000000000003.partial-1bdc2a8fa3d0b4ab5bd6fb8d8b7a8d9b9a7c4d47.gz":{"type":"file","size":50,"time":1623057867}}`
+)
+
+// fakeExecCommandRepoStorage returns listing of backup or archive directory for repo-ls command and mockData for other commands.
+func fakeExecCommandRepoStorage(command string, args ...string) *exec.Cmd {
+	cs := make([]string, 0, 3+len(args))
+	cs = append(cs, "-test.run=TestExecCommandHelper", "--", command)
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	data := mockData
+	switch {
+	case slices.Contains(args, "backup/demo"):
+		data = mockStruct{templateRepoLsBackup, "", 0}
+	case slices.Contains(args, "archive/demo"):
+		data = mockStruct{templateRepoLsArchive, "", 0}
+	}
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1",
+		"STDOUT=" + data.mockStdout,
+		"STDERR=" + data.mockStderr,
+		"EXIT_STATUS=" + strconv.Itoa(data.mockExit)}
+	return cmd
+}
+
+func TestGetWALStorageUsage(t *testing.T) {
+	entries, err := parseRepoLsResult([]byte(templateRepoLsArchive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := getWALStorageUsage(entries)
+	want := map[walStorageKey]repoStorageUsage{
+		{"13-1", "00000001"}: {300, 2},
+		{"13-1", "00000002"}: {50, 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestGetOrphanedBackups(t *testing.T) {
+	entries, err := parseRepoLsResult([]byte(templateRepoLsBackup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups := parseTemplateBackups(`[{"label":"20210607-092423F","type":"full","database":{"id":1,"repo-key":1}}]`)
+	// For pgBackRest < v2.32 repo key is not returned.
+	backupsNoRepoKey := parseTemplateBackups(`[{"label":"20210607-092423F","type":"full","database":{"id":1}}]`)
+	tests := []struct {
+		name    string
+		backups []backup
+		repoKey int
+		want    map[string]repoStorageUsage
+	}{
+		{
+			"listedInInfo",
+			backups,
+			1,
+			map[string]repoStorageUsage{"20210601-092423F": {900, 1}},
+		},
+		{
+			"listedInInfoNoRepoKey",
+			backupsNoRepoKey,
+			1,
+			map[string]repoStorageUsage{"20210601-092423F": {900, 1}},
+		},
+		{
+			"otherRepo",
+			backups,
+			2,
+			map[string]repoStorageUsage{
+				"20210601-092423F": {900, 1},
+				"20210607-092423F": {1020, 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getOrphanedBackups(entries, tt.backups, tt.repoKey)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunRepoStorageProbe(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_repo_storage_bytes Total size of files in repository.
+# TYPE pgbackrest_repo_storage_bytes gauge
+pgbackrest_repo_storage_bytes{area="archive",repo_key="1",stanza="demo"} 650
+pgbackrest_repo_storage_bytes{area="backup",repo_key="1",stanza="demo"} 2420
+# HELP pgbackrest_repo_storage_files Number of files in repository.
+# TYPE pgbackrest_repo_storage_files gauge
+pgbackrest_repo_storage_files{area="archive",repo_key="1",stanza="demo"} 6
+pgbackrest_repo_storage_files{area="backup",repo_key="1",stanza="demo"} 4
+# HELP pgbackrest_repo_storage_orphaned_backup_bytes Total size of files in backup directory that is not listed in pgbackrest info.
+# TYPE pgbackrest_repo_storage_orphaned_backup_bytes gauge
+pgbackrest_repo_storage_orphaned_backup_bytes{backup_name="20210601-092423F",repo_key="1",stanza="demo"} 900
+# HELP pgbackrest_repo_storage_wal_bytes Total size of WAL segments in repository.
+# TYPE pgbackrest_repo_storage_wal_bytes gauge
+pgbackrest_repo_storage_wal_bytes{archive_id="13-1",repo_key="1",stanza="demo",timeline="00000001"} 300
+pgbackrest_repo_storage_wal_bytes{archive_id="13-1",repo_key="1",stanza="demo",timeline="00000002"} 50
+# HELP pgbackrest_repo_storage_wal_files Number of WAL segments in repository.
+# TYPE pgbackrest_repo_storage_wal_files gauge
+pgbackrest_repo_storage_wal_files{archive_id="13-1",repo_key="1",stanza="demo",timeline="00000001"} 2
+pgbackrest_repo_storage_wal_files{archive_id="13-1",repo_key="1",stanza="demo",timeline="00000002"} 1
+`
+	tests := []struct {
+		name       string
+		backupLock bool
+		want       string
+	}{
+		{"backupNotRunning", false, templateMetrics},
+		// Orphaned backups are not detected while backup is running.
+		{
+			"backupRunning",
+			true,
+			templateMetrics[:bytes.Index([]byte(templateMetrics), []byte("# HELP pgbackrest_repo_storage_orphaned"))] +
+				templateMetrics[bytes.Index([]byte(templateMetrics), []byte("# HELP pgbackrest_repo_storage_wal_bytes")):],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockData = mockStruct{
+				`[{"name":"demo","status":{"code":0,"lock":{"backup":{"held":` + strconv.FormatBool(tt.backupLock) + `}},"message":"ok"},` +
+					`"repo":[{"key":1,"status":{"code":0,"message":"ok"}}],` +
+					`"backup":[{"label":"20210607-092423F","type":"full","database":{"id":1,"repo-key":1}}]}]`,
+				"",
+				0,
+			}
+			execCommand = fakeExecCommandRepoStorage
+			defer func() { execCommand = exec.Command }()
+			probe := &repoStorageProbe{results: make(map[repoStorageKey]repoStorageResult)}
+			probe.run(RepoStorageProbeConfig{IncludeStanza: []string{""}}, setUpMetricValue, logger)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(
+				pgbrRepoStorageBytesMetric,
+				pgbrRepoStorageFilesMetric,
+				pgbrRepoStorageWALFilesMetric,
+				pgbrRepoStorageWALBytesMetric,
+				pgbrRepoStorageOrphanedBackupMetric,
+			)
+			metricFamily, err := reg.Gather()
+			if err != nil {
+				fmt.Println(err)
+			}
+			out := &bytes.Buffer{}
+			for _, mf := range metricFamily {
+				if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+					panic(err)
+				}
+			}
+			if tt.want != out.String() {
+				t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", tt.want, out.String())
+			}
+		})
+	}
+}
+
+// Repository that can't be listed keeps previous results,
+// results for stanzas that are not returned anymore are removed.
+func TestRepoStorageProbeRunListFailed(t *testing.T) {
+	mockData = mockStruct{
+		`[{"name":"demo","status":{"code":0,"lock":{"backup":{"held":false}},"message":"ok"},` +
+			`"repo":[{"key":1,"status":{"code":0,"message":"ok"}},{"key":2,"status":{"code":0,"message":"ok"}}],` +
+			`"backup":[{"label":"20210607-092423F","type":"full","database":{"id":1,"repo-key":1}}]}]`,
+		"",
+		0,
+	}
+	// Listing of repo2 fails.
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		cmd := fakeExecCommandRepoStorage(command, args...)
+		if i := slices.Index(args, "--repo"); i != -1 && args[i+1] == "2" {
+			cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1", "STDOUT=", "STDERR=unable to list", "EXIT_STATUS=1"}
+		}
+		return cmd
+	}
+	defer func() { execCommand = exec.Command }()
+	previous := repoStorageResult{areas: map[string]repoStorageUsage{repoAreaBackup: {100, 1}}}
+	probe := &repoStorageProbe{results: map[repoStorageKey]repoStorageResult{
+		{"demo", 2}:    previous,
+		{"removed", 1}: previous,
+	}}
+	probe.run(RepoStorageProbeConfig{IncludeStanza: []string{""}}, setUpMetricValue, logger)
+	if len(probe.results) != 2 {
+		t.Errorf("\nVariables do not match, results:\n%+v\nwant:\n%d", probe.results, 2)
+	}
+	if got := probe.results[repoStorageKey{"demo", 2}]; !reflect.DeepEqual(got, previous) {
+		t.Errorf("\nVariables do not match:\n%+v\nwant:\n%+v", got, previous)
+	}
+	if got := probe.results[repoStorageKey{"demo", 1}].areas[repoAreaArchive]; got != (repoStorageUsage{650, 6}) {
+		t.Errorf("\nVariables do not match:\n%+v\nwant:\n%+v", got, repoStorageUsage{650, 6})
+	}
+}
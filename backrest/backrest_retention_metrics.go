@@ -0,0 +1,220 @@
+package backrest
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pgbrRetentionExpectedBackupsMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_retention_expected_backups",
+		Help: "Number of backups to retain from pgBackRest configuration.",
+	},
+		[]string{
+			"backup_type",
+			"repo_key",
+			"stanza"})
+	pgbrRetentionActualBackupsMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_retention_actual_backups",
+		Help: "Number of backups in repository counted for retention.",
+	},
+		[]string{
+			"backup_type",
+			"repo_key",
+			"stanza"})
+	pgbrRetentionExpireStatusMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_retention_expire_status",
+		Help: "Backups in repository don't exceed retention.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrRetentionRestorePointsStatusMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_retention_restore_points_status",
+		Help: "Backups in repository provide restore points required by retention.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+)
+
+// retentionCompliance is the result of comparing backups with retention settings.
+type retentionCompliance struct {
+	withinRetention bool
+	restorePoints   bool
+}
+
+// getRetentionMetrics compares backups of stanzas with retention settings from pgBackRest configuration.
+// Repositories with unreliable list of backups and without retention-full option are skipped.
+// Archive retention options are not checked, they define kept WAL segments, not backups.
+func getRetentionMetrics(cfg *backrestConfig, stanzas []stanza, currentUnixTime int64, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	for _, stanzaData := range stanzas {
+		repoBackups := getBackupsByRepo(stanzaData.Backup)
+		for _, repoKey := range getStanzaRepoKeys(stanzaData) {
+			if !isHistoryRepoAvailable(stanzaData, repoKey) {
+				continue
+			}
+			getRepoRetentionMetrics(cfg, stanzaData.Name, repoKey, repoBackups[repoKey], currentUnixTime, setUpMetricValueFun, logger)
+		}
+	}
+}
+
+// Set retention metrics:
+//   - pgbackrest_retention_expected_backups
+//   - pgbackrest_retention_actual_backups
+//   - pgbackrest_retention_expire_status
+//   - pgbackrest_retention_restore_points_status
+func getRepoRetentionMetrics(cfg *backrestConfig, stanzaName string, repoKey int, backups []backup, currentUnixTime int64, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	retentionFull, ok := getRetentionOption(cfg, stanzaName, "repo-retention-full", repoKey, logger)
+	// Without retention-full option backups are not expired.
+	if !ok {
+		return
+	}
+	repoKeyLabel := strconv.Itoa(repoKey)
+	// Newest backups first.
+	slices.SortFunc(backups, func(a, b backup) int {
+		return cmp.Compare(b.Timestamp.Stop, a.Timestamp.Stop)
+	})
+	fullStops := make([]int64, 0, len(backups))
+	for _, backup := range backups {
+		if backup.Type == "full" {
+			fullStops = append(fullStops, backup.Timestamp.Stop)
+		}
+	}
+	var compliance retentionCompliance
+	switch getConfigOption(cfg, stanzaName, "expire", "repo-retention-full-type", repoKey) {
+	case "time":
+		// Retention is in days, all full backups needed to restore to that time are kept.
+		compliance = getTimeRetentionCompliance(fullStops, currentUnixTime-int64(retentionFull)*86400)
+	default:
+		compliance = retentionCompliance{
+			withinRetention: len(fullStops) <= retentionFull,
+			restorePoints:   len(fullStops) >= retentionFull,
+		}
+		setUpMetric(
+			pgbrRetentionExpectedBackupsMetric,
+			"pgbackrest_retention_expected_backups",
+			float64(retentionFull),
+			setUpMetricValueFun,
+			logger,
+			"full",
+			repoKeyLabel,
+			stanzaName,
+		)
+	}
+	setUpMetric(
+		pgbrRetentionActualBackupsMetric,
+		"pgbackrest_retention_actual_backups",
+		float64(len(fullStops)),
+		setUpMetricValueFun,
+		logger,
+		"full",
+		repoKeyLabel,
+		stanzaName,
+	)
+	if retentionDiff, ok := getRetentionOption(cfg, stanzaName, "repo-retention-diff", repoKey, logger); ok {
+		diffCompliance, diffCount := getDiffRetentionCompliance(backups, retentionDiff)
+		compliance.withinRetention = compliance.withinRetention && diffCompliance.withinRetention
+		compliance.restorePoints = compliance.restorePoints && diffCompliance.restorePoints
+		setUpMetric(
+			pgbrRetentionExpectedBackupsMetric,
+			"pgbackrest_retention_expected_backups",
+			float64(retentionDiff),
+			setUpMetricValueFun,
+			logger,
+			"diff",
+			repoKeyLabel,
+			stanzaName,
+		)
+		setUpMetric(
+			pgbrRetentionActualBackupsMetric,
+			"pgbackrest_retention_actual_backups",
+			float64(diffCount),
+			setUpMetricValueFun,
+			logger,
+			"diff",
+			repoKeyLabel,
+			stanzaName,
+		)
+	}
+	setUpMetric(
+		pgbrRetentionExpireStatusMetric,
+		"pgbackrest_retention_expire_status",
+		convertBoolToFloat64(compliance.withinRetention),
+		setUpMetricValueFun,
+		logger,
+		repoKeyLabel,
+		stanzaName,
+	)
+	setUpMetric(
+		pgbrRetentionRestorePointsStatusMetric,
+		"pgbackrest_retention_restore_points_status",
+		convertBoolToFloat64(compliance.restorePoints),
+		setUpMetricValueFun,
+		logger,
+		repoKeyLabel,
+		stanzaName,
+	)
+}
+
+// getRetentionOption returns positive integer value of retention option.
+func getRetentionOption(cfg *backrestConfig, stanzaName, option string, repoKey int, logger *slog.Logger) (int, bool) {
+	value := getConfigOption(cfg, stanzaName, "expire", option, repoKey)
+	if value == "" {
+		return 0, false
+	}
+	retention, err := strconv.Atoi(value)
+	if err != nil || retention < 1 {
+		logger.Error("Parse retention option failed", "stanza", stanzaName, "repo_key", repoKey, "option", option, "value", value)
+		return 0, false
+	}
+	return retention, true
+}
+
+// getTimeRetentionCompliance checks full backups sorted from newest for time based retention.
+// pgBackRest keeps full backups completed after the threshold and the newest one completed before it.
+func getTimeRetentionCompliance(fullStops []int64, threshold int64) retentionCompliance {
+	older := 0
+	for _, stop := range fullStops {
+		if stop <= threshold {
+			older++
+		}
+	}
+	return retentionCompliance{
+		withinRetention: older <= 1,
+		restorePoints:   older >= 1,
+	}
+}
+
+// getDiffRetentionCompliance checks backups sorted from newest for retention-diff.
+// Full backups are included in the count of differential backups by pgBackRest,
+// but only differential backups are expired.
+// Returns the number of full and differential backups.
+func getDiffRetentionCompliance(backups []backup, retentionDiff int) (retentionCompliance, int) {
+	count, withinRetention := 0, true
+	for _, backup := range backups {
+		if backup.Type != "full" && backup.Type != "diff" {
+			continue
+		}
+		count++
+		if backup.Type == "diff" && count > retentionDiff {
+			withinRetention = false
+		}
+	}
+	return retentionCompliance{
+		withinRetention: withinRetention,
+		restorePoints:   count >= retentionDiff,
+	}, count
+}
+
+func resetRetentionMetrics() {
+	pgbrRetentionExpectedBackupsMetric.Reset()
+	pgbrRetentionActualBackupsMetric.Reset()
+	pgbrRetentionExpireStatusMetric.Reset()
+	pgbrRetentionRestorePointsStatusMetric.Reset()
+}
@@ -0,0 +1,120 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Retention is 2 full and 3 differential backups for repo 1 and 7 days for repo 2:
+//   - demo has 3 full backups in repo 1, so expire doesn't work;
+//     repo 2 has one full backup older than 7 days and one newer, as expected;
+//     repo 3 is unavailable;
+//   - demo2 has only one full backup, so there are fewer restore points than configured.
+func TestGetRetentionMetrics(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_retention_actual_backups Number of backups in repository counted for retention.
+# TYPE pgbackrest_retention_actual_backups gauge
+pgbackrest_retention_actual_backups{backup_type="diff",repo_key="1",stanza="demo"} 4
+pgbackrest_retention_actual_backups{backup_type="diff",repo_key="1",stanza="demo2"} 1
+pgbackrest_retention_actual_backups{backup_type="full",repo_key="1",stanza="demo"} 3
+pgbackrest_retention_actual_backups{backup_type="full",repo_key="1",stanza="demo2"} 1
+pgbackrest_retention_actual_backups{backup_type="full",repo_key="2",stanza="demo"} 2
+# HELP pgbackrest_retention_expected_backups Number of backups to retain from pgBackRest configuration.
+# TYPE pgbackrest_retention_expected_backups gauge
+pgbackrest_retention_expected_backups{backup_type="diff",repo_key="1",stanza="demo"} 3
+pgbackrest_retention_expected_backups{backup_type="diff",repo_key="1",stanza="demo2"} 3
+pgbackrest_retention_expected_backups{backup_type="full",repo_key="1",stanza="demo"} 2
+pgbackrest_retention_expected_backups{backup_type="full",repo_key="1",stanza="demo2"} 2
+# HELP pgbackrest_retention_expire_status Backups in repository don't exceed retention.
+# TYPE pgbackrest_retention_expire_status gauge
+pgbackrest_retention_expire_status{repo_key="1",stanza="demo"} 0
+pgbackrest_retention_expire_status{repo_key="1",stanza="demo2"} 1
+pgbackrest_retention_expire_status{repo_key="2",stanza="demo"} 1
+# HELP pgbackrest_retention_restore_points_status Backups in repository provide restore points required by retention.
+# TYPE pgbackrest_retention_restore_points_status gauge
+pgbackrest_retention_restore_points_status{repo_key="1",stanza="demo"} 1
+pgbackrest_retention_restore_points_status{repo_key="1",stanza="demo2"} 0
+pgbackrest_retention_restore_points_status{repo_key="2",stanza="demo"} 1
+`
+	const day = 86400
+	base := int64(1623057866)
+	stanzas, err := parseResult([]byte(`[{"name":"demo","backup":[` +
+		templateRetentionBackup("20210607-092423F", "full", 1, base) + `,` +
+		templateRetentionBackup("20210608-092423F", "full", 1, base+day) + `,` +
+		templateRetentionBackup("20210609-092423F", "full", 1, base+2*day) + `,` +
+		templateRetentionBackup("20210609-092423F_20210609-212423D", "diff", 1, base+2*day+day/2) + `,` +
+		templateRetentionBackup("20210609-092423F_20210609-222423I", "incr", 1, base+2*day+day/2+3600) + `,` +
+		templateRetentionBackup("20210609-092423F", "full", 2, base+2*day) + `,` +
+		templateRetentionBackup("20210612-092423F", "full", 2, base+5*day) + `,` +
+		templateRetentionBackup("20210612-092423F", "full", 3, base+5*day) +
+		`],"repo":[{"key":1,"status":{"code":0}},{"key":2,"status":{"code":0}},{"key":3,"status":{"code":99}}],"status":{"code":0}},` +
+		`{"name":"demo2","backup":[` + templateRetentionBackup("20210616-092423F", "full", 1, base+9*day) +
+		`],"status":{"code":0}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile, includePath := writeTemplateConfig(t, `[global]
+repo1-retention-full=2
+repo1-retention-diff=3
+repo2-retention-full-type=time
+repo2-retention-full=7
+repo3-retention-full=1
+`, "")
+	cfg, err := loadBackrestConfig(configFile, includePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetRetentionMetrics()
+	getRetentionMetrics(cfg, stanzas, base+10*day, setUpMetricValue, logger)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrRetentionExpectedBackupsMetric,
+		pgbrRetentionActualBackupsMetric,
+		pgbrRetentionExpireStatusMetric,
+		pgbrRetentionRestorePointsStatusMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
+func TestGetTimeRetentionCompliance(t *testing.T) {
+	tests := []struct {
+		name      string
+		fullStops []int64
+		want      retentionCompliance
+	}{
+		{"noBackups", nil, retentionCompliance{true, false}},
+		{"onlyNewer", []int64{300, 200}, retentionCompliance{true, false}},
+		{"oneOlder", []int64{300, 100}, retentionCompliance{true, true}},
+		{"twoOlder", []int64{300, 100, 50}, retentionCompliance{false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getTimeRetentionCompliance(tt.fullStops, 150); got != tt.want {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func templateRetentionBackup(label, backupType string, repoKey int, stop int64) string {
+	return fmt.Sprintf(
+		`{"label":"%s","type":"%s","database":{"id":1,"repo-key":%d},"error":false,`+
+			`"info":{"delta":100,"repository":{"delta":100,"size":100},"size":100},`+
+			`"timestamp":{"start":%d,"stop":%d}}`,
+		label, backupType, repoKey, stop-60, stop)
+}
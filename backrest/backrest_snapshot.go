@@ -0,0 +1,104 @@
+package backrest
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// collectionSnapshot contains data received from pgBackRest during one collection.
+// It's used by HTTP handlers that need parsed data rather than metrics.
+type collectionSnapshot struct {
+	// Time when the collection was started.
+	collectedAt time.Time
+	// Stanzas in the order of pgBackRest output.
+	// Excluded stanzas are not stored.
+	stanzas []stanza
+	// Errors occurred during the collection.
+	errors []collectionError
+}
+
+// collectionError describes an error occurred when fetching information from pgBackRest.
+type collectionError struct {
+	time time.Time
+	// Stanza name, empty when the information is collected for all stanzas.
+	stanza  string
+	message string
+}
+
+var (
+	lastSnapshotMutex sync.RWMutex
+	lastSnapshot      *collectionSnapshot
+	// The last error is kept even if the following collections are successful.
+	lastError *collectionError
+)
+
+func newCollectionSnapshot(collectedAt time.Time) *collectionSnapshot {
+	return &collectionSnapshot{collectedAt: collectedAt}
+}
+
+func (snapshot *collectionSnapshot) addStanza(stanzaData stanza) {
+	snapshot.stanzas = append(snapshot.stanzas, stanzaData)
+}
+
+func (snapshot *collectionSnapshot) addError(stanzaName string, err error) {
+	snapshot.errors = append(snapshot.errors, collectionError{
+		time:    snapshot.collectedAt,
+		stanza:  stanzaName,
+		message: err.Error(),
+	})
+}
+
+// err returns all errors occurred during the collection joined into one.
+// If there were no errors, nil is returned.
+func (snapshot *collectionSnapshot) err() error {
+	errs := make([]error, 0, len(snapshot.errors))
+	for _, collectErr := range snapshot.errors {
+		errs = append(errs, collectErr)
+	}
+	return errors.Join(errs...)
+}
+
+func (collectErr collectionError) Error() string {
+	if collectErr.stanza == "" {
+		return collectErr.message
+	}
+	return "stanza " + collectErr.stanza + ": " + collectErr.message
+}
+
+// getStanza returns stanza data by name.
+func (snapshot *collectionSnapshot) getStanza(name string) (stanza, bool) {
+	for _, stanzaData := range snapshot.stanzas {
+		if stanzaData.Name == name {
+			return stanzaData, true
+		}
+	}
+	return stanza{}, false
+}
+
+// storeSnapshot replaces the latest collected snapshot.
+// The snapshot must not be modified after it has been stored.
+func storeSnapshot(snapshot *collectionSnapshot) {
+	lastSnapshotMutex.Lock()
+	defer lastSnapshotMutex.Unlock()
+	lastSnapshot = snapshot
+	if snapshot != nil && len(snapshot.errors) != 0 {
+		lastError = &snapshot.errors[len(snapshot.errors)-1]
+	}
+}
+
+// loadSnapshot returns the latest collected snapshot.
+// If there were no collections yet, nil is returned.
+func loadSnapshot() *collectionSnapshot {
+	lastSnapshotMutex.RLock()
+	defer lastSnapshotMutex.RUnlock()
+	return lastSnapshot
+}
+
+// loadLastCollectionError returns the last error occurred during collections.
+// If there were no errors, nil is returned.
+func loadLastCollectionError() *collectionError {
+	lastSnapshotMutex.RLock()
+	defer lastSnapshotMutex.RUnlock()
+	return lastError
+}
@@ -0,0 +1,54 @@
+package backrest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectionSnapshot(t *testing.T) {
+	storeSnapshot(nil)
+	if got := loadSnapshot(); got != nil {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, nil)
+	}
+	snapshot := newCollectionSnapshot(time.Unix(1623706322, 0))
+	snapshot.addStanza(stanza{Name: "demo"})
+	snapshot.addStanza(stanza{Name: "demo2"})
+	storeSnapshot(snapshot)
+	got := loadSnapshot()
+	if got != snapshot {
+		t.Fatalf("\nVariables do not match:\n%v\nwant:\n%v", got, snapshot)
+	}
+	if _, ok := got.getStanza("demo2"); !ok {
+		t.Errorf("\nStanza %s not found in snapshot", "demo2")
+	}
+	if _, ok := got.getStanza("demo3"); ok {
+		t.Errorf("\nStanza %s found in snapshot", "demo3")
+	}
+	storeSnapshot(nil)
+}
+
+func TestCollectionSnapshotErrors(t *testing.T) {
+	defer resetLastCollectionError()
+	resetLastCollectionError()
+	if got := loadLastCollectionError(); got != nil {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, nil)
+	}
+	snapshot := newCollectionSnapshot(time.Unix(1623706322, 0))
+	snapshot.addError("demo", errors.New("exit status 1"))
+	storeSnapshot(snapshot)
+	// Successful collection doesn't reset the last error.
+	storeSnapshot(newCollectionSnapshot(time.Unix(1623706382, 0)))
+	want := &collectionError{time.Unix(1623706322, 0), "demo", "exit status 1"}
+	if got := loadLastCollectionError(); !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	storeSnapshot(nil)
+}
+
+func resetLastCollectionError() {
+	lastSnapshotMutex.Lock()
+	defer lastSnapshotMutex.Unlock()
+	lastError = nil
+}
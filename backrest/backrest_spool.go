@@ -0,0 +1,337 @@
+package backrest
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Default spool path of pgBackRest.
+// See https://pgbackrest.org/configuration.html#section-general/option-spool-path.
+const defaultSpoolPath = "/var/spool/pgbackrest"
+
+const (
+	spoolArchivePush = "archive-push"
+	spoolArchiveGet  = "archive-get"
+	// Written by async process when it fails for all segments.
+	spoolGlobalError = "global.error"
+)
+
+var (
+	pgbrSpoolQueueSegmentsMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_spool_queue_segments",
+		Help: "Number of WAL segments in spool queue.",
+	},
+		[]string{
+			"command",
+			"stanza"})
+	pgbrSpoolQueueBytesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_spool_queue_bytes",
+		Help: "Total size of WAL segments in spool queue.",
+	},
+		[]string{
+			"command",
+			"stanza"})
+	pgbrSpoolQueueOldestMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_spool_queue_oldest_segment_seconds",
+		Help: "Time since the oldest WAL segment in spool queue was modified.",
+	},
+		[]string{
+			"command",
+			"stanza"})
+	pgbrSpoolStatusFilesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_spool_status_files",
+		Help: "Number of status files of WAL segments in spool.",
+	},
+		[]string{
+			"command",
+			"stanza",
+			"status"})
+	pgbrSpoolGlobalErrorMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_spool_global_error_status",
+		Help: "Async process failed for all WAL segments.",
+	},
+		[]string{
+			"command",
+			"stanza"})
+)
+
+// E.g. "000000010000000000000004" or "000000010000000000000004.partial".
+var spoolSegmentRegexp = regexp.MustCompile(`^[0-9A-F]{24}(\.partial)?$`)
+
+// Directories with WAL archive status files inside pg1-path, for PostgreSQL >= 10 and < 10.
+var archiveStatusDirs = []string{
+	filepath.Join("pg_wal", "archive_status"),
+	filepath.Join("pg_xlog", "archive_status"),
+}
+
+// Spool queue directories of async commands inside spool-path/archive/<stanza>.
+var spoolQueueDirs = map[string]string{
+	spoolArchivePush: "out",
+	spoolArchiveGet:  "in",
+}
+
+// Empty when spool metrics are disabled.
+var spoolPath string
+
+// SetSpoolPath enables collecting metrics for spool of asynchronous archiving.
+// When path is empty, spool-path option for archive-push command is taken from pgBackRest configuration,
+// or the default value is used.
+func SetSpoolPath(path, config, configIncludePath string) (string, error) {
+	if path == "" {
+		cfg, err := loadBackrestConfig(config, configIncludePath)
+		if err != nil {
+			return "", err
+		}
+		value, ok := cfg.get("", spoolArchivePush, "spool-path")
+		if !ok {
+			value = defaultSpoolPath
+		}
+		path = value
+	}
+	spoolPath = path
+	return path, nil
+}
+
+// spoolQueue is the state of spool directory of async command.
+type spoolQueue struct {
+	// False when WAL segments queue can't be read, e.g. pg1-path is absent in pgBackRest configuration.
+	queueAvailable bool
+	segments       int
+	bytes          int64
+	oldestMtime    int64
+	okFiles        int
+	errorFiles     int
+	globalError    bool
+}
+
+// getSpoolMetrics sets metrics for stanzas in spool-path/archive directory.
+// Queue of archive-push is read from pg1-path of stanza in pgBackRest configuration, it's skipped when cfg is nil.
+// Include and exclude lists are applied the same way as for collecting metrics.
+func getSpoolMetrics(path string, cfg *backrestConfig, includeStanzas, excludeStanzas []string, currentUnixTime int64, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	stanzaDirs, err := os.ReadDir(filepath.Join(path, "archive"))
+	if err != nil {
+		// Spool is created by the first async command.
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Debug("Spool directory doesn't exist", "path", path)
+			return
+		}
+		logger.Error("Read spool directory failed", "path", path, "err", err)
+		return
+	}
+	includeSpecified := strings.Join(includeStanzas, "") != ""
+	for _, stanzaDir := range stanzaDirs {
+		stanzaName := stanzaDir.Name()
+		if !stanzaDir.IsDir() || stanzaInExclude(stanzaName, excludeStanzas) || stanzaFilteredByRegexp(stanzaName) ||
+			(includeSpecified && !slices.Contains(includeStanzas, stanzaName)) {
+			continue
+		}
+		for _, command := range []string{spoolArchivePush, spoolArchiveGet} {
+			dir := filepath.Join(path, "archive", stanzaName, spoolQueueDirs[command])
+			queue, err := getSpoolQueue(dir)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				logger.Error("Read spool directory failed", "path", dir, "err", err)
+				continue
+			}
+			if command == spoolArchivePush {
+				queue = getArchivePushQueue(cfg, stanzaName, queue, logger)
+			}
+			setSpoolQueueMetrics(stanzaName, command, queue, currentUnixTime, setUpMetricValueFun, logger)
+		}
+	}
+}
+
+// getSpoolQueue reads spool directory.
+// For archive-get it contains prefetched WAL segments.
+// For archive-push WAL segments are queued in pg_wal, the directory contains status files only.
+func getSpoolQueue(dir string) (spoolQueue, error) {
+	queue := spoolQueue{queueAvailable: true}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return queue, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case name == spoolGlobalError:
+			queue.globalError = true
+		case strings.HasSuffix(name, ".ok"):
+			queue.okFiles++
+		case strings.HasSuffix(name, ".error"):
+			queue.errorFiles++
+		case spoolSegmentRegexp.MatchString(name):
+			info, err := entry.Info()
+			// File can be removed after reading directory.
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return queue, err
+			}
+			queue.segments++
+			queue.bytes += info.Size()
+			mtime := info.ModTime().Unix()
+			if queue.oldestMtime == 0 || mtime < queue.oldestMtime {
+				queue.oldestMtime = mtime
+			}
+		}
+	}
+	return queue, nil
+}
+
+// getArchivePushQueue returns queue of archive-push with WAL segments ready for archiving.
+// PostgreSQL creates <segment>.ready file in archive_status directory when segment is ready for archiving,
+// so the queue is read from pg1-path. The exporter must have read access to it.
+func getArchivePushQueue(cfg *backrestConfig, stanzaName string, queue spoolQueue, logger *slog.Logger) spoolQueue {
+	queue.queueAvailable = false
+	if cfg == nil {
+		return queue
+	}
+	pgPath, ok := cfg.get(stanzaName, spoolArchivePush, "pg1-path")
+	if !ok {
+		logger.Debug("Option pg1-path is not set, archive-push queue is skipped", "stanza", stanzaName)
+		return queue
+	}
+	for _, statusDir := range archiveStatusDirs {
+		readyQueue, err := getArchiveReadyQueue(pgPath, statusDir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			logger.Error("Read WAL archive status directory failed", "path", filepath.Join(pgPath, statusDir), "err", err)
+			return queue
+		}
+		queue.queueAvailable = true
+		queue.segments, queue.bytes, queue.oldestMtime = readyQueue.segments, readyQueue.bytes, readyQueue.oldestMtime
+		return queue
+	}
+	logger.Debug("WAL archive status directory doesn't exist", "path", pgPath)
+	return queue
+}
+
+// getArchiveReadyQueue reads .ready files of WAL segments in archive status directory.
+// Age of queue is taken from .ready file, it's created when segment is ready for archiving.
+// Size is taken from WAL segment in the parent directory.
+func getArchiveReadyQueue(pgPath, statusDir string) (spoolQueue, error) {
+	var queue spoolQueue
+	dir := filepath.Join(pgPath, statusDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return queue, err
+	}
+	for _, entry := range entries {
+		segment, ok := strings.CutSuffix(entry.Name(), ".ready")
+		// History and backup files are archived too, but they aren't WAL segments.
+		if !ok || !spoolSegmentRegexp.MatchString(segment) {
+			continue
+		}
+		info, err := entry.Info()
+		// Status file is renamed to .done after archiving.
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return queue, err
+		}
+		segmentInfo, err := os.Stat(filepath.Join(filepath.Dir(dir), segment))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return queue, err
+		}
+		queue.segments++
+		queue.bytes += segmentInfo.Size()
+		mtime := info.ModTime().Unix()
+		if queue.oldestMtime == 0 || mtime < queue.oldestMtime {
+			queue.oldestMtime = mtime
+		}
+	}
+	return queue, nil
+}
+
+// Set spool metrics:
+//   - pgbackrest_spool_queue_segments
+//   - pgbackrest_spool_queue_bytes
+//   - pgbackrest_spool_queue_oldest_segment_seconds
+//   - pgbackrest_spool_status_files
+//   - pgbackrest_spool_global_error_status
+func setSpoolQueueMetrics(stanzaName, command string, queue spoolQueue, currentUnixTime int64, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	if queue.queueAvailable {
+		setSpoolQueueSizeMetrics(stanzaName, command, queue, currentUnixTime, setUpMetricValueFun, logger)
+	}
+	for status, value := range map[string]int{"ok": queue.okFiles, "error": queue.errorFiles} {
+		setUpMetric(
+			pgbrSpoolStatusFilesMetric,
+			"pgbackrest_spool_status_files",
+			float64(value),
+			setUpMetricValueFun,
+			logger,
+			command,
+			stanzaName,
+			status,
+		)
+	}
+	setUpMetric(
+		pgbrSpoolGlobalErrorMetric,
+		"pgbackrest_spool_global_error_status",
+		convertBoolToFloat64(queue.globalError),
+		setUpMetricValueFun,
+		logger,
+		command,
+		stanzaName,
+	)
+}
+
+// Queue metrics are set only when queue of WAL segments is available.
+func setSpoolQueueSizeMetrics(stanzaName, command string, queue spoolQueue, currentUnixTime int64, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	setUpMetric(
+		pgbrSpoolQueueSegmentsMetric,
+		"pgbackrest_spool_queue_segments",
+		float64(queue.segments),
+		setUpMetricValueFun,
+		logger,
+		command,
+		stanzaName,
+	)
+	setUpMetric(
+		pgbrSpoolQueueBytesMetric,
+		"pgbackrest_spool_queue_bytes",
+		float64(queue.bytes),
+		setUpMetricValueFun,
+		logger,
+		command,
+		stanzaName,
+	)
+	// Age is not set for empty queue.
+	if queue.segments != 0 {
+		setUpMetric(
+			pgbrSpoolQueueOldestMetric,
+			"pgbackrest_spool_queue_oldest_segment_seconds",
+			float64(max(0, currentUnixTime-queue.oldestMtime)),
+			setUpMetricValueFun,
+			logger,
+			command,
+			stanzaName,
+		)
+	}
+}
+
+func resetSpoolMetrics() {
+	pgbrSpoolQueueSegmentsMetric.Reset()
+	pgbrSpoolQueueBytesMetric.Reset()
+	pgbrSpoolQueueOldestMetric.Reset()
+	pgbrSpoolStatusFilesMetric.Reset()
+	pgbrSpoolGlobalErrorMetric.Reset()
+}
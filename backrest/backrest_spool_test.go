@@ -0,0 +1,160 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// writeTemplateSpool creates spool with queues for demo stanza and empty directory for excluded stanza.
+// WAL segments ready for archiving are in pgdata directory.
+func writeTemplateSpool(t *testing.T, mtime time.Time) string {
+	dir := t.TempDir()
+	files := map[string]int{
+		"archive/demo/out/000000010000000000000004.ok":                0,
+		"archive/demo/out/000000010000000000000005.error":             40,
+		"archive/demo/out/global.error":                               40,
+		"archive/demo/in/000000010000000000000006":                    100,
+		"archive/demo/in/000000010000000000000007":                    200,
+		"archive/demo/in/000000010000000000000008.pgbackrest.tmp":     50,
+		"archive/excluded/out/000000010000000000000004.ok":            0,
+		"pgdata/pg_wal/000000010000000000000008":                      400,
+		"pgdata/pg_wal/000000010000000000000009":                      400,
+		"pgdata/pg_wal/00000001000000000000000A":                      400,
+		"pgdata/pg_wal/archive_status/000000010000000000000008.done":  0,
+		"pgdata/pg_wal/archive_status/000000010000000000000009.ready": 0,
+		"pgdata/pg_wal/archive_status/00000001000000000000000A.ready": 0,
+		"pgdata/pg_wal/archive_status/00000002.history.ready":         0,
+	}
+	for name, size := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, make([]byte, size), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newer := mtime.Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "archive/demo/in/000000010000000000000007"), newer, newer); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestGetSpoolMetrics(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_spool_global_error_status Async process failed for all WAL segments.
+# TYPE pgbackrest_spool_global_error_status gauge
+pgbackrest_spool_global_error_status{command="archive-get",stanza="demo"} 0
+pgbackrest_spool_global_error_status{command="archive-push",stanza="demo"} 1
+# HELP pgbackrest_spool_queue_bytes Total size of WAL segments in spool queue.
+# TYPE pgbackrest_spool_queue_bytes gauge
+pgbackrest_spool_queue_bytes{command="archive-get",stanza="demo"} 300
+pgbackrest_spool_queue_bytes{command="archive-push",stanza="demo"} 800
+# HELP pgbackrest_spool_queue_oldest_segment_seconds Time since the oldest WAL segment in spool queue was modified.
+# TYPE pgbackrest_spool_queue_oldest_segment_seconds gauge
+pgbackrest_spool_queue_oldest_segment_seconds{command="archive-get",stanza="demo"} 600
+pgbackrest_spool_queue_oldest_segment_seconds{command="archive-push",stanza="demo"} 600
+# HELP pgbackrest_spool_queue_segments Number of WAL segments in spool queue.
+# TYPE pgbackrest_spool_queue_segments gauge
+pgbackrest_spool_queue_segments{command="archive-get",stanza="demo"} 2
+pgbackrest_spool_queue_segments{command="archive-push",stanza="demo"} 2
+# HELP pgbackrest_spool_status_files Number of status files of WAL segments in spool.
+# TYPE pgbackrest_spool_status_files gauge
+pgbackrest_spool_status_files{command="archive-get",stanza="demo",status="error"} 0
+pgbackrest_spool_status_files{command="archive-get",stanza="demo",status="ok"} 0
+pgbackrest_spool_status_files{command="archive-push",stanza="demo",status="error"} 1
+pgbackrest_spool_status_files{command="archive-push",stanza="demo",status="ok"} 1
+`
+	mtime := time.Unix(1623057000, 0)
+	dir := writeTemplateSpool(t, mtime)
+	configFile, includePath := writeTemplateConfig(t, "[demo]\npg1-path="+filepath.Join(dir, "pgdata")+"\n", "")
+	backrestCfg, err := loadBackrestConfig(configFile, includePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetSpoolMetrics()
+	getSpoolMetrics(dir, backrestCfg, []string{""}, []string{"excluded"}, mtime.Unix()+600, setUpMetricValue, logger)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrSpoolQueueSegmentsMetric,
+		pgbrSpoolQueueBytesMetric,
+		pgbrSpoolQueueOldestMetric,
+		pgbrSpoolStatusFilesMetric,
+		pgbrSpoolGlobalErrorMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
+
+// Without pg1-path queue of archive-push is unknown, status files are kept.
+func TestGetArchivePushQueueUnavailable(t *testing.T) {
+	configFile, includePath := writeTemplateConfig(t, "[demo]\npg1-path=/nonexistent\n", "")
+	backrestCfg, err := loadBackrestConfig(configFile, includePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := spoolQueue{queueAvailable: true, okFiles: 1}
+	for _, tt := range []struct {
+		name   string
+		cfg    *backrestConfig
+		stanza string
+	}{
+		{"noConfig", nil, "demo"},
+		{"noPgPath", backrestCfg, "demo2"},
+		{"noStatusDir", backrestCfg, "demo"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			want := spoolQueue{okFiles: 1}
+			if got := getArchivePushQueue(tt.cfg, tt.stanza, queue, logger); got != want {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetSpoolPath(t *testing.T) {
+	defer func() { spoolPath = "" }()
+	configFile, includePath := writeTemplateConfig(t, "[global]\nspool-path=/tmp/spool\n", "")
+	tests := []struct {
+		name   string
+		path   string
+		config string
+		want   string
+	}{
+		{"flag", "/spool", configFile, "/spool"},
+		{"config", "", configFile, "/tmp/spool"},
+		{"default", "", filepath.Join(includePath, "demo.conf"), defaultSpoolPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SetSpoolPath(tt.path, tt.config, includePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || spoolPath != tt.want {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
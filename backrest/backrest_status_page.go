@@ -0,0 +1,233 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// statusPageCSS is embedded into the landing page.
+const statusPageCSS = `
+table.status { border-collapse: collapse; margin-bottom: 1em; }
+table.status th, table.status td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+table.status td.ok { background: #d9ead3; }
+table.status td.warning { background: #fff2cc; }
+table.status td.error { background: #f4cccc; }
+`
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<h2>Status</h2>
+{{- if not .Collected }}
+<p>No data collected from pgBackRest yet.</p>
+{{- else }}
+<p>Last collection: {{ .CollectedAt }} ({{ .CollectedAgo }} ago).</p>
+{{- range .Errors }}
+<p class="error">Collection error{{ if .Stanza }} for stanza {{ .Stanza }}{{ end }}: {{ .Message }}</p>
+{{- end }}
+<table class="status">
+<tr><th>Stanza</th><th>Status</th><th>Repositories</th><th>Last full</th><th>Last diff</th><th>Last incr</th><th>Backup in progress</th><th>Restore in progress</th></tr>
+{{- range .Stanzas }}
+<tr>
+<td>{{ .Name }}</td>
+<td class="{{ .StatusClass }}">{{ .Status }}</td>
+<td>{{ range .Repos }}<div class="{{ .Class }}">{{ .Text }}</div>{{ else }}-{{ end }}</td>
+<td>{{ .LastFull }}</td>
+<td>{{ .LastDiff }}</td>
+<td>{{ .LastIncr }}</td>
+<td>{{ .Backup }}</td>
+<td>{{ .Restore }}</td>
+</tr>
+{{- else }}
+<tr><td colspan="8">No stanzas found.</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- with .LastError }}
+<p>Last collection error: {{ . }}</p>
+{{- end }}
+`))
+
+// statusPageRepo is a repository status on the status page.
+type statusPageRepo struct {
+	Text  string
+	Class string
+}
+
+// statusPageStanza is a row of stanzas table on the status page.
+type statusPageStanza struct {
+	Name        string
+	Status      string
+	StatusClass string
+	Repos       []statusPageRepo
+	LastFull    string
+	LastDiff    string
+	LastIncr    string
+	Backup      string
+	Restore     string
+}
+
+// statusPageError is a collection error on the status page.
+type statusPageError struct {
+	Stanza  string
+	Message string
+}
+
+// statusPageData is the data for status page template.
+type statusPageData struct {
+	Collected    bool
+	CollectedAt  string
+	CollectedAgo string
+	Errors       []statusPageError
+	Stanzas      []statusPageStanza
+	LastError    string
+}
+
+// landingPageHandler renders the landing page with the status of stanzas
+// for the latest collected data on each request.
+func landingPageHandler(landingConfig web.LandingConfig, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusHTML, err := renderStatusPage(time.Now())
+		if err != nil {
+			logger.Error("Render status page failed", "err", err)
+		}
+		config := landingConfig
+		// Landing page modifies links, so the copy is used for each request.
+		config.Links = slices.Clone(landingConfig.Links)
+		config.ExtraHTML = statusHTML
+		config.ExtraCSS = statusPageCSS
+		landingPage, err := web.NewLandingPage(config)
+		if err != nil {
+			logger.Error("Error creating landing page", "err", err)
+			http.Error(w, "Error creating landing page", http.StatusInternalServerError)
+			return
+		}
+		landingPage.ServeHTTP(w, r)
+	})
+}
+
+// renderStatusPage returns HTML with the status of stanzas from the latest collected data.
+func renderStatusPage(now time.Time) (string, error) {
+	data := getStatusPageData(loadSnapshot(), loadLastCollectionError(), now)
+	var buf bytes.Buffer
+	if err := statusPageTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func getStatusPageData(snapshot *collectionSnapshot, lastErr *collectionError, now time.Time) statusPageData {
+	var data statusPageData
+	if lastErr != nil {
+		data.LastError = lastErr.time.Format(layout) + " " + lastErr.message
+		if lastErr.stanza != "" {
+			data.LastError += " (stanza " + lastErr.stanza + ")"
+		}
+	}
+	if snapshot == nil {
+		return data
+	}
+	data.Collected = true
+	data.CollectedAt = snapshot.collectedAt.Format(layout)
+	data.CollectedAgo = formatAge(now.Sub(snapshot.collectedAt))
+	for _, collectErr := range snapshot.errors {
+		data.Errors = append(data.Errors, statusPageError{collectErr.stanza, collectErr.message})
+	}
+	for _, stanzaData := range snapshot.stanzas {
+		data.Stanzas = append(data.Stanzas, getStatusPageStanza(stanzaData, now))
+	}
+	return data
+}
+
+func getStatusPageStanza(stanzaData stanza, now time.Time) statusPageStanza {
+	row := statusPageStanza{
+		Name:        stanzaData.Name,
+		Status:      fmt.Sprintf("%d (%s)", stanzaData.Status.Code, stanzaData.Status.Message),
+		StatusClass: statusPageClass(stanzaData.Status.Code),
+		LastFull:    "-",
+		LastDiff:    "-",
+		LastIncr:    "-",
+		Backup:      formatStatusPageProgress(stanzaData.Status.Lock.Backup.Held, stanzaData.Status.Lock.Backup.SizeComplete, stanzaData.Status.Lock.Backup.SizeTotal),
+		Restore:     formatStatusPageProgress(stanzaData.Status.Lock.Restore.Held, stanzaData.Status.Lock.Restore.SizeComplete, stanzaData.Status.Lock.Restore.SizeTotal),
+	}
+	// For pgBackRest < v2.32 repo info is not available.
+	if stanzaData.Repo != nil {
+		for _, repo := range *stanzaData.Repo {
+			row.Repos = append(row.Repos, statusPageRepo{
+				Text:  fmt.Sprintf("repo%d: %d (%s)", repo.Key, repo.Status.Code, repo.Status.Message),
+				Class: statusPageClass(repo.Status.Code),
+			})
+		}
+	}
+	lastBackups := initLastBackupStruct()
+	for _, backupData := range stanzaData.Backup {
+		compareLastBackups(&lastBackups, backupData, backupData.checkBackupIncremental())
+	}
+	// The same logic as for pgbackrest_backup_since_last_completion_seconds metric.
+	if !lastBackups.full.backupTime.IsZero() {
+		row.LastFull = formatStatusPageBackup(lastBackups.full, now)
+		row.LastDiff = formatStatusPageBackup(lastBackups.diff, now)
+		row.LastIncr = formatStatusPageBackup(lastBackups.incr, now)
+	}
+	return row
+}
+
+// statusPageClass returns CSS class for stanza or repo status code.
+func statusPageClass(code int) string {
+	switch code {
+	case 0:
+		return "ok"
+	// Stanza has no valid backups yet, e.g. right after stanza-create.
+	case 2:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+func formatStatusPageBackup(backupData backupStruct, now time.Time) string {
+	return fmt.Sprintf("%s ago (%s)", formatAge(now.Sub(backupData.backupTime)), backupData.backupLabel)
+}
+
+// formatStatusPageProgress returns the progress of backup or restore.
+// Information about size of backup or restore in progress is available since pgBackRest v2.48 and v2.56.0.
+func formatStatusPageProgress(held bool, sizeComplete, sizeTotal *int64) string {
+	if !held {
+		return "-"
+	}
+	if sizeComplete == nil || sizeTotal == nil || *sizeTotal == 0 {
+		return "lock held"
+	}
+	return fmt.Sprintf(
+		"%s%% (%d of %d bytes)",
+		strconv.FormatFloat(float64(*sizeComplete)*100/float64(*sizeTotal), 'f', 1, 64),
+		*sizeComplete,
+		*sizeTotal,
+	)
+}
+
+// formatAge returns duration in short human-readable format, e.g. 2d 3h.
+func formatAge(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	days := int64(d / (24 * time.Hour))
+	hours := int64(d/time.Hour) % 24
+	minutes := int64(d/time.Minute) % 60
+	seconds := int64(d/time.Second) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, seconds)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
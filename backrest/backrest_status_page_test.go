@@ -0,0 +1,158 @@
+package backrest
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+func TestLandingPageHandler(t *testing.T) {
+	storeSnapshot(templateStatusPageSnapshot())
+	defer storeSnapshot(nil)
+	defer resetLastCollectionError()
+	landingConfig := web.LandingConfig{
+		Name:  "pgBackRest exporter",
+		Links: []web.LandingLinks{{Address: "/metrics", Text: "Metrics"}},
+	}
+	handler := landingPageHandler(landingConfig, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	// Links are modified by landing page, so the page is requested twice.
+	for range 2 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("\nVariables do not match:\n%v\nwant:\n%v", rec.Code, http.StatusOK)
+		}
+		for _, want := range []string{
+			`<a href="/metrics">Metrics</a>`,
+			`table.status td.ok`,
+			`<td>demo</td>`,
+		} {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("\nVariable do not match:\n%s\nwant:\n%s", rec.Body.String(), want)
+			}
+		}
+	}
+}
+
+func TestRenderStatusPage(t *testing.T) {
+	defer resetLastCollectionError()
+	collectedAt := time.Unix(1623706322, 0)
+	tests := []struct {
+		name       string
+		snapshot   *collectionSnapshot
+		wantString []string
+	}{
+		{
+			"statusPageNoData",
+			nil,
+			[]string{`<p>No data collected from pgBackRest yet.</p>`},
+		},
+		{
+			"statusPage",
+			templateStatusPageSnapshot(),
+			[]string{
+				`<p>Last collection: ` + collectedAt.Format(layout) + ` (1h 0m ago).</p>`,
+				`<p class="error">Collection error for stanza demo2: exit status 1</p>`,
+				`<td>demo</td>`,
+				`<td class="warning">2 (no valid backups)</td>`,
+				`<td><div class="ok">repo1: 0 (ok)</div></td>`,
+				`<td>7d 13h ago (20210607-092423F)</td>`,
+				`<td>50.0% (6 of 12 bytes)</td>`,
+				`<td>-</td>`,
+				`<p>Last collection error: ` + collectedAt.Format(layout) + ` exit status 1 (stanza demo2)</p>`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeSnapshot(tt.snapshot)
+			defer storeSnapshot(nil)
+			got, err := renderStatusPage(collectedAt.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("\nRender status page failed: %v", err)
+			}
+			for _, want := range tt.wantString {
+				if !strings.Contains(got, want) {
+					t.Errorf("\nVariable do not match:\n%s\nwant:\n%s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatStatusPageProgress(t *testing.T) {
+	var (
+		sizeComplete int64 = 1
+		sizeTotal    int64 = 3
+		sizeZero     int64
+	)
+	tests := []struct {
+		name         string
+		held         bool
+		sizeComplete *int64
+		sizeTotal    *int64
+		want         string
+	}{
+		{"notHeld", false, &sizeComplete, &sizeTotal, "-"},
+		{"sizeAbsent", true, nil, nil, "lock held"},
+		{"sizeZero", true, &sizeZero, &sizeZero, "lock held"},
+		{"progress", true, &sizeComplete, &sizeTotal, "33.3% (1 of 3 bytes)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatStatusPageProgress(tt.held, tt.sizeComplete, tt.sizeTotal); got != tt.want {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Second, "0s"},
+		{42 * time.Second, "42s"},
+		{3*time.Minute + 5*time.Second, "3m 5s"},
+		{2*time.Hour + 30*time.Minute, "2h 30m"},
+		{50 * time.Hour, "2d 2h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatAge(tt.d); got != tt.want {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func templateStatusPageSnapshot() *collectionSnapshot {
+	snapshot := newCollectionSnapshot(time.Unix(1623706322, 0))
+	stanzaData := templateStanza(
+		"000000010000000000000004",
+		"000000010000000000000001",
+		[]databaseRef{{"postgres", 13425}},
+		false,
+		true,
+		false,
+		12,
+		100,
+		12,
+		6,
+		0,
+		0,
+		annotation{"testkey": "testvalue"})
+	stanzaData.Status.Code = 2
+	stanzaData.Status.Message = "no valid backups"
+	snapshot.addStanza(stanzaData)
+	snapshot.addError("demo2", errors.New("exit status 1"))
+	return snapshot
+}
@@ -202,3 +202,22 @@ type status struct {
 	} `json:"lock"`
 	Message string `json:"message"`
 }
+
+// Output of repo-ls command.
+//
+//	{
+//	  "name": {
+//	      "type": "string",
+//	      "size": number,
+//	      "time": number,
+//	      "destination": "string"
+//	  }
+//	}
+//
+// Name "." is the listed path itself.
+type repoLsEntry struct {
+	Type        string `json:"type"`
+	Size        *int64 `json:"size"`
+	Time        *int64 `json:"time"`
+	Destination string `json:"destination"`
+}
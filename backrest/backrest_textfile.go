@@ -0,0 +1,17 @@
+package backrest
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WriteTextfile writes pgBackRest metrics from the default registry to file
+// in Prometheus text format for node_exporter textfile collector.
+// The file is written atomically: data is written to a temporary file
+// in the same directory, which is then renamed.
+func WriteTextfile(filename string) error {
+	return writeTextfile(filename, prometheus.DefaultGatherer)
+}
+
+func writeTextfile(filename string, gatherer prometheus.Gatherer) error {
+	return prometheus.WriteToTextfile(filename, exporterMetricsGatherer(gatherer))
+}
@@ -0,0 +1,62 @@
+package backrest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	// Metric with the same name is exposed by node_exporter.
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "go_goroutines",
+		Help: "Number of goroutines that currently exist.",
+	}))
+	metric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_exporter_status",
+		Help: "pgBackRest exporter get data status.",
+	}, []string{"stanza"})
+	registry.MustRegister(metric)
+	metric.WithLabelValues("demo").Set(1)
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "pgbackrest.prom")
+	if err := writeTextfile(filename, registry); err != nil {
+		t.Fatalf("\nWrite textfile failed: %v", err)
+	}
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("\nRead textfile failed: %v", err)
+	}
+	want := `# HELP pgbackrest_exporter_status pgBackRest exporter get data status.
+# TYPE pgbackrest_exporter_status gauge
+pgbackrest_exporter_status{stanza="demo"} 1
+`
+	if string(got) != want {
+		t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", got, want)
+	}
+	// Temporary file must be renamed.
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("\nRead dir failed: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("\nVariables do not match:\n%d\nwant:\n%d", len(files), 1)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("\nStat textfile failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", info.Mode().Perm(), os.FileMode(0o644))
+	}
+}
+
+func TestWriteTextfileError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "not-exists", "pgbackrest.prom")
+	if err := writeTextfile(filename, prometheus.NewRegistry()); err == nil {
+		t.Errorf("\nExpected error for file in non-existent dir")
+	}
+}
@@ -0,0 +1,483 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons of invalid files in verify results.
+const (
+	verifyMissing         = "missing"
+	verifyChecksumInvalid = "checksum_invalid"
+	verifySizeInvalid     = "size_invalid"
+	verifyOther           = "other"
+)
+
+// Interval of checking if verify should be run.
+var verifyPollInterval = time.Minute
+
+var (
+	pgbrVerifyStatusMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_status",
+		Help: "Result of pgbackrest verify command.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrVerifyDurationMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_duration_seconds",
+		Help: "Duration of pgbackrest verify command.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrVerifyLastRunMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_last_run_timestamp_seconds",
+		Help: "Time when pgbackrest verify command was completed.",
+	},
+		[]string{
+			"repo_key",
+			"stanza"})
+	pgbrVerifyBackupStatusMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_backup_status",
+		Help: "Backup status reported by pgbackrest verify command.",
+	},
+		[]string{
+			"backup_name",
+			"repo_key",
+			"stanza"})
+	pgbrVerifyBackupFilesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_backup_files",
+		Help: "Number of backup files checked by pgbackrest verify command.",
+	},
+		[]string{
+			"backup_name",
+			"repo_key",
+			"stanza"})
+	pgbrVerifyBackupInvalidFilesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_backup_invalid_files",
+		Help: "Number of invalid backup files found by pgbackrest verify command.",
+	},
+		[]string{
+			"backup_name",
+			"reason",
+			"repo_key",
+			"stanza"})
+	pgbrVerifyWALFilesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_wal_files",
+		Help: "Number of WAL files checked by pgbackrest verify command.",
+	},
+		[]string{
+			"archive_id",
+			"repo_key",
+			"stanza"})
+	pgbrVerifyWALInvalidFilesMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_wal_invalid_files",
+		Help: "Number of invalid WAL files found by pgbackrest verify command.",
+	},
+		[]string{
+			"archive_id",
+			"reason",
+			"repo_key",
+			"stanza"})
+	pgbrVerifyWALGapsMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgbackrest_verify_wal_gaps",
+		Help: "Number of gaps between continuous WAL ranges found by pgbackrest verify command.",
+	},
+		[]string{
+			"archive_id",
+			"repo_key",
+			"stanza"})
+)
+
+var (
+	// E.g. "status: error".
+	verifyStatusRegexp = regexp.MustCompile(`^\s*status: (\S+)`)
+	// E.g. "  archiveId: 13-1, total WAL checked: 4, total valid WAL: 3".
+	verifyArchiveRegexp = regexp.MustCompile(`archiveId: (\S+), total WAL checked: (\d+), total valid WAL: (\d+)`)
+	// E.g. "P00 DETAIL: archiveId: 13-1, wal start: 000000010000000000000001, wal stop: 000000010000000000000004".
+	verifyWALRangeRegexp = regexp.MustCompile(`archiveId: (\S+), wal start: \S+, wal stop: \S+`)
+	// E.g. "  backup: 20210607-092423F, status: invalid, total files checked: 986, total valid files: 985".
+	verifyBackupRegexp = regexp.MustCompile(`backup: (\S+), status: (\S+), total files checked: (\d+), total valid files: (\d+)`)
+	// E.g. "    missing: 0, checksum invalid: 1, size invalid: 0, other: 0".
+	verifyInvalidRegexp = regexp.MustCompile(`missing: (\d+), checksum invalid: (\d+), size invalid: (\d+), other: (\d+)`)
+)
+
+// VerifyProbeConfig contains parameters for running pgbackrest verify command periodically.
+type VerifyProbeConfig struct {
+	// Config is the full path to pgBackRest configuration file.
+	Config string
+	// ConfigIncludePath is the full path to additional pgBackRest configuration files.
+	ConfigIncludePath string
+	// IncludeStanza and ExcludeStanza are the same as for collecting metrics.
+	IncludeStanza []string
+	ExcludeStanza []string
+	// Interval is the minimum interval between runs, disabled when 0.
+	Interval time.Duration
+	// Window is the time of day when verify can be started, e.g. 01:00-05:00.
+	// When empty, verify can be started at any time.
+	Window string
+}
+
+// verifyWindow is the time of day in minutes since midnight.
+// When start is greater than end, the window includes midnight.
+type verifyWindow struct {
+	start, end int
+}
+
+// verifyInvalidFiles is the number of invalid files by reason.
+type verifyInvalidFiles struct {
+	missing, checksumInvalid, sizeInvalid, other int
+}
+
+type verifyArchiveResult struct {
+	archiveID    string
+	checked      int
+	valid        int
+	invalidFiles verifyInvalidFiles
+	ranges       int
+}
+
+type verifyBackupResult struct {
+	label        string
+	status       string
+	checked      int
+	valid        int
+	invalidFiles verifyInvalidFiles
+}
+
+// verifyResult is the result of pgbackrest verify command for stanza repository.
+type verifyResult struct {
+	ok       bool
+	duration time.Duration
+	time     time.Time
+	archives []verifyArchiveResult
+	backups  []verifyBackupResult
+}
+
+// verifyResultKey identifies repository of stanza.
+type verifyResultKey struct {
+	stanza  string
+	repoKey int
+}
+
+// verifyProbe schedules pgbackrest verify command.
+type verifyProbe struct {
+	window  *verifyWindow
+	lastRun time.Time
+}
+
+// StartVerifyProbe runs pgbackrest verify command for each repository of stanzas in the background.
+// Verify reads all files in repository, so it's run only in the configured window.
+// Results are kept until the next run.
+func StartVerifyProbe(cfg VerifyProbeConfig, logger *slog.Logger) error {
+	if cfg.Interval <= 0 {
+		return nil
+	}
+	window, err := parseVerifyWindow(cfg.Window)
+	if err != nil {
+		return err
+	}
+	logger.Info("Running pgbackrest verify periodically", "interval", cfg.Interval, "window", cfg.Window)
+	probe := &verifyProbe{window: window}
+	go func() {
+		for {
+			if probe.due(cfg.Interval, time.Now()) {
+				probe.run(cfg, setUpMetricValue, logger)
+			}
+			time.Sleep(verifyPollInterval)
+		}
+	}()
+	return nil
+}
+
+// parseVerifyWindow parses window in HH:MM-HH:MM format.
+// Empty window means any time.
+func parseVerifyWindow(value string) (*verifyWindow, error) {
+	if value == "" {
+		return nil, nil
+	}
+	start, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid verify window %q, format HH:MM-HH:MM is expected", value)
+	}
+	window := &verifyWindow{}
+	for _, item := range []struct {
+		value  string
+		result *int
+	}{
+		{start, &window.start},
+		{end, &window.end},
+	} {
+		t, err := time.Parse("15:04", strings.TrimSpace(item.value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid verify window %q: %w", value, err)
+		}
+		*item.result = t.Hour()*60 + t.Minute()
+	}
+	if window.start == window.end {
+		return nil, fmt.Errorf("invalid verify window %q, start and end are equal", value)
+	}
+	return window, nil
+}
+
+// contains returns true if the time of day is in the window.
+func (window *verifyWindow) contains(now time.Time) bool {
+	if window == nil {
+		return true
+	}
+	minutes := now.Hour()*60 + now.Minute()
+	if window.start < window.end {
+		return minutes >= window.start && minutes < window.end
+	}
+	return minutes >= window.start || minutes < window.end
+}
+
+// due returns true if verify should be started now.
+func (probe *verifyProbe) due(interval time.Duration, now time.Time) bool {
+	return probe.window.contains(now) && (probe.lastRun.IsZero() || now.Sub(probe.lastRun) >= interval)
+}
+
+// run verifies each repository of stanzas and sets metrics.
+// Metrics for stanzas that are not returned by pgBackRest anymore are removed.
+// When data can't be received from pgBackRest, previous metrics are kept.
+func (probe *verifyProbe) run(cfg VerifyProbeConfig, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	probe.lastRun = time.Now()
+	stanzas, err := getProbeStanzas(cfg.Config, cfg.ConfigIncludePath, cfg.IncludeStanza, cfg.ExcludeStanza, logger)
+	if err != nil {
+		return
+	}
+	results := make(map[verifyResultKey]verifyResult)
+	for _, singleStanza := range stanzas {
+		for _, repoKey := range getStanzaRepoKeys(singleStanza) {
+			results[verifyResultKey{singleStanza.Name, repoKey}] = runVerifyCommand(cfg.Config, cfg.ConfigIncludePath, singleStanza.Name, repoKey, logger)
+		}
+	}
+	resetVerifyProbeMetrics()
+	for key, result := range results {
+		getVerifyProbeMetrics(key.stanza, key.repoKey, result, setUpMetricValueFun, logger)
+	}
+}
+
+func returnVerifyExecArgs(stanza string, repoKey int) []string {
+	// Ranges of WAL are logged with detail level.
+	return []string{
+		"verify",
+		"--stanza", stanza,
+		"--repo", strconv.Itoa(repoKey),
+		"--output", "text",
+		"--verbose",
+		"--log-level-console", "detail",
+	}
+}
+
+// runVerifyCommand runs pgbackrest verify command for stanza repository.
+// pgBackRest exits with error when invalid files are found, but output contains results anyway.
+func runVerifyCommand(config, configIncludePath, stanzaName string, repoKey int, logger *slog.Logger) verifyResult {
+	args := [][]string{
+		returnVerifyExecArgs(stanzaName, repoKey),
+		returnConfigExecArgs(config, configIncludePath),
+	}
+	cmd := execCommand(appName, concatExecArgs(args)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	err := cmd.Run()
+	result := parseVerifyOutput(stdout.String())
+	result.duration = time.Since(start)
+	result.time = time.Now()
+	if err != nil {
+		result.ok = false
+		logger.Error(
+			"pgbackrest verify failed",
+			"stanza", stanzaName,
+			"repo_key", repoKey,
+			"err", err,
+			"stderr", strings.TrimSpace(stderr.String()),
+		)
+	}
+	return result
+}
+
+// parseVerifyOutput parses results of verify command in text format.
+// Lines with the number of invalid files by reason follow archive or backup line,
+// they are absent when all files are valid.
+func parseVerifyOutput(output string) verifyResult {
+	result := verifyResult{ok: true}
+	ranges := make(map[string]int)
+	var invalidFiles *verifyInvalidFiles
+	for line := range strings.Lines(output) {
+		switch {
+		case verifyWALRangeRegexp.MatchString(line):
+			ranges[verifyWALRangeRegexp.FindStringSubmatch(line)[1]]++
+		case verifyArchiveRegexp.MatchString(line):
+			match := verifyArchiveRegexp.FindStringSubmatch(line)
+			checked, _ := strconv.Atoi(match[2])
+			valid, _ := strconv.Atoi(match[3])
+			result.archives = append(result.archives, verifyArchiveResult{archiveID: match[1], checked: checked, valid: valid})
+			invalidFiles = &result.archives[len(result.archives)-1].invalidFiles
+		case verifyBackupRegexp.MatchString(line):
+			match := verifyBackupRegexp.FindStringSubmatch(line)
+			checked, _ := strconv.Atoi(match[3])
+			valid, _ := strconv.Atoi(match[4])
+			result.backups = append(result.backups, verifyBackupResult{label: match[1], status: match[2], checked: checked, valid: valid})
+			invalidFiles = &result.backups[len(result.backups)-1].invalidFiles
+		case verifyInvalidRegexp.MatchString(line):
+			if invalidFiles == nil {
+				continue
+			}
+			match := verifyInvalidRegexp.FindStringSubmatch(line)
+			invalidFiles.missing, _ = strconv.Atoi(match[1])
+			invalidFiles.checksumInvalid, _ = strconv.Atoi(match[2])
+			invalidFiles.sizeInvalid, _ = strconv.Atoi(match[3])
+			invalidFiles.other, _ = strconv.Atoi(match[4])
+		case verifyStatusRegexp.MatchString(line):
+			result.ok = verifyStatusRegexp.FindStringSubmatch(line)[1] == "ok"
+		}
+	}
+	for i := range result.archives {
+		result.archives[i].ranges = ranges[result.archives[i].archiveID]
+	}
+	return result
+}
+
+// Set verify metrics:
+//   - pgbackrest_verify_status
+//   - pgbackrest_verify_duration_seconds
+//   - pgbackrest_verify_last_run_timestamp_seconds
+//   - pgbackrest_verify_backup_status
+//   - pgbackrest_verify_backup_files
+//   - pgbackrest_verify_backup_invalid_files
+//   - pgbackrest_verify_wal_files
+//   - pgbackrest_verify_wal_invalid_files
+//   - pgbackrest_verify_wal_gaps
+func getVerifyProbeMetrics(stanzaName string, repoKey int, result verifyResult, setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+	repoKeyLabel := strconv.Itoa(repoKey)
+	setUpMetric(
+		pgbrVerifyStatusMetric,
+		"pgbackrest_verify_status",
+		convertBoolToFloat64(result.ok),
+		setUpMetricValueFun,
+		logger,
+		repoKeyLabel,
+		stanzaName,
+	)
+	setUpMetric(
+		pgbrVerifyDurationMetric,
+		"pgbackrest_verify_duration_seconds",
+		result.duration.Seconds(),
+		setUpMetricValueFun,
+		logger,
+		repoKeyLabel,
+		stanzaName,
+	)
+	setUpMetric(
+		pgbrVerifyLastRunMetric,
+		"pgbackrest_verify_last_run_timestamp_seconds",
+		float64(result.time.Unix()),
+		setUpMetricValueFun,
+		logger,
+		repoKeyLabel,
+		stanzaName,
+	)
+	for _, backup := range result.backups {
+		setUpMetric(
+			pgbrVerifyBackupStatusMetric,
+			"pgbackrest_verify_backup_status",
+			convertBoolToFloat64(backup.status == "valid"),
+			setUpMetricValueFun,
+			logger,
+			backup.label,
+			repoKeyLabel,
+			stanzaName,
+		)
+		setUpMetric(
+			pgbrVerifyBackupFilesMetric,
+			"pgbackrest_verify_backup_files",
+			float64(backup.checked),
+			setUpMetricValueFun,
+			logger,
+			backup.label,
+			repoKeyLabel,
+			stanzaName,
+		)
+		for reason, value := range backup.invalidFiles.byReason() {
+			setUpMetric(
+				pgbrVerifyBackupInvalidFilesMetric,
+				"pgbackrest_verify_backup_invalid_files",
+				float64(value),
+				setUpMetricValueFun,
+				logger,
+				backup.label,
+				reason,
+				repoKeyLabel,
+				stanzaName,
+			)
+		}
+	}
+	for _, archive := range result.archives {
+		setUpMetric(
+			pgbrVerifyWALFilesMetric,
+			"pgbackrest_verify_wal_files",
+			float64(archive.checked),
+			setUpMetricValueFun,
+			logger,
+			archive.archiveID,
+			repoKeyLabel,
+			stanzaName,
+		)
+		for reason, value := range archive.invalidFiles.byReason() {
+			setUpMetric(
+				pgbrVerifyWALInvalidFilesMetric,
+				"pgbackrest_verify_wal_invalid_files",
+				float64(value),
+				setUpMetricValueFun,
+				logger,
+				archive.archiveID,
+				reason,
+				repoKeyLabel,
+				stanzaName,
+			)
+		}
+		setUpMetric(
+			pgbrVerifyWALGapsMetric,
+			"pgbackrest_verify_wal_gaps",
+			float64(max(0, archive.ranges-1)),
+			setUpMetricValueFun,
+			logger,
+			archive.archiveID,
+			repoKeyLabel,
+			stanzaName,
+		)
+	}
+}
+
+func (files verifyInvalidFiles) byReason() map[string]int {
+	return map[string]int{
+		verifyMissing:         files.missing,
+		verifyChecksumInvalid: files.checksumInvalid,
+		verifySizeInvalid:     files.sizeInvalid,
+		verifyOther:           files.other,
+	}
+}
+
+func resetVerifyProbeMetrics() {
+	pgbrVerifyStatusMetric.Reset()
+	pgbrVerifyDurationMetric.Reset()
+	pgbrVerifyLastRunMetric.Reset()
+	pgbrVerifyBackupStatusMetric.Reset()
+	pgbrVerifyBackupFilesMetric.Reset()
+	pgbrVerifyBackupInvalidFilesMetric.Reset()
+	pgbrVerifyWALFilesMetric.Reset()
+	pgbrVerifyWALInvalidFilesMetric.Reset()
+	pgbrVerifyWALGapsMetric.Reset()
+}
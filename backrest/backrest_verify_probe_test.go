@@ -0,0 +1,222 @@
+package backrest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var mockDataVerify = mockStruct{}
+
+const templateVerifyOutput = `P00 DETAIL: archiveId: 13-1, wal start: 000000010000000000000001, wal stop: 000000010000000000000004
+P00 DETAIL: archiveId: 13-1, wal start: 000000010000000000000007, wal stop: 000000010000000000000008
+stanza: demo
+status: error
+  archiveId: 13-1, total WAL checked: 6, total valid WAL: 5
+    missing: 0, checksum invalid: 1, size invalid: 0, other: 0
+  backup: 20210607-092423F, status: invalid, total files checked: 986, total valid files: 984
+    missing: 1, checksum invalid: 0, size invalid: 1, other: 0
+  backup: 20210607-092423F_20210607-092500I, status: valid, total files checked: 12, total valid files: 12
+`
+
+// fakeExecCommandVerify returns mockDataVerify for verify command and mockData for other commands.
+func fakeExecCommandVerify(command string, args ...string) *exec.Cmd {
+	cs := make([]string, 0, 3+len(args))
+	cs = append(cs, "-test.run=TestExecCommandHelper", "--", command)
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	data := mockData
+	if slices.Contains(args, "verify") {
+		data = mockDataVerify
+	}
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1",
+		"STDOUT=" + data.mockStdout,
+		"STDERR=" + data.mockStderr,
+		"EXIT_STATUS=" + strconv.Itoa(data.mockExit)}
+	return cmd
+}
+
+func TestReturnVerifyExecArgs(t *testing.T) {
+	got := returnVerifyExecArgs("demo", 2)
+	want := []string{"verify", "--stanza", "demo", "--repo", "2", "--output", "text", "--verbose", "--log-level-console", "detail"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestParseVerifyWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    *verifyWindow
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"day", "01:00-05:30", &verifyWindow{60, 330}, false},
+		{"midnight", "23:00-02:00", &verifyWindow{1380, 120}, false},
+		{"noSeparator", "01:00", nil, true},
+		{"badTime", "01:00-25:00", nil, true},
+		{"equal", "01:00-01:00", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVerifyWindow(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nparseVerifyWindow() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyProbeDue(t *testing.T) {
+	day := time.Date(2021, 6, 7, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		window  *verifyWindow
+		lastRun time.Time
+		now     time.Time
+		want    bool
+	}{
+		{"firstRunAnyTime", nil, time.Time{}, day.Add(12 * time.Hour), true},
+		{"intervalNotPassed", nil, day, day.Add(12 * time.Hour), false},
+		{"intervalPassed", nil, day, day.Add(24 * time.Hour), true},
+		{"inWindow", &verifyWindow{60, 300}, time.Time{}, day.Add(2 * time.Hour), true},
+		{"windowEnd", &verifyWindow{60, 300}, time.Time{}, day.Add(5 * time.Hour), false},
+		{"inMidnightWindow", &verifyWindow{1380, 120}, time.Time{}, day.Add(time.Hour), true},
+		{"outMidnightWindow", &verifyWindow{1380, 120}, time.Time{}, day.Add(12 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := &verifyProbe{window: tt.window, lastRun: tt.lastRun}
+			if got := probe.due(24*time.Hour, tt.now); got != tt.want {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVerifyOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   verifyResult
+	}{
+		{
+			"error",
+			templateVerifyOutput,
+			verifyResult{
+				ok: false,
+				archives: []verifyArchiveResult{
+					{"13-1", 6, 5, verifyInvalidFiles{0, 1, 0, 0}, 2},
+				},
+				backups: []verifyBackupResult{
+					{"20210607-092423F", "invalid", 986, 984, verifyInvalidFiles{1, 0, 1, 0}},
+					{"20210607-092423F_20210607-092500I", "valid", 12, 12, verifyInvalidFiles{}},
+				},
+			},
+		},
+		{
+			"ok",
+			"stanza: demo\nstatus: ok\n  archiveId: 13-1, total WAL checked: 4, total valid WAL: 4\n",
+			verifyResult{
+				ok:       true,
+				archives: []verifyArchiveResult{{"13-1", 4, 4, verifyInvalidFiles{}, 0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseVerifyOutput(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nVariables do not match:\n%+v\nwant:\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyProbeRun(t *testing.T) {
+	templateMetrics := `# HELP pgbackrest_verify_backup_files Number of backup files checked by pgbackrest verify command.
+# TYPE pgbackrest_verify_backup_files gauge
+pgbackrest_verify_backup_files{backup_name="20210607-092423F",repo_key="1",stanza="demo"} 986
+pgbackrest_verify_backup_files{backup_name="20210607-092423F_20210607-092500I",repo_key="1",stanza="demo"} 12
+# HELP pgbackrest_verify_backup_invalid_files Number of invalid backup files found by pgbackrest verify command.
+# TYPE pgbackrest_verify_backup_invalid_files gauge
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F",reason="checksum_invalid",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F",reason="missing",repo_key="1",stanza="demo"} 1
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F",reason="other",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F",reason="size_invalid",repo_key="1",stanza="demo"} 1
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F_20210607-092500I",reason="checksum_invalid",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F_20210607-092500I",reason="missing",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F_20210607-092500I",reason="other",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_backup_invalid_files{backup_name="20210607-092423F_20210607-092500I",reason="size_invalid",repo_key="1",stanza="demo"} 0
+# HELP pgbackrest_verify_backup_status Backup status reported by pgbackrest verify command.
+# TYPE pgbackrest_verify_backup_status gauge
+pgbackrest_verify_backup_status{backup_name="20210607-092423F",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_backup_status{backup_name="20210607-092423F_20210607-092500I",repo_key="1",stanza="demo"} 1
+# HELP pgbackrest_verify_status Result of pgbackrest verify command.
+# TYPE pgbackrest_verify_status gauge
+pgbackrest_verify_status{repo_key="1",stanza="demo"} 0
+# HELP pgbackrest_verify_wal_files Number of WAL files checked by pgbackrest verify command.
+# TYPE pgbackrest_verify_wal_files gauge
+pgbackrest_verify_wal_files{archive_id="13-1",repo_key="1",stanza="demo"} 6
+# HELP pgbackrest_verify_wal_gaps Number of gaps between continuous WAL ranges found by pgbackrest verify command.
+# TYPE pgbackrest_verify_wal_gaps gauge
+pgbackrest_verify_wal_gaps{archive_id="13-1",repo_key="1",stanza="demo"} 1
+# HELP pgbackrest_verify_wal_invalid_files Number of invalid WAL files found by pgbackrest verify command.
+# TYPE pgbackrest_verify_wal_invalid_files gauge
+pgbackrest_verify_wal_invalid_files{archive_id="13-1",reason="checksum_invalid",repo_key="1",stanza="demo"} 1
+pgbackrest_verify_wal_invalid_files{archive_id="13-1",reason="missing",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_wal_invalid_files{archive_id="13-1",reason="other",repo_key="1",stanza="demo"} 0
+pgbackrest_verify_wal_invalid_files{archive_id="13-1",reason="size_invalid",repo_key="1",stanza="demo"} 0
+`
+	mockData = mockStruct{
+		`[{"name":"demo","status":{"code":0,"lock":{"backup":{"held":false}},"message":"ok"},` +
+			`"repo":[{"key":1,"status":{"code":0,"message":"ok"}}]}]`,
+		"",
+		0,
+	}
+	mockDataVerify = mockStruct{templateVerifyOutput, "", 1}
+	execCommand = fakeExecCommandVerify
+	defer func() { execCommand = exec.Command }()
+	probe := &verifyProbe{}
+	probe.run(VerifyProbeConfig{IncludeStanza: []string{""}}, setUpMetricValue, logger)
+	if probe.lastRun.IsZero() {
+		t.Errorf("\nVariables do not match, last run is not set")
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		pgbrVerifyStatusMetric,
+		pgbrVerifyBackupStatusMetric,
+		pgbrVerifyBackupFilesMetric,
+		pgbrVerifyBackupInvalidFilesMetric,
+		pgbrVerifyWALFilesMetric,
+		pgbrVerifyWALInvalidFilesMetric,
+		pgbrVerifyWALGapsMetric,
+	)
+	metricFamily, err := reg.Gather()
+	if err != nil {
+		fmt.Println(err)
+	}
+	out := &bytes.Buffer{}
+	for _, mf := range metricFamily {
+		if _, err := expfmt.MetricFamilyToText(out, mf); err != nil {
+			panic(err)
+		}
+	}
+	if templateMetrics != out.String() {
+		t.Errorf("\nVariables do not match, metrics:\n%s\nwant:\n%s", templateMetrics, out.String())
+	}
+}
@@ -16,7 +16,9 @@ var (
 
 // Set version metric:
 //   - pgbackrest_version_info
-func getBackrestVersionMetrics(setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) {
+//
+// Returns an error if the data was not received from pgBackRest or can't be parsed.
+func getBackrestVersionMetrics(setUpMetricValueFun setUpMetricValueFunType, logger *slog.Logger) error {
 	versionData, err := getVersionData(logger)
 	if err != nil {
 		logger.Error(
@@ -24,11 +26,11 @@ func getBackrestVersionMetrics(setUpMetricValueFun setUpMetricValueFunType, logg
 			"err", err,
 		)
 	}
-	parsedVersionData, err := parseVersionOutput(versionData, logger)
-	if err != nil {
+	parsedVersionData, parseErr := parseVersionOutput(versionData, logger)
+	if parseErr != nil {
 		logger.Error(
 			"Parse version failed",
-			"err", err,
+			"err", parseErr,
 		)
 	}
 	setUpMetric(
@@ -38,6 +40,11 @@ func getBackrestVersionMetrics(setUpMetricValueFun setUpMetricValueFunType, logg
 		setUpMetricValueFun,
 		logger,
 	)
+	// If data was not received, parsing also fails, so the first error is returned.
+	if err != nil {
+		return err
+	}
+	return parseErr
 }
 
 func resetVersionMetrics() {
@@ -0,0 +1,232 @@
+package backrest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Formats of webhook payload.
+const (
+	WebhookFormatGeneric = "generic"
+	WebhookFormatSlack   = "slack"
+)
+
+// Queue size for events batches waiting to be sent.
+// When the queue is full, new events are dropped, so the collection is never blocked.
+const webhookQueueSize = 100
+
+// WebhookConfig contains parameters for sending events to webhooks.
+type WebhookConfig struct {
+	// URLs of webhooks receiving events in generic JSON format.
+	URLs []string
+	// SlackURLs of Slack-compatible incoming webhooks.
+	SlackURLs []string
+	// Retries is the number of retries for failed requests.
+	Retries int
+	// RetryInterval is the interval before the first retry, it's doubled for each next retry.
+	RetryInterval time.Duration
+	// Timeout is the timeout for webhook request.
+	Timeout time.Duration
+	// DedupWindow is the time during which the same event is not sent again.
+	DedupWindow time.Duration
+}
+
+// webhookPayload is the payload in generic format.
+type webhookPayload struct {
+	Events []event `json:"events"`
+}
+
+// slackPayload is the payload for Slack-compatible incoming webhooks.
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+type webhookTarget struct {
+	url    string
+	format string
+}
+
+// webhookNotifier sends events to webhooks in background.
+type webhookNotifier struct {
+	cfg     WebhookConfig
+	targets []webhookTarget
+	client  *http.Client
+	queue   chan []event
+	// Time when the event was sent last time by event key.
+	sent   map[string]time.Time
+	logger *slog.Logger
+}
+
+// SetWebhookConfig enables sending events detected between collections to webhooks.
+// If no webhooks are specified, sending is disabled.
+func SetWebhookConfig(cfg WebhookConfig, logger *slog.Logger) {
+	notifier := newWebhookNotifier(cfg, logger)
+	if len(notifier.targets) == 0 {
+		return
+	}
+	go notifier.run()
+	addEventSink(notifier.enqueue)
+}
+
+func newWebhookNotifier(cfg WebhookConfig, logger *slog.Logger) *webhookNotifier {
+	return &webhookNotifier{
+		cfg:     cfg,
+		targets: getWebhookTargets(cfg),
+		client:  &http.Client{Timeout: cfg.Timeout},
+		queue:   make(chan []event, webhookQueueSize),
+		sent:    make(map[string]time.Time),
+		logger:  logger,
+	}
+}
+
+// getWebhookTargets returns non-empty webhook URLs with payload formats.
+// Empty values come from default values of command-line flags.
+func getWebhookTargets(cfg WebhookConfig) []webhookTarget {
+	var targets []webhookTarget
+	for _, url := range cfg.URLs {
+		if url != "" {
+			targets = append(targets, webhookTarget{url, WebhookFormatGeneric})
+		}
+	}
+	for _, url := range cfg.SlackURLs {
+		if url != "" {
+			targets = append(targets, webhookTarget{url, WebhookFormatSlack})
+		}
+	}
+	return targets
+}
+
+// enqueue adds events to the queue without blocking.
+func (n *webhookNotifier) enqueue(events []event) {
+	select {
+	case n.queue <- events:
+	default:
+		n.logger.Warn("Webhook queue is full, events are dropped", "events", len(events))
+	}
+}
+
+func (n *webhookNotifier) run() {
+	for events := range n.queue {
+		n.notify(context.Background(), events, time.Now())
+	}
+}
+
+// notify sends events that were not sent during dedup window to all webhooks.
+func (n *webhookNotifier) notify(ctx context.Context, events []event, now time.Time) {
+	events = n.dedup(events, now)
+	if len(events) == 0 {
+		return
+	}
+	for _, target := range n.targets {
+		body, err := getWebhookPayload(target.format, events)
+		if err != nil {
+			n.logger.Error("Encode webhook payload failed", "err", err)
+			continue
+		}
+		if err := n.send(ctx, target.url, body); err != nil {
+			// URL can contain secret token, so it's not logged.
+			n.logger.Error("Send events to webhook failed", "format", target.format, "events", len(events), "err", err)
+		}
+	}
+}
+
+// dedup returns events that were not sent during dedup window.
+// Backup progress events are skipped, they are too frequent for webhooks.
+func (n *webhookNotifier) dedup(events []event, now time.Time) []event {
+	for key, sentAt := range n.sent {
+		if now.Sub(sentAt) >= n.cfg.DedupWindow {
+			delete(n.sent, key)
+		}
+	}
+	result := make([]event, 0, len(events))
+	for _, e := range events {
+		if e.Type == eventBackupProgress {
+			continue
+		}
+		key := e.key()
+		if _, ok := n.sent[key]; ok {
+			continue
+		}
+		n.sent[key] = now
+		result = append(result, e)
+	}
+	return result
+}
+
+// send posts payload to webhook with retries.
+// Requests are retried for network errors, 429 and 5xx responses.
+func (n *webhookNotifier) send(ctx context.Context, url string, body []byte) error {
+	interval := n.cfg.RetryInterval
+	var err error
+	for attempt := 0; attempt <= n.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+			interval *= 2
+		}
+		var retry bool
+		retry, err = n.post(ctx, url, body)
+		if err == nil || !retry {
+			return err
+		}
+		n.logger.Debug("Webhook request failed", "attempt", attempt+1, "err", err)
+	}
+	return err
+}
+
+func (n *webhookNotifier) post(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	// Body is read to reuse connection.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected response status %s", resp.Status)
+}
+
+func getWebhookPayload(format string, events []event) ([]byte, error) {
+	switch format {
+	case WebhookFormatSlack:
+		lines := make([]string, 0, len(events))
+		for _, e := range events {
+			lines = append(lines, fmt.Sprintf("[%s] %s: %s", e.Type, e.Stanza, e.Message))
+		}
+		return json.Marshal(slackPayload{Text: "pgBackRest events:\n" + strings.Join(lines, "\n")})
+	default:
+		return json.Marshal(webhookPayload{Events: events})
+	}
+}
+
+// LogWebhookConfig logs WebhookConfig parameters.
+// URLs are not logged, because they can contain secret tokens.
+func LogWebhookConfig(cfg WebhookConfig, logger *slog.Logger) {
+	targets := getWebhookTargets(cfg)
+	if len(targets) == 0 {
+		return
+	}
+	logger.Info(
+		"Sending events to webhooks",
+		"webhooks", len(targets),
+		"retries", cfg.Retries,
+		"dedup-window", cfg.DedupWindow)
+}
@@ -0,0 +1,168 @@
+package backrest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	eventTime := time.Unix(1623706322, 0).UTC()
+	events := []event{
+		{eventBackupNew, eventTime, "demo", 1, "20210607-092423F", "new full backup 20210607-092423F in repo1", nil},
+		{eventStanzaStatusChanged, eventTime, "demo", 0, "", "stanza status changed from 0 (ok) to 2 (no valid backups)", nil},
+	}
+	tests := []struct {
+		name      string
+		format    string
+		statuses  []int
+		retries   int
+		wantCalls int
+		wantBody  string
+	}{
+		{
+			"generic",
+			WebhookFormatGeneric,
+			[]int{http.StatusOK},
+			3,
+			1,
+			`{"events":[` +
+				`{"type":"backup_new","time":"2021-06-14T21:32:02Z","stanza":"demo","repo_key":1,"backup":"20210607-092423F","message":"new full backup 20210607-092423F in repo1"},` +
+				`{"type":"stanza_status_changed","time":"2021-06-14T21:32:02Z","stanza":"demo","message":"stanza status changed from 0 (ok) to 2 (no valid backups)"}]}`,
+		},
+		{
+			"slack",
+			WebhookFormatSlack,
+			[]int{http.StatusOK},
+			3,
+			1,
+			`{"text":"pgBackRest events:\n[backup_new] demo: new full backup 20210607-092423F in repo1\n[stanza_status_changed] demo: stanza status changed from 0 (ok) to 2 (no valid backups)"}`,
+		},
+		{
+			"retryServerError",
+			WebhookFormatGeneric,
+			[]int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusNoContent},
+			3,
+			3,
+			"",
+		},
+		{
+			"retriesExhausted",
+			WebhookFormatGeneric,
+			[]int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			2,
+			3,
+			"",
+		},
+		{
+			"noRetryClientError",
+			WebhookFormatGeneric,
+			[]int{http.StatusBadRequest, http.StatusOK},
+			3,
+			1,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				calls int
+				body  string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				data, _ := io.ReadAll(r.Body)
+				body = string(data)
+				w.WriteHeader(tt.statuses[min(calls, len(tt.statuses)-1)])
+				calls++
+			}))
+			defer srv.Close()
+			cfg := WebhookConfig{
+				Retries:       tt.retries,
+				RetryInterval: time.Millisecond,
+				Timeout:       time.Second,
+				DedupWindow:   time.Hour,
+			}
+			if tt.format == WebhookFormatSlack {
+				cfg.SlackURLs = []string{srv.URL}
+			} else {
+				cfg.URLs = []string{"", srv.URL}
+			}
+			notifier := newWebhookNotifier(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			notifier.notify(context.Background(), events, eventTime)
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != tt.wantCalls {
+				t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", calls, tt.wantCalls)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("\nVariables do not match:\n%s\nwant:\n%s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWebhookDedup(t *testing.T) {
+	eventTime := time.Unix(1623706322, 0)
+	var received [][]event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("\nDecode payload failed: %v", err)
+		}
+		received = append(received, payload.Events)
+	}))
+	defer srv.Close()
+	notifier := newWebhookNotifier(
+		WebhookConfig{URLs: []string{srv.URL}, Timeout: time.Second, DedupWindow: time.Hour},
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+	)
+	statusEvent := event{eventStanzaStatusChanged, eventTime, "demo", 0, "", "stanza status changed from 0 (ok) to 2 (no valid backups)", nil}
+	backupEvent := event{eventBackupNew, eventTime, "demo", 1, "20210607-092423F", "new full backup 20210607-092423F in repo1", nil}
+	progressEvent := event{eventBackupProgress, eventTime, "demo", 0, "", "backup progress 50.0% (1 of 2 bytes)", &eventProgress{1, 2}}
+	// Backup progress events are not sent.
+	notifier.notify(context.Background(), []event{statusEvent, progressEvent}, eventTime)
+	notifier.notify(context.Background(), []event{progressEvent}, eventTime)
+	// Repeated event within dedup window is skipped.
+	notifier.notify(context.Background(), []event{statusEvent, backupEvent}, eventTime.Add(30*time.Minute))
+	notifier.notify(context.Background(), []event{statusEvent}, eventTime.Add(40*time.Minute))
+	// Dedup window is expired.
+	notifier.notify(context.Background(), []event{statusEvent}, eventTime.Add(time.Hour))
+	want := []int{1, 1, 1}
+	var got []int
+	for _, events := range received {
+		got = append(got, len(events))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	if len(received) == 3 && received[1][0].Type != eventBackupNew {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", received[1][0].Type, eventBackupNew)
+	}
+}
+
+func TestGetWebhookTargets(t *testing.T) {
+	cfg := WebhookConfig{
+		URLs:      []string{"", "http://example.com/hook"},
+		SlackURLs: []string{"https://hooks.slack.com/services/T/B/X"},
+	}
+	want := []webhookTarget{
+		{"http://example.com/hook", WebhookFormatGeneric},
+		{"https://hooks.slack.com/services/T/B/X", WebhookFormatSlack},
+	}
+	if got := getWebhookTargets(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, want)
+	}
+	if got := getWebhookTargets(WebhookConfig{URLs: []string{""}}); len(got) != 0 {
+		t.Errorf("\nVariables do not match:\n%v\nwant:\n%v", got, nil)
+	}
+}
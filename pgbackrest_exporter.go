@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -12,6 +14,7 @@ import (
 	kingpin "github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	version_collector "github.com/prometheus/client_golang/prometheus/collectors/version"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
@@ -46,8 +49,20 @@ func main() {
 		).Default("").PlaceHolder("\"\"").Strings()
 		backrestExcludeStanza = kingpin.Flag(
 			"backrest.stanza-exclude",
-			"Specific stanza to exclude from collecting metrics. Can be specified several times.",
+			"Specific stanza or glob pattern, e.g. staging-*, to exclude from collecting metrics. Can be specified several times.",
 		).Default("").PlaceHolder("\"\"").Strings()
+		backrestIncludeStanzaRegex = kingpin.Flag(
+			"backrest.stanza-include-regex",
+			"Regular expression for names of stanzas for collecting metrics, e.g. ^prod-. Applied to stanzas returned by pgBackRest.",
+		).Default("").String()
+		backrestExcludeStanzaRegex = kingpin.Flag(
+			"backrest.stanza-exclude-regex",
+			"Regular expression for names of stanzas to exclude from collecting metrics, e.g. ^staging-. Applied to stanzas returned by pgBackRest.",
+		).Default("").String()
+		backrestStanzaDiscovery = kingpin.Flag(
+			"backrest.stanza-discovery",
+			"Discover stanzas from pgBackRest configuration and collect metrics for each stanza separately. Used when --backrest.stanza-include is not specified.",
+		).Default("false").Bool()
 		backrestBackupType = kingpin.Flag(
 			"backrest.backup-type",
 			"Specific backup type for collecting metrics. One of: [full, incr, diff].",
@@ -72,11 +87,187 @@ func main() {
 			"backrest.verbose-wal",
 			"Exposing additional labels for WAL metrics.",
 		).Default("false").Bool()
+		backrestAnomalyWindow = kingpin.Flag(
+			"backrest.anomaly-window",
+			"Number of previous backups of the same type to compare the latest backup size, delta and duration with. Disabled when 0.",
+		).Default("0").Int()
+		backrestRepoCapacity = kingpin.Flag(
+			"backrest.repo-capacity",
+			"Capacity of repository in repo_key=size format, e.g. 1=500GiB. Can be specified several times.",
+		).PlaceHolder("KEY=SIZE").StringMap()
+		backrestRepoPath = kingpin.Flag(
+			"backrest.repo-path",
+			"Path to posix repository in repo_key=path format for getting capacity and used space from filesystem, e.g. 1=/var/lib/pgbackrest. Can be specified several times.",
+		).PlaceHolder("KEY=PATH").StringMap()
+		backrestSpoolPath = kingpin.Flag(
+			"backrest.spool-path",
+			"Path to pgBackRest spool directory. When empty, spool-path option from pgBackRest configuration or default path is used.",
+		).Default("").String()
+		backrestLogPath = kingpin.Flag(
+			"backrest.log-path",
+			"Path to pgBackRest log files. When empty, log-path option from pgBackRest configuration or default path is used.",
+		).Default("").String()
+		collectCheckInterval = kingpin.Flag(
+			"collect.check-interval",
+			"Interval of running pgbackrest check command for stanzas, e.g. 1h. Disabled when 0.",
+		).Default("0s").Duration()
+		collectVerifyInterval = kingpin.Flag(
+			"collect.verify-interval",
+			"Minimum interval of running pgbackrest verify command for stanzas, e.g. 24h. Disabled when 0.",
+		).Default("0s").Duration()
+		collectVerifyWindow = kingpin.Flag(
+			"collect.verify-window",
+			"Time of day when pgbackrest verify command can be started in HH:MM-HH:MM format, e.g. 01:00-05:00. When empty, any time.",
+		).Default("").String()
+		collectRepoStorageInterval = kingpin.Flag(
+			"collect.repo-storage-interval",
+			"Interval of listing files of stanzas in repositories via pgbackrest repo-ls command, e.g. 6h. Disabled when 0.",
+		).Default("0s").Duration()
+		collectorSpool = kingpin.Flag(
+			"collector.spool",
+			"Enable collecting metrics for spool queue of asynchronous archiving.",
+		).Default("false").Bool()
+		collectorLog = kingpin.Flag(
+			"collector.log",
+			"Enable collecting metrics from pgBackRest log files.",
+		).Default("false").Bool()
+		collectorConfig = kingpin.Flag(
+			"collector.config",
+			"Enable collecting settings of stanzas and repositories from pgBackRest configuration and checking retention of backups.",
+		).Default("false").Bool()
 		collectorBackrest = kingpin.Flag(
 			"collector.pgbackrest",
 			"Enable pgBackRest collector. When disabled, only pgBackRest version and exporter build info are collected.",
 		).Default("true").Bool()
+		historyFile = kingpin.Flag(
+			"history.file",
+			"Full path to file for recording the history of backups in JSON lines format. When empty, history is disabled.",
+		).Default("").String()
+		historyRetention = kingpin.Flag(
+			"history.retention",
+			"Time after which backups not seen in pgBackRest output are removed from the history. When 0, backups are kept forever.",
+		).Default("2160h").Duration()
+		collectOnce = kingpin.Flag(
+			"once",
+			"Collect metrics once, write them to file specified by --output.textfile and exit.",
+		).Default("false").Bool()
+		outputTextfile = kingpin.Flag(
+			"output.textfile",
+			"Full path to file for writing metrics in Prometheus text format (node_exporter textfile collector). Used with --once.",
+		).Default("").String()
+		otlpEndpoint = kingpin.Flag(
+			"otlp.endpoint",
+			"URL of OTLP receiver for exporting metrics via OpenTelemetry protocol, e.g. http://otel-collector:4318/v1/metrics. When empty, export is disabled.",
+		).Default("").String()
+		otlpHeaders = kingpin.Flag(
+			"otlp.header",
+			"Header for OTLP export requests in key=value format. Can be specified several times.",
+		).PlaceHolder("KEY=VALUE").StringMap()
+		otlpResourceAttributes = kingpin.Flag(
+			"otlp.resource-attribute",
+			"Resource attribute for exported metrics in key=value format, e.g. cluster=main. Can be specified several times. By default, host.name is set to hostname.",
+		).PlaceHolder("KEY=VALUE").StringMap()
+		otlpTimeout = kingpin.Flag(
+			"otlp.timeout",
+			"Timeout for OTLP export request.",
+		).Default("30s").Duration()
+		eventsWALStoppedAfter = kingpin.Flag(
+			"events.wal-stopped-after",
+			"Time after which WAL archiving is considered stopped if no new WAL segments are archived. Disabled when 0.",
+		).Default("1h").Duration()
+		webhookURLs = kingpin.Flag(
+			"webhook.url",
+			"URL of webhook receiving events in generic JSON format. Can be specified several times.",
+		).Default("").PlaceHolder("\"\"").Strings()
+		webhookSlackURLs = kingpin.Flag(
+			"webhook.slack-url",
+			"URL of Slack-compatible incoming webhook receiving events. Can be specified several times.",
+		).Default("").PlaceHolder("\"\"").Strings()
+		webhookRetries = kingpin.Flag(
+			"webhook.retries",
+			"Number of retries for failed webhook requests.",
+		).Default("3").Int()
+		webhookTimeout = kingpin.Flag(
+			"webhook.timeout",
+			"Timeout for webhook request.",
+		).Default("10s").Duration()
+		webhookDedupWindow = kingpin.Flag(
+			"webhook.dedup-window",
+			"Time during which the same event is not sent again.",
+		).Default("1h").Duration()
+		// By default, exporter runs web server and collects metrics periodically.
+		_       = kingpin.Command("serve", "Run web server and collect metrics periodically.").Default()
+		pushCmd = kingpin.Command("push", "Collect metrics for stanza once and push them to Prometheus Pushgateway.")
+		pushURL = pushCmd.Flag(
+			"push.url",
+			"Pushgateway URL, e.g. http://pushgateway:9091.",
+		).Required().String()
+		pushStanza = pushCmd.Flag(
+			"stanza",
+			"Stanza for collecting metrics.",
+		).Required().String()
+		pushJob = pushCmd.Flag(
+			"push.job",
+			"Value of job label.",
+		).Default(exporterName).String()
+		pushInstance = pushCmd.Flag(
+			"push.instance",
+			"Value of instance label in grouping key. Default is hostname.",
+		).Default("").String()
+		pushConfigFile = pushCmd.Flag(
+			"push.config.file",
+			"Path to HTTP client configuration file that can enable TLS or basic authentication for Pushgateway.",
+		).Default("").String()
+		pushTimeout = pushCmd.Flag(
+			"push.timeout",
+			"Timeout for push request.",
+		).Default("30s").Duration()
+		checkCmd    = kingpin.Command("check", "Check stanza once and exit with Nagios plugin exit code: 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN).")
+		checkStanza = checkCmd.Flag(
+			"stanza",
+			"Stanza for checking.",
+		).Required().String()
+		checkMaxFullAge  model.Duration
+		checkMaxDiffAge  model.Duration
+		checkMaxIncrAge  model.Duration
+		checkMaxWALAge   model.Duration
+		checkWarnFullAge model.Duration
+		checkWarnDiffAge model.Duration
+		checkWarnIncrAge model.Duration
+		checkWarnWALAge  model.Duration
 	)
+	checkCmd.Flag(
+		"max-full-age",
+		"Maximum time since the last completed full backup, e.g. 8d. Disabled when 0.",
+	).Default("0s").SetValue(&checkMaxFullAge)
+	checkCmd.Flag(
+		"max-diff-age",
+		"Maximum time since the last completed full or differential backup, e.g. 2d. Disabled when 0.",
+	).Default("0s").SetValue(&checkMaxDiffAge)
+	checkCmd.Flag(
+		"max-incr-age",
+		"Maximum time since the last completed backup of any type, e.g. 26h. Disabled when 0.",
+	).Default("0s").SetValue(&checkMaxIncrAge)
+	checkCmd.Flag(
+		"max-wal-age",
+		"Maximum time since the last WAL segment was archived, e.g. 10m. Disabled when 0.",
+	).Default("0s").SetValue(&checkMaxWALAge)
+	checkCmd.Flag(
+		"warning-full-age",
+		"Time since the last completed full backup for WARNING status, e.g. 7d. Disabled when 0.",
+	).Default("0s").SetValue(&checkWarnFullAge)
+	checkCmd.Flag(
+		"warning-diff-age",
+		"Time since the last completed full or differential backup for WARNING status, e.g. 1d. Disabled when 0.",
+	).Default("0s").SetValue(&checkWarnDiffAge)
+	checkCmd.Flag(
+		"warning-incr-age",
+		"Time since the last completed backup of any type for WARNING status, e.g. 25h. Disabled when 0.",
+	).Default("0s").SetValue(&checkWarnIncrAge)
+	checkCmd.Flag(
+		"warning-wal-age",
+		"Time since the last WAL segment was archived for WARNING status, e.g. 5m. Disabled when 0.",
+	).Default("0s").SetValue(&checkWarnWALAge)
 	// Set logger config.
 	promslogConfig := &promslog.Config{}
 	// Add flags log.level and log.format from promlog package.
@@ -85,22 +276,30 @@ func main() {
 	// Add short help flag.
 	kingpin.HelpFlag.Short('h')
 	// Load command line arguments.
-	kingpin.Parse()
-	// Setup signal catching.
-	sigs := make(chan os.Signal, 1)
-	// Catch  listed signals.
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	command := kingpin.Parse()
+	if *collectOnce && *outputTextfile == "" {
+		kingpin.Fatalf("--output.textfile is required with --once")
+	}
 	// Set logger.
 	logger := promslog.New(promslogConfig)
-	// Method invoked upon seeing signal.
-	go func(logger *slog.Logger) {
-		s := <-sigs
-		logger.Warn(
-			"Stopping exporter",
-			"name", filepath.Base(os.Args[0]),
-			"signal", s)
-		os.Exit(1)
-	}(logger)
+	// Check command prints one-line result to stdout, logs are written to stderr.
+	if command == checkCmd.FullCommand() {
+		result := backrest.RunCheck(backrest.CheckConfig{
+			Config:            *backrestCustomConfig,
+			ConfigIncludePath: *backrestCustomConfigIncludePath,
+			Stanza:            *checkStanza,
+			MaxFullAge:        time.Duration(checkMaxFullAge),
+			MaxDiffAge:        time.Duration(checkMaxDiffAge),
+			MaxIncrAge:        time.Duration(checkMaxIncrAge),
+			MaxWALAge:         time.Duration(checkMaxWALAge),
+			WarnFullAge:       time.Duration(checkWarnFullAge),
+			WarnDiffAge:       time.Duration(checkWarnDiffAge),
+			WarnIncrAge:       time.Duration(checkWarnIncrAge),
+			WarnWALAge:        time.Duration(checkWarnWALAge),
+		}, logger)
+		fmt.Println(result)
+		os.Exit(result.Code)
+	}
 	logger.Info(
 		"Starting exporter",
 		"name", filepath.Base(os.Args[0]),
@@ -120,6 +319,8 @@ func main() {
 		VerboseWAL:                     *backrestVerboseWAL,
 		ResetMetricsAfter:              resetMetricsAfterFetch,
 		BackupDBCountParallelProcesses: *backrestBackupDBCountParallelProcesses,
+		AnomalyWindow:                  *backrestAnomalyWindow,
+		DiscoverStanza:                 *backrestStanzaDiscovery,
 	}
 	// Setup parameters for exporter.
 	backrest.SetPromPortAndPath(*webAdditionalToolkitFlags, *webPath)
@@ -136,10 +337,171 @@ func main() {
 	if *collectorBackrest {
 		backrest.LogBackrestExporterConfig(backrestExporterConfig, logger)
 	}
+	if err := backrest.SetStanzaRegexpFilters(*backrestIncludeStanzaRegex, *backrestExcludeStanzaRegex); err != nil {
+		kingpin.Fatalf("invalid stanza regular expression: %v", err)
+	}
+	if err := backrest.SetRepoForecastConfig(*backrestRepoCapacity, *backrestRepoPath); err != nil {
+		kingpin.Fatalf("invalid repository forecast parameters: %v", err)
+	}
+	if *collectorSpool {
+		path, err := backrest.SetSpoolPath(*backrestSpoolPath, *backrestCustomConfig, *backrestCustomConfigIncludePath)
+		if err != nil {
+			kingpin.Fatalf("invalid spool parameters: %v", err)
+		}
+		logger.Info("Collecting spool metrics", "path", path)
+	}
+	if *collectorConfig {
+		if err := backrest.SetConfigMetrics(*backrestCustomConfig, *backrestCustomConfigIncludePath); err != nil {
+			kingpin.Fatalf("invalid pgBackRest configuration: %v", err)
+		}
+		logger.Info("Collecting pgBackRest configuration metrics")
+	}
+	if *collectorLog {
+		path, err := backrest.SetLogPath(*backrestLogPath, *backrestCustomConfig, *backrestCustomConfigIncludePath)
+		if err != nil {
+			kingpin.Fatalf("invalid log parameters: %v", err)
+		}
+		logger.Info("Collecting log metrics", "path", path)
+	}
+	// History is loaded once and updated after each collection in all modes.
+	if *historyFile != "" {
+		if err := backrest.SetBackupHistoryFile(*historyFile, *historyRetention); err != nil {
+			logger.Error("Load backup history failed", "file", *historyFile, "err", err)
+			os.Exit(1)
+		}
+		logger.Info("Recording backup history", "file", *historyFile, "retention", *historyRetention)
+	}
+	// OTLP export works alongside metrics endpoint, Pushgateway and textfile.
+	var otlpExporter *backrest.OTLPExporter
+	if *otlpEndpoint != "" {
+		otlpConfig := backrest.OTLPConfig{
+			Endpoint:           *otlpEndpoint,
+			Headers:            *otlpHeaders,
+			ResourceAttributes: *otlpResourceAttributes,
+			Timeout:            *otlpTimeout,
+		}
+		if _, ok := otlpConfig.ResourceAttributes["host.name"]; !ok {
+			if hostname, err := os.Hostname(); err == nil {
+				otlpConfig.ResourceAttributes["host.name"] = hostname
+			}
+		}
+		var err error
+		otlpExporter, err = backrest.NewOTLPExporter(otlpConfig)
+		if err != nil {
+			logger.Error("Create OTLP exporter failed", "err", err)
+			os.Exit(1)
+		}
+		logger.Info(
+			"Use OTLP parameters",
+			"endpoint", otlpConfig.Endpoint,
+			"resource-attributes", otlpConfig.ResourceAttributes,
+		)
+	}
+	// Setup signal catching.
+	sigs := make(chan os.Signal, 1)
+	// Catch  listed signals.
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	// Method invoked upon seeing signal.
+	go func(logger *slog.Logger) {
+		s := <-sigs
+		logger.Warn(
+			"Stopping exporter",
+			"name", filepath.Base(os.Args[0]),
+			"signal", s)
+		shutdownOTLPExporter(otlpExporter, *otlpTimeout, logger)
+		os.Exit(1)
+	}(logger)
 	// Exporter build info metric
 	prometheus.MustRegister(version_collector.NewCollector(exporterName))
+	// In push mode metrics for stanza are collected once and pushed to Pushgateway, web server is not started.
+	if command == pushCmd.FullCommand() {
+		if *pushInstance == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				logger.Error("Get hostname failed", "err", err)
+				shutdownOTLPExporter(otlpExporter, *otlpTimeout, logger)
+				os.Exit(1)
+			}
+			*pushInstance = hostname
+		}
+		pushConfig := backrest.PushConfig{
+			URL:            *pushURL,
+			Job:            *pushJob,
+			Instance:       *pushInstance,
+			Stanza:         *pushStanza,
+			HTTPConfigFile: *pushConfigFile,
+			Timeout:        *pushTimeout,
+		}
+		logger.Info(
+			"Use push parameters",
+			"url", pushConfig.URL,
+			"job", pushConfig.Job,
+			"instance", pushConfig.Instance,
+			"stanza", pushConfig.Stanza,
+		)
+		backrestExporterConfig.IncludeStanza = []string{pushConfig.Stanza}
+		backrestExporterConfig.ResetMetricsAfter = false
+		exitCode := runOnce(backrestExporterConfig, *collectorBackrest, "pushgateway", func() error {
+			return backrest.PushMetrics(pushConfig)
+		}, otlpExporter, logger)
+		shutdownOTLPExporter(otlpExporter, *otlpTimeout, logger)
+		os.Exit(exitCode)
+	}
+	// In one-shot mode metrics are collected once and written to textfile, web server is not started.
+	if *collectOnce {
+		exitCode := runOnce(backrestExporterConfig, *collectorBackrest, "textfile", func() error {
+			return backrest.WriteTextfile(*outputTextfile)
+		}, otlpExporter, logger)
+		shutdownOTLPExporter(otlpExporter, *otlpTimeout, logger)
+		os.Exit(exitCode)
+	}
+	if *outputTextfile != "" {
+		logger.Warn("Flag --output.textfile is ignored without --once", "file", *outputTextfile)
+	}
+	// Events are detected between collections for events endpoint and webhooks.
+	logger.Info("Events config", "wal-stopped-after", *eventsWALStoppedAfter)
+	backrest.SetWALStoppedAfter(*eventsWALStoppedAfter)
+	webhookConfig := backrest.WebhookConfig{
+		URLs:          *webhookURLs,
+		SlackURLs:     *webhookSlackURLs,
+		Retries:       *webhookRetries,
+		RetryInterval: time.Second,
+		Timeout:       *webhookTimeout,
+		DedupWindow:   *webhookDedupWindow,
+	}
+	backrest.LogWebhookConfig(webhookConfig, logger)
+	backrest.SetWebhookConfig(webhookConfig, logger)
 	// Start web server.
 	backrest.StartPromEndpoint(version.Info(), logger)
+	// Check command is run on its own schedule, it can take longer than getting info.
+	if *collectorBackrest {
+		backrest.StartCheckProbe(backrest.CheckProbeConfig{
+			Config:            *backrestCustomConfig,
+			ConfigIncludePath: *backrestCustomConfigIncludePath,
+			IncludeStanza:     *backrestIncludeStanza,
+			ExcludeStanza:     *backrestExcludeStanza,
+			Interval:          *collectCheckInterval,
+		}, logger)
+		// Verify reads all files in repository, so it's run off-peak.
+		if err := backrest.StartVerifyProbe(backrest.VerifyProbeConfig{
+			Config:            *backrestCustomConfig,
+			ConfigIncludePath: *backrestCustomConfigIncludePath,
+			IncludeStanza:     *backrestIncludeStanza,
+			ExcludeStanza:     *backrestExcludeStanza,
+			Interval:          *collectVerifyInterval,
+			Window:            *collectVerifyWindow,
+		}, logger); err != nil {
+			shutdownOTLPExporter(otlpExporter, *otlpTimeout, logger)
+			kingpin.Fatalf("invalid verify parameters: %v", err)
+		}
+		backrest.StartRepoStorageProbe(backrest.RepoStorageProbeConfig{
+			Config:            *backrestCustomConfig,
+			ConfigIncludePath: *backrestCustomConfigIncludePath,
+			IncludeStanza:     *backrestIncludeStanza,
+			ExcludeStanza:     *backrestExcludeStanza,
+			Interval:          *collectRepoStorageInterval,
+		}, logger)
+	}
 	for {
 		// Get pgBackRest version info and set metric.
 		backrest.GetPgBackrestVersionInfo(logger)
@@ -147,7 +509,62 @@ func main() {
 		if *collectorBackrest {
 			backrest.GetPgBackRestInfo(backrestExporterConfig, logger)
 		}
+		// Export the same metric values via OTLP.
+		exportOTLPMetrics(otlpExporter, logger)
 		// Sleep for 'collection.interval' seconds.
 		time.Sleep(time.Duration(*collectionInterval) * time.Second)
 	}
 }
+
+// exportOTLPMetrics exports current values of metrics via OTLP, if it's enabled.
+// Returns false if export failed.
+func exportOTLPMetrics(otlpExporter *backrest.OTLPExporter, logger *slog.Logger) bool {
+	if otlpExporter == nil {
+		return true
+	}
+	if err := otlpExporter.Export(context.Background()); err != nil {
+		logger.Error("Export metrics via OTLP failed", "err", err)
+		return false
+	}
+	return true
+}
+
+// shutdownOTLPExporter flushes and closes OTLP exporter before exit, if it's enabled.
+func shutdownOTLPExporter(otlpExporter *backrest.OTLPExporter, timeout time.Duration, logger *slog.Logger) {
+	if otlpExporter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := otlpExporter.Shutdown(ctx); err != nil {
+		logger.Error("Shutdown OTLP exporter failed", "err", err)
+	}
+}
+
+// runOnce collects metrics once and outputs them (writes to textfile or pushes to Pushgateway).
+// Metrics are output even if collection failed, because they contain the exporter status.
+// The same metric values are exported via OTLP, if it's enabled.
+// Returns exit code: 0 on success, 1 if collection or output failed.
+func runOnce(backrestExporterConfig backrest.BackrestExporterConfig, collectorBackrest bool, output string, outputFunc func() error, otlpExporter *backrest.OTLPExporter, logger *slog.Logger) int {
+	exitCode := 0
+	if err := backrest.GetPgBackrestVersionInfo(logger); err != nil {
+		exitCode = 1
+	}
+	if collectorBackrest {
+		if err := backrest.GetPgBackRestInfo(backrestExporterConfig, logger); err != nil {
+			exitCode = 1
+		}
+	}
+	if !exportOTLPMetrics(otlpExporter, logger) {
+		exitCode = 1
+	}
+	if err := outputFunc(); err != nil {
+		logger.Error("Output metrics failed", "output", output, "err", err)
+		return 1
+	}
+	logger.Info("Metrics output completed", "output", output)
+	if exitCode != 0 {
+		logger.Error("Collecting metrics failed")
+	}
+	return exitCode
+}